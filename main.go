@@ -2,34 +2,249 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"cdk-deployer/pkg/alert"
+	"cdk-deployer/pkg/assembly"
 	"cdk-deployer/pkg/cdk"
+	"cdk-deployer/pkg/config"
+	"cdk-deployer/pkg/event"
 	"cdk-deployer/pkg/git"
+	"cdk-deployer/pkg/history"
+	"cdk-deployer/pkg/lock"
+	"cdk-deployer/pkg/logging"
+	"cdk-deployer/pkg/server"
+	"cdk-deployer/pkg/source"
+	"cdk-deployer/pkg/watch"
 )
 
 func main() {
 	// Define CLI flags
 	repoURL := flag.String("repo", "", "Public Git repository URL to clone")
-	command := flag.String("cmd", "deploy", "CDK command to run: synth, deploy, or drift")
-	stackName := flag.String("stack", "", "Stack name for drift detection (optional, uses synth to discover stacks if not provided)")
+	ref := flag.String("ref", "", "Branch, tag or commit to check out (default: the default branch)")
+	sourceSpec := flag.String("source", "", "Source of the CDK app instead of -repo: git+<url>, dir:<path> or archive:<path or URL>[#sha256=<hex>]")
+	inPlace := flag.Bool("in-place", false, "Use a dir: source as is instead of copying it")
+	noSubmodules := flag.Bool("no-submodules", false, "Do not check out the submodules of the repository")
+	noLFS := flag.Bool("no-lfs", false, "Do not fetch Git LFS objects, leaving their pointer files in place")
+	subpath := flag.String("subpath", "", "Directory of the CDK app within the repository")
+	command := flag.String("cmd", "deploy", "CDK command to run: synth, list, deploy, import, drift, rollback, context, unlock, history, drift-watch, or serve")
+	stackName := flag.String("stack", "", "Stack name for drift detection, import, rollback, unlock or history (optional, uses synth to discover stacks if not provided)")
+	stacks := flag.String("stacks", "", "Comma-separated stack names or glob patterns (e.g. Prod/*) to act on; their dependencies are included")
+	exclude := flag.String("exclude", "", "Comma-separated stack names or glob patterns to leave out")
+	exclusively := flag.Bool("exclusively", false, "Only act on the stacks given in -stacks, without their dependencies")
+	contextValues := contextFlag{}
+	flag.Var(contextValues, "context", "CDK context value as key=value passed to synth (repeatable)")
+	contextFile := flag.String("context-file", "", "File seeding cdk.context.json before synthesis")
+	contextCache := flag.String("context-cache", "", "File keeping cdk.context.json lookups between runs")
+	contextReset := flag.String("context-reset", "", "Context key or glob pattern to remove from the cache with -cmd context")
+	contextClear := flag.Bool("context-clear", false, "Remove every value from the cache with -cmd context")
 	cleanup := flag.Bool("cleanup", true, "Clean up cloned repository after operation")
 	destDir := flag.String("dest", "", "Destination directory for cloning (default: temp directory)")
+	remediate := flag.Bool("remediate", false, "Offer to remediate drifted resources after drift detection")
+	remediationStrategy := flag.String("remediation-strategy", "", "Remediation strategy for all drifted resources: reapply, import, plan or skip (default: ask per resource)")
+	remediationDir := flag.String("remediation-dir", ".", "Directory for drift override files and remediation plans")
+	driftIgnore := flag.String("drift-ignore", "", "Drift ignore file with suppression rules (JSON)")
+	driftBaseline := flag.String("drift-baseline", "", "Drift baseline file; only drift not in the baseline is reported")
+	recordBaseline := flag.Bool("record-baseline", false, "Record the detected drift as the new baseline in -drift-baseline")
+	importMapping := flag.String("import-mapping", "", "Resource import mapping file (JSON: stack -> logical ID -> identifier properties)")
+	watchConfig := flag.String("watch-config", "", "Drift watch configuration file (JSON)")
+	interval := flag.Duration("interval", 0, "Interval between drift checks for drift-watch (overrides the watch config)")
+	regions := flag.String("regions", "", "Comma-separated regions to watch for drift-watch (used with -stack)")
+	watchState := flag.String("watch-state", "", "State file for drift-watch (overrides the watch config)")
+	alertWebhook := flag.String("alert-webhook", "", "Webhook URL receiving drift-watch alerts as JSON")
+	alertFile := flag.String("alert-file", "", "File drift-watch alerts are appended to as JSON lines")
+	continueRollback := flag.Bool("continue-rollback", false, "Continue rollbacks stuck in UPDATE_ROLLBACK_FAILED without asking")
+	skipResources := flag.String("skip-resources", "", "Comma-separated logical IDs to skip when continuing a failed rollback")
+	recreateFailed := flag.Bool("recreate-failed", false, "Delete and recreate stacks left in ROLLBACK_COMPLETE by a failed create or in REVIEW_IN_PROGRESS by an unexecuted change set")
+	configFile := flag.String("config", "", "Configuration file (YAML, default: "+config.FileName+" in the current directory or repo root)")
+	env := flag.String("env", "", "Environment overlay from the configuration file (e.g. dev, staging, prod)")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration and exit")
+	concurrency := flag.Int("concurrency", 0, "Number of stacks to deploy in parallel (default 1)")
+	approval := flag.String("approval", "", "Approval policy for deploys: never, always, destructive or preview (default never)")
+	synthMode := flag.String("synth-mode", "", "How to synthesize: native runs the app directly, cli uses the CDK CLI, auto performs missing context lookups with the CLI (default cli)")
+	synthTimeout := flag.Duration("synth-timeout", 0, "Timeout for synthesis (default: none)")
+	stackTimeout := flag.Duration("stack-timeout", 0, "Timeout for a single stack operation (default 30m)")
+	driftTimeout := flag.Duration("drift-timeout", 0, "Timeout for drift detection of a single stack (default 10m)")
+	cancelOnInterrupt := flag.Bool("cancel-on-interrupt", true, "Cancel in-progress stack updates when interrupted")
+	listen := flag.String("listen", ":8080", "Address the API server listens on for serve")
+	dataDir := flag.String("data-dir", "cdk-deployer-data", "Directory holding the job database and job logs for serve")
+	workers := flag.Int("workers", 2, "Number of jobs run in parallel for serve")
+	apiTokens := flag.String("api-tokens", "", "Comma-separated bearer tokens accepted by serve (also read from "+apiTokensEnv+")")
+	apiTokenFile := flag.String("api-token-file", "", "File with one bearer token per line accepted by serve")
+	webhookConfig := flag.String("webhook-config", "", "Webhook configuration file (JSON) enabling push-to-deploy for serve")
+	lockBackend := flag.String("lock", "", "Where per-stack deployment locks are kept: none, file or dynamodb (default file)")
+	lockDir := flag.String("lock-dir", "", "Directory of the file lock backend (default: cdk-deployer/locks in the user cache directory)")
+	lockTable := flag.String("lock-table", "", "DynamoDB table of the dynamodb lock backend")
+	lockEndpoint := flag.String("lock-endpoint", "", "DynamoDB endpoint override, e.g. http://localhost:8000 for a local stand-in")
+	lockTTL := flag.Duration("lock-ttl", 0, "Lease of a stack lock, renewed while the operation runs (default 5m)")
+	lockWait := flag.Duration("lock-wait", 0, "How long to wait for a stack locked by another run (default: fail at once)")
+	gitCache := flag.String("git-cache", "", "Directory keeping clones of repositories between runs (default: clone from scratch)")
+	gitCacheMaxSize := flag.String("git-cache-max-size", "", "Evict the least recently used repositories beyond this clone cache size, e.g. 10GB")
+	gitCacheMaxAge := flag.Duration("git-cache-max-age", 0, "Evict repositories not used for this long from the clone cache")
+	verifyGPGKeyring := flag.String("verify-gpg-keyring", "", "Only deploy commits or tags signed by a key in this GPG keyring")
+	verifyAllowedSigners := flag.String("verify-allowed-signers", "", "Only deploy commits or tags signed by a key in this SSH allowed signers file")
+	allowRepos := flag.String("allow-repos", "", "Comma-separated hosts, host/org or host/org/repo patterns repositories may be cloned from (default: any host)")
+	allowLocalRepos := flag.Bool("allow-local-repos", false, "Allow cloning file:// URLs and local paths")
+	sandboxEnv := flag.String("sandbox-env", "", "Comma-separated further environment variables (NAME or PREFIX*) passed to installs and synthesis")
+	sandboxLookups := flag.Bool("sandbox-lookups", false, "Perform missing context lookups with the CDK CLI, which alone receives the AWS credentials")
+	sandboxIsolation := flag.String("sandbox-isolation", "", "Isolate installs and synthesis: none, namespaces (Linux) or bwrap (default none)")
+	sandboxNetwork := flag.String("sandbox-network", "", "Network access of synthesis: allow or none, which needs isolation (default allow)")
+	sandboxSeccomp := flag.Bool("sandbox-seccomp", false, "Block system calls such as ptrace, mount and bpf under bwrap isolation")
+	sandboxCPUTime := flag.Duration("sandbox-cpu-time", 0, "CPU time limit of each install and synthesis command (default: none)")
+	sandboxMemory := flag.String("sandbox-memory", "", "Address space limit of each install and synthesis command, e.g. 4GB (default: none)")
+	sandboxTimeout := flag.Duration("sandbox-timeout", 0, "Wall-clock limit of each install and synthesis command (default: none)")
+	archive := flag.String("archive", "", "Directory or s3://bucket/prefix synthesized cloud assemblies are archived to")
+	assemblyFlag := flag.String("assembly", "", "Deploy a cloud assembly directory, tarball (path, s3:// or https:// URL) or the one archived for -repo at -ref, instead of synthesizing")
+	historyFile := flag.String("history-file", "", "JSON lines file every run is recorded to, or none (default ~/.cdk-deployer/history.jsonl)")
+	since := flag.String("since", "", "Only show history from this time on, as RFC 3339, a date or a duration ago (e.g. 24h)")
+	until := flag.String("until", "", "Only show history up to this time, as RFC 3339, a date or a duration ago")
+	limit := flag.Int("limit", 20, "Maximum number of history records to show (0 for all)")
+	rollbackTo := flag.String("to", "", "Commit SHA, or number of known-good deploys to go back, for rollback (default 1)")
+	historyJSON := flag.Bool("history-json", false, "Print history records as JSON lines instead of a table")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
 
 	flag.Parse()
 
-	if *repoURL == "" {
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	logger, err := logging.New(os.Stderr, logging.Format(*logFormat), level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	if *command == "drift-watch" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		watchFlags := watchFlags{
+			configFile: *watchConfig,
+			interval:   *interval,
+			regions:    *regions,
+			stacks:     *stackName,
+			stateFile:  *watchState,
+			ignoreFile: *driftIgnore,
+			baseline:   *driftBaseline,
+			webhook:    *alertWebhook,
+			alertFile:  *alertFile,
+			logger:     logger,
+		}
+		if err := runDriftWatch(ctx, watchFlags); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sandboxSettings := config.SandboxSettings{
+		Sandbox: cdk.Sandbox{
+			Env:       splitList(*sandboxEnv),
+			Lookups:   *sandboxLookups,
+			Isolation: cdk.Isolation(*sandboxIsolation),
+			Network:   cdk.Network(*sandboxNetwork),
+			Seccomp:   *sandboxSeccomp,
+			CPUTime:   *sandboxCPUTime,
+			Timeout:   *sandboxTimeout,
+		},
+		Memory: *sandboxMemory,
+	}
+
+	if *command == "serve" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		serveFlags := serveFlags{
+			listen:    *listen,
+			dataDir:   *dataDir,
+			workers:   *workers,
+			tokens:    *apiTokens,
+			tokenFile: *apiTokenFile,
+			webhooks:  *webhookConfig,
+			history:   *historyFile,
+			gitCache: config.GitCacheSettings{
+				Dir:     *gitCache,
+				MaxSize: *gitCacheMaxSize,
+				MaxAge:  *gitCacheMaxAge,
+			},
+			noSubmodules: *noSubmodules,
+			noLFS:        *noLFS,
+			verify: config.VerifySettings{
+				GPGKeyring:     *verifyGPGKeyring,
+				AllowedSigners: *verifyAllowedSigners,
+			},
+			repos: config.RepoSettings{
+				Allow:      splitList(*allowRepos),
+				AllowLocal: *allowLocalRepos,
+			},
+			sandbox: sandboxSettings,
+			lock: config.LockSettings{
+				Backend:     *lockBackend,
+				Dir:         *lockDir,
+				Table:       *lockTable,
+				Endpoint:    *lockEndpoint,
+				LockOptions: cdk.LockOptions{TTL: *lockTTL, Wait: *lockWait},
+			},
+			logger: logger,
+		}
+		if err := runServe(ctx, serveFlags); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *configFile == "" {
+		*configFile = config.Discover(".")
+	}
+
+	if *repoURL == "" && *sourceSpec == "" && *configFile == "" && *command != "context" && *command != "unlock" && *command != "history" && *command != "rollback" && *assemblyFlag == "" {
 		fmt.Println("Usage: cdk-deployer -repo <git-url> [-cmd synth|deploy|drift] [-cleanup=true|false] [-dest <dir>]")
+		fmt.Println("       cdk-deployer [-config cdk-deployer.yaml] [-env <name>] [-cmd synth|deploy|drift]")
+		fmt.Println("       cdk-deployer -cmd drift-watch [-watch-config <file>] [-interval <duration>]")
+		fmt.Println("       cdk-deployer -cmd serve -api-token-file <file> [-listen :8080] [-data-dir <dir>]")
 		fmt.Println("\nExamples:")
 		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git")
 		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -cmd synth")
 		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -cmd deploy -cleanup=false")
+		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -ref v1.2.0 -subpath infra")
+		fmt.Println("  cdk-deployer -source dir:. -in-place -cmd synth")
+		fmt.Println("  cdk-deployer -source https://artifacts.example.com/app-1.2.0.tar.gz#sha256=<hex>")
+		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -cmd list")
+		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -stacks 'Prod/*' -exclusively")
 		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -cmd drift")
 		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -cmd drift -stack MyStack")
+		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -cmd drift -remediate")
+		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -cmd import -import-mapping import.json")
+		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -context stage=prod -context-cache prod.context.json")
+		fmt.Println("  cdk-deployer -cmd context -context-cache prod.context.json -context-reset 'vpc-provider:*'")
+		fmt.Println("  cdk-deployer -repo https://github.com/user/cdk-project.git -cmd synth -archive s3://bucket/assemblies")
+		fmt.Println("  cdk-deployer -cmd deploy -assembly s3://bucket/assemblies/objects/<hash>.tar.gz")
+		fmt.Println("  cdk-deployer -cmd deploy -assembly ./cdk.out")
+		fmt.Println("  cdk-deployer -cmd rollback -stack ApiStack -to 2")
+		fmt.Println("  cdk-deployer -cmd history -stack 'Prod/*' -since 168h")
+		fmt.Println("  cdk-deployer -cmd unlock -stack MyStack -lock dynamodb -lock-table cdk-deployer-locks")
+		fmt.Println("  cdk-deployer -config cdk-deployer.yaml -env prod -print-config")
+		fmt.Println("  cdk-deployer -cmd drift-watch -interval 1h -regions us-east-1,eu-west-1 -stack StackA,StackB")
+		fmt.Println("  cdk-deployer -cmd drift-watch -watch-config drift-watch.json")
+		fmt.Println("  cdk-deployer -cmd serve -listen :8080 -workers 4 -api-token-file tokens.txt")
 		os.Exit(1)
 	}
 
@@ -42,64 +257,387 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		fmt.Println("\nReceived interrupt signal, cleaning up...")
+		logger.Warn("Received interrupt signal, cleaning up")
 		cancel()
 	}()
 
+	// Flags override the values from the configuration file
+	overrides := config.Settings{
+		Repo:         *repoURL,
+		Ref:          *ref,
+		Source:       *sourceSpec,
+		InPlace:      *inPlace,
+		NoSubmodules: *noSubmodules,
+		NoLFS:        *noLFS,
+		Subpath:      *subpath,
+		Include:      splitList(*stacks),
+		SynthMode:    cdk.SynthMode(*synthMode),
+		Context:      contextValues,
+		ContextFile:  *contextFile,
+		ContextCache: *contextCache,
+		Exclude:      splitList(*exclude),
+		Concurrency:  *concurrency,
+		Approval:     cdk.ApprovalPolicy(*approval),
+		Timeouts: cdk.Timeouts{
+			Synth: *synthTimeout,
+			Stack: *stackTimeout,
+			Drift: *driftTimeout,
+		},
+		Lock: config.LockSettings{
+			Backend:     *lockBackend,
+			Dir:         *lockDir,
+			Table:       *lockTable,
+			Endpoint:    *lockEndpoint,
+			LockOptions: cdk.LockOptions{TTL: *lockTTL, Wait: *lockWait},
+		},
+		HistoryFile: *historyFile,
+		Archive:     *archive,
+		GitCache: config.GitCacheSettings{
+			Dir:     *gitCache,
+			MaxSize: *gitCacheMaxSize,
+			MaxAge:  *gitCacheMaxAge,
+		},
+		Verify: config.VerifySettings{
+			GPGKeyring:     *verifyGPGKeyring,
+			AllowedSigners: *verifyAllowedSigners,
+		},
+		Repos: config.RepoSettings{
+			Allow:      splitList(*allowRepos),
+			AllowLocal: *allowLocalRepos,
+		},
+		Sandbox: sandboxSettings,
+	}
+
 	// Run the CDK deployer
-	if err := run(ctx, *repoURL, *command, *destDir, *stackName, *cleanup); err != nil {
+	opts := runOptions{
+		command:       *command,
+		destDir:       *destDir,
+		stackName:     *stackName,
+		rollbackTo:    *rollbackTo,
+		assembly:      *assemblyFlag,
+		exclusively:   *exclusively,
+		importMapping: *importMapping,
+		contextReset:  *contextReset,
+		contextClear:  *contextClear,
+		cleanup:       *cleanup,
+		configFile:    *configFile,
+		env:           *env,
+		overrides:     overrides,
+		printConfig:   *printConfig,
+		logger:        logger,
+		drift: driftConfig{
+			remediate:           *remediate,
+			remediationStrategy: cdk.RemediationStrategy(*remediationStrategy),
+			remediationDir:      *remediationDir,
+			ignoreFile:          *driftIgnore,
+			baselineFile:        *driftBaseline,
+			recordBaseline:      *recordBaseline,
+		},
+		history: historyFlags{
+			since: *since,
+			until: *until,
+			limit: *limit,
+			json:  *historyJSON,
+		},
+		recovery: cdk.RecoveryOptions{
+			ContinueRollback:     *continueRollback,
+			ResourcesToSkip:      splitList(*skipResources),
+			RecreateFailedStacks: *recreateFailed,
+			CancelOnInterrupt:    *cancelOnInterrupt,
+			Prompter:             interactivePrompter(),
+		},
+	}
+
+	if err := run(ctx, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func run(ctx context.Context, repoURL, command, destDir, stackName string, cleanup bool) error {
-	// Clone the repository
-	projectPath, err := git.CloneRepository(repoURL, destDir)
+// runOptions holds the flags of a single run
+type runOptions struct {
+	command       string
+	destDir       string
+	stackName     string
+	exclusively   bool
+	rollbackTo    string
+	assembly      string
+	importMapping string
+	contextReset  string
+	contextClear  bool
+	cleanup       bool
+	configFile    string
+	env           string
+	overrides     config.Settings
+	printConfig   bool
+	drift         driftConfig
+	history       historyFlags
+	recovery      cdk.RecoveryOptions
+	logger        *slog.Logger
+}
+
+// driftConfig holds the drift detection and remediation flags
+type driftConfig struct {
+	remediate           bool
+	remediationStrategy cdk.RemediationStrategy
+	remediationDir      string
+	ignoreFile          string
+	baselineFile        string
+	recordBaseline      bool
+}
+
+// loadSettings loads the configuration file, selects the environment overlay and
+// applies the flag overrides. Without a configuration file only the overrides apply.
+func loadSettings(path, env string, overrides config.Settings) (config.Settings, error) {
+	if path == "" {
+		if env != "" {
+			return config.Settings{}, fmt.Errorf("-env %s requires a configuration file", env)
+		}
+		return overrides, nil
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return config.Settings{}, err
+	}
+	if env != "" && !cfg.HasEnvironment(env) {
+		return config.Settings{}, fmt.Errorf("environment %q is not defined in %s", env, path)
+	}
+
+	return cfg.Resolve(env).Merge(overrides), nil
+}
+
+// printSettings prints the effective configuration as YAML
+func printSettings(settings config.Settings, path string) error {
+	out, err := settings.YAML()
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		fmt.Printf("# Effective configuration from %s\n", path)
+	}
+	fmt.Print(out)
+	return nil
+}
+
+func run(ctx context.Context, opts runOptions) (runErr error) {
+	settings, err := loadSettings(opts.configFile, opts.env, opts.overrides)
 	if err != nil {
-		return fmt.Errorf("failed to clone repository: %w", err)
+		return err
+	}
+	if opts.configFile != "" && opts.printConfig {
+		return printSettings(settings, opts.configFile)
+	}
+	if opts.command == "context" {
+		return manageContext(settings.ContextCache, opts.contextReset, opts.contextClear)
+	}
+	// Catch a contradicting sandbox before anything is cloned
+	if _, err := settings.Sandbox.Resolve(); err != nil {
+		return err
+	}
+	if opts.command == "unlock" {
+		return unlockStacks(ctx, settings, append(splitList(opts.stackName), settings.Include...), opts.logger)
+	}
+	if opts.command == "history" {
+		return printHistory(ctx, settings.HistoryFile, opts.stackName, opts.history)
+	}
+	if opts.command == "rollback" {
+		return rollbackStack(ctx, settings, opts)
+	}
+	if settings.Repo == "" && settings.Source == "" && opts.assembly == "" {
+		return fmt.Errorf("no repository given; set -repo, -source, or repo or source in %s", opts.configFile)
+	}
+	if opts.assembly != "" && opts.command == "synth" {
+		return fmt.Errorf("-assembly replaces synthesis; use it with deploy, list, import or drift")
 	}
 
-	// Cleanup if requested
-	if cleanup {
+	// Record the run once it finishes, with the history file of the final settings
+	recorder := history.NewRecorder(history.Operation(opts.command))
+	if recordsHistory(opts.command) && !opts.printConfig {
 		defer func() {
-			fmt.Printf("Cleaning up %s...\n", projectPath)
-			if err := git.CleanupRepository(projectPath); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to cleanup: %v\n", err)
-			}
+			recordRun(ctx, settings.HistoryFile, recorder.Finish(runErr), opts.logger)
 		}()
+	}
+
+	var projectPath, commit string
+	var cdkOpts []cdk.Option
+	if opts.assembly != "" {
+		// A pre-synthesized assembly replaces the clone and synthesis. A directory is
+		// deployed in place; tarballs and archived assemblies are unpacked first.
+		if settings.Verify.Enabled() {
+			return fmt.Errorf("-assembly cannot be used with signature verification: the assembly has no signature to verify")
+		}
+		dir := opts.assembly
+		if !assembly.IsDir(dir) {
+			var err error
+			if dir, commit, err = fetchAssembly(ctx, opts.assembly, settings); err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+		}
+		projectPath = dir
+		cdkOpts = append(cdkOpts, cdk.WithAssembly(dir))
+		recorder.SetSource(git.RedactURL(settings.Repo), settings.Ref, commit)
+		if opts.printConfig {
+			return printSettings(settings, opts.configFile)
+		}
 	} else {
-		fmt.Printf("Repository cloned to: %s\n", projectPath)
+		// Clone the repository, or fetch the directory or archive given as source
+		src, err := newSource(settings, opts.logger, recorder.Handler())
+		if err != nil {
+			return err
+		}
+		checkout, err := src.Fetch(ctx, opts.destDir)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", src, err)
+		}
+		repoPath := checkout.Path
+		commit = checkout.Commit
+		recorder.SetSource(src.String(), settings.Ref, commit)
+		recorder.SetApp(settings.Subpath, settings.Context)
+
+		// Cleanup if requested; a directory used in place is never removed
+		if opts.cleanup && !checkout.InPlace {
+			defer func() {
+				opts.logger.Info("Cleaning up", "path", repoPath)
+				if err := checkout.Cleanup(); err != nil {
+					opts.logger.Warn("Failed to clean up", "path", repoPath, "error", err)
+				}
+			}()
+		} else if !checkout.InPlace {
+			opts.logger.Info("Repository kept", "path", repoPath)
+		}
+
+		// Without a local configuration file, use the one in the repository root. Its repo,
+		// ref and source are ignored since the repository is already fetched.
+		configFile := opts.configFile
+		if configFile == "" {
+			if configFile = config.Discover(repoPath); configFile != "" {
+				opts.logger.Info("Using configuration file", "file", configFile)
+				// The repository must not choose the keys its own signatures are checked with,
+				// nor loosen the sandbox its code runs in
+				verify, sandbox := settings.Verify, settings.Sandbox
+				if settings, err = loadSettings(configFile, opts.env, opts.overrides); err != nil {
+					return err
+				}
+				settings.Verify = verify
+				settings.Sandbox = sandbox.Tighten(settings.Sandbox)
+			} else if opts.env != "" {
+				return fmt.Errorf("-env %s requires a configuration file", opts.env)
+			}
+		}
+		if opts.printConfig {
+			return printSettings(settings, configFile)
+		}
+
+		projectPath = repoPath
+		if settings.Subpath != "" {
+			projectPath = filepath.Join(repoPath, settings.Subpath)
+			if _, err := os.Stat(projectPath); err != nil {
+				return fmt.Errorf("subpath %s not found in repository: %w", settings.Subpath, err)
+			}
+		}
 	}
 
 	// Create CDK instance
-	cdkApp := cdk.New(projectPath)
+	cdkApp, err := newCDKApp(ctx, projectPath, settings, opts, recorder.Handler(), cdkOpts...)
+	if err != nil {
+		return err
+	}
 
-	// Initialize the project
-	if err := cdkApp.Initialize(); err != nil {
-		return fmt.Errorf("failed to initialize CDK project: %w", err)
+	if recordsHistory(opts.command) {
+		recordIdentity(ctx, cdkApp, recorder, opts.logger)
 	}
 
-	switch command {
+	// Initialize the project, or check that the given assembly is complete
+	if opts.assembly == "" {
+		if err := cdkApp.InitializeContext(ctx); err != nil {
+			return fmt.Errorf("failed to initialize CDK project: %w", err)
+		}
+	} else if err := cdkApp.ValidateAssembly(); err != nil {
+		return err
+	}
+
+	selection := cdk.StackSelection{
+		Include:     settings.Include,
+		Exclude:     settings.Exclude,
+		Exclusively: opts.exclusively,
+	}
+
+	// synth synthesizes the app and archives the assembly; a given assembly is used as is
+	synth := func() (*cdk.SynthResult, error) {
+		if opts.assembly != "" {
+			return nil, nil
+		}
+		result, err := cdkApp.SynthContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("synthesis failed: %w", err)
+		}
+		if settings.Archive != "" {
+			if err := archiveAssembly(ctx, settings.Archive, result.TemplateDir, git.RedactURL(settings.Repo), commit); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	// synthStacks synthesizes the app and returns the selected stacks
+	synthStacks := func() ([]string, error) {
+		if _, err := synth(); err != nil {
+			return nil, err
+		}
+		infos, err := cdkApp.Stacks()
+		if err != nil {
+			return nil, err
+		}
+		stacks, err := cdk.SelectStacks(infos, selection)
+		if err != nil {
+			return nil, err
+		}
+		recorder.SetStacks(stacks)
+		return stacks, nil
+	}
+
+	drift := opts.drift
+	stackName := opts.stackName
+
+	switch opts.command {
 	case "synth":
-		result, err := cdkApp.Synth()
+		result, err := synth()
 		if err != nil {
-			return fmt.Errorf("synthesis failed: %w", err)
+			return err
 		}
+		recorder.SetStacks(result.Stacks)
 		fmt.Printf("\nSynthesis complete!\n")
 		fmt.Printf("Template directory: %s\n", result.TemplateDir)
 		fmt.Printf("Stacks: %v\n", result.Stacks)
 
+	case "list":
+		if _, err := synth(); err != nil {
+			return err
+		}
+		infos, err := cdkApp.Stacks()
+		if err != nil {
+			return err
+		}
+		names, err := cdk.SelectStacks(infos, selection)
+		if err != nil {
+			return err
+		}
+		printStacks(infos, names)
+
 	case "deploy":
 		// First synthesize
-		synthResult, err := cdkApp.Synth()
+		stacks, err := synthStacks()
 		if err != nil {
-			return fmt.Errorf("synthesis failed: %w", err)
+			return err
 		}
-		fmt.Printf("Synthesized %d stack(s)\n", len(synthResult.Stacks))
+		fmt.Printf("Synthesized %d stack(s)\n", len(stacks))
 
 		// Then deploy
-		results, err := cdkApp.Deploy(ctx, synthResult.Stacks)
+		results, err := cdkApp.Deploy(ctx, stacks)
+		recorder.AddDeployResults(results)
+		snapshotTemplates(ctx, settings.HistoryFile, cdkApp, recorder, results, opts.logger)
 		if err != nil {
 			return fmt.Errorf("deployment failed: %w", err)
 		}
@@ -116,17 +654,52 @@ func run(ctx context.Context, repoURL, command, destDir, stackName string, clean
 			}
 		}
 
+	case "import":
+		stacks, err := synthStacks()
+		if err != nil {
+			return err
+		}
+		if stackName != "" {
+			stacks = []string{stackName}
+		}
+
+		var mapping cdk.ImportMapping
+		if opts.importMapping != "" {
+			mapping, err = cdk.LoadImportMapping(opts.importMapping)
+			if err != nil {
+				return err
+			}
+		}
+		results, err := cdkApp.Import(ctx, stacks, mapping, consolePrompter())
+		if err != nil {
+			return fmt.Errorf("import failed: %w", err)
+		}
+
+		fmt.Printf("\nImport complete!\n")
+		for _, r := range results {
+			fmt.Printf("\nStack: %s\n", r.StackName)
+			fmt.Printf("Status: %s\n", r.Status)
+			if len(r.Imported) > 0 {
+				fmt.Printf("Imported: %s\n", strings.Join(r.Imported, ", "))
+			}
+		}
+
 	case "drift":
 		var stacks []string
 		if stackName != "" {
 			stacks = []string{stackName}
+			recorder.SetStacks(stacks)
 		} else {
 			// Synthesize to discover stack names
-			synthResult, err := cdkApp.Synth()
+			synthesized, err := synthStacks()
 			if err != nil {
-				return fmt.Errorf("synthesis failed: %w", err)
+				return err
 			}
-			stacks = synthResult.Stacks
+			stacks = synthesized
+		}
+
+		if drift.recordBaseline && drift.baselineFile == "" {
+			return fmt.Errorf("-record-baseline requires -drift-baseline")
 		}
 
 		fmt.Printf("Detecting drift for %d stack(s)...\n", len(stacks))
@@ -136,32 +709,994 @@ func run(ctx context.Context, repoURL, command, destDir, stackName string, clean
 			return fmt.Errorf("drift detection failed: %w", err)
 		}
 
+		if err := filterDrift(results, drift); err != nil {
+			return err
+		}
+
 		fmt.Printf("\nDrift Detection Complete!\n")
-		for _, r := range results {
-			fmt.Printf("\nStack: %s\n", r.StackName)
-			fmt.Printf("Drift Status: %s\n", r.DriftStatus)
-			if len(r.DriftedResources) > 0 {
+		printDriftResults(results)
+
+		if drift.recordBaseline {
+			if err := cdk.NewDriftBaseline(results, time.Now()).Save(drift.baselineFile); err != nil {
+				return err
+			}
+			fmt.Printf("\nDrift baseline recorded to %s\n", drift.baselineFile)
+		}
+
+		if drift.remediate {
+			if err := remediateDrift(ctx, cdkApp, results, drift); err != nil {
+				return fmt.Errorf("drift remediation failed: %w", err)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown command: %s (use 'synth', 'list', 'deploy', 'import', 'drift', 'rollback', 'context', 'unlock', 'history', 'drift-watch', or 'serve')", opts.command)
+	}
+
+	return nil
+}
+
+// rollbackStack redeploys a stack as it was deployed at an earlier commit. The
+// recorded commit is re-synthesized with the recorded context; when that fails or
+// yields a different template, the template snapshot of the earlier deploy is used.
+func rollbackStack(ctx context.Context, settings config.Settings, opts runOptions) (runErr error) {
+	stack := opts.stackName
+	if stack == "" {
+		return fmt.Errorf("-cmd rollback requires -stack")
+	}
+	store, err := historyStore(settings.HistoryFile)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return fmt.Errorf("rollback needs the deployment history; set -history-file or historyFile in the configuration file")
+	}
+
+	rollback, err := history.FindRollbackTarget(ctx, store, stack, opts.rollbackTo)
+	if err != nil {
+		return err
+	}
+	target := rollback.Target
+	fmt.Printf("Rolling back %s from %s to %s, deployed %s\n", stack,
+		shortCommit(rollback.Current.Commit), shortCommit(target.Commit), target.Time.Local().Format(time.DateTime))
+
+	recorder := history.NewRecorder(history.OperationRollback)
+	recorder.SetSource(target.Repo, target.Ref, target.Commit)
+	recorder.SetApp(target.Subpath, target.Context)
+	recorder.SetRollbackFrom(rollback.Current.Commit)
+	recorder.SetStacks([]string{stack})
+	defer func() {
+		recordRun(ctx, settings.HistoryFile, recorder.Finish(runErr), opts.logger)
+	}()
+
+	// The change set against the live stack is always shown; confirmation follows
+	// the approval policy
+	settings.Context = target.Context
+	settings.Subpath = target.Subpath
+	if settings.Approval == "" || settings.Approval == cdk.ApprovalNever {
+		settings.Approval = cdk.ApprovalPreview
+	}
+
+	var snapshot string
+	if s := target.Stack(stack); s != nil && s.TemplateHash != "" {
+		templates, ok := store.(history.TemplateStore)
+		if ok {
+			if snapshot, err = templates.GetTemplate(ctx, s.TemplateHash); err != nil {
+				opts.logger.Warn("Template snapshot of the earlier deploy is not available", "error", err)
+			}
+		}
+	}
+
+	workDir, err := os.MkdirTemp("", "cdk-deployer-rollback-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	cdkApp, err := resynthesize(ctx, workDir, stack, target, settings, opts, recorder)
+	useSnapshot := false
+	// Only a failed synthesis falls back to the snapshot; clone, signature and URL
+	// policy errors must not be bypassed by deploying a stored template
+	var synthErr *synthesisError
+	switch {
+	case err != nil && !errors.As(err, &synthErr):
+		return err
+	case err != nil && snapshot == "":
+		return fmt.Errorf("failed to re-synthesize %s at %s and no template snapshot was kept: %w", stack, shortCommit(target.Commit), err)
+	case err != nil:
+		opts.logger.Warn("Re-synthesis failed, deploying the template snapshot of the earlier deploy", "error", err)
+		if cdkApp, err = newCDKApp(ctx, workDir, settings, opts, recorder.Handler()); err != nil {
+			return err
+		}
+		useSnapshot = true
+	case snapshot != "":
+		body, err := cdkApp.TemplateBody(stack)
+		if err != nil {
+			return err
+		}
+		if history.TemplateHash(body) != history.TemplateHash(snapshot) {
+			opts.logger.Warn("Re-synthesized template differs from the earlier deploy, deploying its template snapshot instead")
+			useSnapshot = true
+		}
+	}
+	if useSnapshot {
+		if err := cdkApp.ReplaceTemplate(stack, snapshot); err != nil {
+			return err
+		}
+	}
+
+	recordIdentity(ctx, cdkApp, recorder, opts.logger)
+	if target.Identity != nil {
+		identity, _, err := cdkApp.CallerIdentity(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check the AWS account of the rollback: %w", err)
+		}
+		if identity.Account != target.Identity.Account {
+			return fmt.Errorf("the earlier deploy went to account %s but the current credentials are for account %s", target.Identity.Account, identity.Account)
+		}
+	}
+
+	results, err := cdkApp.Deploy(ctx, []string{stack})
+	recorder.AddDeployResults(results)
+	snapshotTemplates(ctx, settings.HistoryFile, cdkApp, recorder, results, opts.logger)
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+
+	fmt.Printf("\nRollback complete!\n")
+	for _, r := range results {
+		fmt.Printf("\nStack: %s\n", r.StackName)
+		fmt.Printf("Status: %s\n", r.Status)
+		if r.ChangeSet != nil {
+			cdk.PrintChangeSet(os.Stdout, r.ChangeSet)
+		}
+	}
+	return nil
+}
+
+// synthesisError marks a re-synthesis that failed after the commit was cloned and verified
+type synthesisError struct {
+	err error
+}
+
+func (e *synthesisError) Error() string { return e.err.Error() }
+
+func (e *synthesisError) Unwrap() error { return e.err }
+
+// resynthesize clones the commit of an earlier deploy and synthesizes it with the
+// recorded context. Failures after the clone are returned as *synthesisError.
+func resynthesize(ctx context.Context, workDir, stack string, target history.Record, settings config.Settings, opts runOptions, recorder *history.Recorder) (*cdk.CDK, error) {
+	if target.Repo == "" || target.Commit == "" {
+		return nil, fmt.Errorf("the earlier deploy has no recorded commit")
+	}
+	cloneOpts, err := cloneOptions(settings)
+	if err != nil {
+		return nil, err
+	}
+	cloneOpts = append(cloneOpts, git.WithRef(target.Commit), git.WithLogger(opts.logger), git.WithEventHandler(recorder.Handler()))
+	repoPath, err := git.CloneRepositoryContext(ctx, target.Repo, workDir, cloneOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone repository: %w", err)
+	}
+
+	projectPath := repoPath
+	if target.Subpath != "" {
+		projectPath = filepath.Join(repoPath, target.Subpath)
+	}
+	cdkApp, err := newCDKApp(ctx, projectPath, settings, opts, recorder.Handler())
+	if err != nil {
+		return nil, err
+	}
+	if err := cdkApp.InitializeContext(ctx); err != nil {
+		return nil, &synthesisError{fmt.Errorf("failed to initialize CDK project: %w", err)}
+	}
+	if _, err := cdkApp.SynthContext(ctx); err != nil {
+		return nil, &synthesisError{fmt.Errorf("synthesis failed: %w", err)}
+	}
+	if _, err := cdkApp.TemplateBody(stack); err != nil {
+		return nil, &synthesisError{err}
+	}
+	return cdkApp, nil
+}
+
+// shortCommit abbreviates a commit SHA for display
+func shortCommit(commit string) string {
+	switch {
+	case commit == "":
+		return "an unknown commit"
+	case len(commit) > 12:
+		return commit[:12]
+	default:
+		return commit
+	}
+}
+
+// newSource returns the source of a run: the source setting when set, otherwise the
+// git repository
+func newSource(settings config.Settings, logger *slog.Logger, events event.Handler) (source.Source, error) {
+	cloneOpts, err := cloneOptions(settings)
+	if err != nil {
+		return nil, err
+	}
+	opts := []source.Option{source.WithLogger(logger), source.WithEventHandler(events), source.WithCloneOptions(cloneOpts...)}
+	if settings.Ref != "" {
+		opts = append(opts, source.WithRef(settings.Ref))
+	}
+	if settings.Source == "" {
+		if settings.InPlace {
+			return nil, fmt.Errorf("-in-place requires a dir: source")
+		}
+		return source.NewGit(settings.Repo, opts...), nil
+	}
+	src, err := source.Parse(settings.Source, append(opts, source.WithInPlace(settings.InPlace))...)
+	if err != nil {
+		return nil, err
+	}
+	// Only git sources carry signatures
+	if _, ok := src.(*source.Git); !ok && settings.Verify.Enabled() {
+		return nil, fmt.Errorf("signature verification requires a git source, not %s", src)
+	}
+	return src, nil
+}
+
+// cloneOptions returns the clone options of the settings
+func cloneOptions(settings config.Settings) ([]git.CloneOption, error) {
+	opts := []git.CloneOption{
+		git.WithSubmodules(!settings.NoSubmodules),
+		git.WithLFS(!settings.NoLFS),
+		git.WithURLPolicy(git.URLPolicy{Allow: settings.Repos.Allow, AllowLocal: settings.Repos.AllowLocal}),
+	}
+	if settings.Verify.Enabled() {
+		verifier, err := git.NewVerifier(settings.Verify.GPGKeyring, settings.Verify.AllowedSigners)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, git.WithVerifier(verifier))
+	}
+	cacheSettings := settings.GitCache
+	if cacheSettings.Dir == "" {
+		return opts, nil
+	}
+	maxSize, err := config.ParseSize(cacheSettings.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clone cache size: %w", err)
+	}
+	cache, err := git.NewCache(cacheSettings.Dir, git.WithMaxSize(maxSize), git.WithMaxAge(cacheSettings.MaxAge))
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, git.WithCache(cache)), nil
+}
+
+// newCDKApp creates the CDK app of a run with the deployer configured from the settings
+func newCDKApp(ctx context.Context, projectPath string, settings config.Settings, opts runOptions, events event.Handler, extra ...cdk.Option) (*cdk.CDK, error) {
+	locker, err := newLocker(ctx, settings.Lock)
+	if err != nil {
+		return nil, err
+	}
+	sandbox, err := settings.Sandbox.Resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	deployerOpts := []cdk.DeployerOption{
+		cdk.WithLocking(locker, settings.Lock.LockOptions),
+		cdk.WithRecovery(opts.recovery),
+		cdk.WithStackOptions(settings.StackOptions),
+		cdk.WithConcurrency(settings.Concurrency),
+		cdk.WithTimeouts(settings.Timeouts),
+		cdk.WithApproval(settings.Approval, interactivePrompter()),
+	}
+	cdkOpts := []cdk.Option{
+		cdk.WithLogger(opts.logger),
+		cdk.WithEventHandler(events),
+		cdk.WithSynthMode(settings.SynthMode),
+		cdk.WithSynthTimeout(settings.Timeouts.Synth),
+		cdk.WithSandbox(sandbox),
+		cdk.WithContext(settings.Context),
+		cdk.WithContextFile(settings.ContextFile),
+		cdk.WithContextCache(settings.ContextCache),
+		cdk.WithDeployerOptions(deployerOpts...),
+	}
+	return cdk.New(projectPath, append(cdkOpts, extra...)...), nil
+}
+
+// archiveAssembly stores a synthesized cloud assembly in the archive, indexed by the
+// repository and commit it was synthesized from
+func archiveAssembly(ctx context.Context, location, dir, repo, commit string) error {
+	store, err := assembly.NewStore(ctx, location)
+	if err != nil {
+		return err
+	}
+	ref, err := assembly.Archive(ctx, store, dir, repo, commit)
+	if err != nil {
+		return fmt.Errorf("failed to archive cloud assembly: %w", err)
+	}
+	fmt.Printf("Archived cloud assembly %s to %s\n", ref.Hash, store.URL(ref.Object))
+	return nil
+}
+
+// fetchAssembly unpacks the cloud assembly given with -assembly into a temporary
+// directory: a tarball by path or URL, or from an archive the assembly of the
+// repository at the commit in -ref. It returns the commit when it is known.
+func fetchAssembly(ctx context.Context, location string, settings config.Settings) (string, string, error) {
+	dir, err := os.MkdirTemp("", "cdk-deployer-assembly-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	if assembly.IsTarball(location) {
+		if err := assembly.Fetch(ctx, location, dir); err != nil {
+			os.RemoveAll(dir)
+			return "", "", err
+		}
+		return dir, "", nil
+	}
+
+	if settings.Repo == "" || settings.Ref == "" {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("-assembly %s is an archive; set -repo and -ref to the commit SHA of the assembly", location)
+	}
+	store, err := assembly.NewStore(ctx, location)
+	if err == nil {
+		var ref *assembly.Ref
+		if ref, err = assembly.Lookup(ctx, store, settings.Repo, settings.Ref); err == nil {
+			err = assembly.Extract(ctx, store, ref, dir)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return dir, settings.Ref, nil
+}
+
+// historyFlags holds the flags of -cmd history
+type historyFlags struct {
+	since string
+	until string
+	limit int
+	json  bool
+}
+
+// recordsHistory reports whether runs of a command are recorded to the history
+func recordsHistory(command string) bool {
+	switch command {
+	case "synth", "deploy", "import", "drift":
+		return true
+	default:
+		return false
+	}
+}
+
+// historyStore opens the history file; "none" disables the history
+func historyStore(path string) (history.Store, error) {
+	if path == "none" {
+		return nil, nil
+	}
+	if path == "" {
+		defaultPath, err := history.DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+		path = defaultPath
+	}
+	return history.NewFileStore(path), nil
+}
+
+// recordRun appends the record of a finished run to the history. Failing to do so
+// does not fail the run, which has already happened.
+func recordRun(ctx context.Context, path string, record history.Record, logger *slog.Logger) {
+	store, err := historyStore(path)
+	if err == nil && store != nil {
+		err = store.Append(context.WithoutCancel(ctx), record)
+	}
+	if err != nil {
+		logger.Error("Failed to record the run in the history", "error", err)
+	}
+}
+
+// recordIdentity records the AWS identity and region a run uses
+func recordIdentity(ctx context.Context, cdkApp *cdk.CDK, recorder *history.Recorder, logger *slog.Logger) {
+	identity, region, err := cdkApp.CallerIdentity(ctx)
+	if err != nil {
+		logger.Warn("Failed to resolve the AWS identity for the history record", "error", err)
+	}
+	recorder.SetIdentity(identity, region)
+}
+
+// snapshotTemplates keeps the templates of deployed stacks for later rollbacks
+func snapshotTemplates(ctx context.Context, path string, cdkApp *cdk.CDK, recorder *history.Recorder, results []cdk.DeployResult, logger *slog.Logger) {
+	store, err := historyStore(path)
+	if err != nil || store == nil {
+		return
+	}
+	templates, ok := store.(history.TemplateStore)
+	if !ok {
+		return
+	}
+	if err := recorder.SnapshotTemplates(ctx, templates, results, cdkApp.TemplateBody); err != nil {
+		logger.Warn("Failed to keep template snapshots for rollbacks", "error", err)
+	}
+}
+
+// printHistory prints the recorded runs matching the history flags, newest first
+func printHistory(ctx context.Context, path, stack string, f historyFlags) error {
+	store, err := historyStore(path)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return fmt.Errorf("the history is disabled; set -history-file or historyFile in the configuration file")
+	}
+
+	now := time.Now()
+	query := history.Query{Stack: stack, Limit: f.limit}
+	if query.Since, err = parseHistoryTime(f.since, now); err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+	if query.Until, err = parseHistoryTime(f.until, now); err != nil {
+		return fmt.Errorf("invalid -until: %w", err)
+	}
+
+	records, err := store.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if f.json {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No recorded runs")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tOPERATION\tSTATUS\tDURATION\tCOMMIT\tIDENTITY\tSTACKS")
+	for _, r := range records {
+		commit := r.Commit
+		switch {
+		case commit == "":
+			commit = "-"
+		case len(commit) > 12:
+			commit = commit[:12]
+		}
+		identity := "-"
+		if r.Identity != nil {
+			identity = r.Identity.ARN
+		}
+		stacks := make([]string, len(r.Stacks))
+		for i, st := range r.Stacks {
+			stacks[i] = st.Name
+			if st.Status != "" && st.Status != r.Status {
+				stacks[i] += " (" + string(st.Status) + ")"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time.Local().Format(time.DateTime), r.Operation, r.Status,
+			r.Duration().Round(time.Second), commit, identity, strings.Join(stacks, ", "))
+	}
+	return w.Flush()
+}
+
+// parseHistoryTime parses an RFC 3339 time, a date, or a duration before now
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration, an RFC 3339 time nor a date", value)
+}
+
+// manageContext lists the cached context values, or removes some or all of them
+func manageContext(cachePath, reset string, clear bool) error {
+	if cachePath == "" {
+		return fmt.Errorf("-cmd context requires -context-cache or contextCache in the configuration file")
+	}
+
+	values, err := cdk.LoadContextFile(cachePath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case clear:
+		fmt.Printf("Cleared %d context value(s) from %s\n", len(values), cachePath)
+		return cdk.ContextValues{}.Save(cachePath)
+	case reset != "":
+		removed := values.Reset(reset)
+		if len(removed) == 0 {
+			return fmt.Errorf("no context key matches %q", reset)
+		}
+		for _, key := range removed {
+			fmt.Printf("Removed %s\n", key)
+		}
+		return values.Save(cachePath)
+	}
+
+	if len(values) == 0 {
+		fmt.Printf("No context values cached in %s\n", cachePath)
+		return nil
+	}
+	fmt.Printf("Context values cached in %s:\n", cachePath)
+	for _, key := range values.Keys() {
+		kind := "value"
+		if cdk.IsLookupKey(key) {
+			kind = "lookup"
+		}
+		data, err := json.Marshal(values[key])
+		if err != nil {
+			return fmt.Errorf("failed to encode context value %s: %w", key, err)
+		}
+		fmt.Printf("  [%s] %s = %s\n", kind, key, data)
+	}
+	return nil
+}
+
+// printStacks prints the selected stacks with their environments and dependencies
+func printStacks(infos []cdk.StackInfo, names []string) {
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	fmt.Printf("\n%d stack(s):\n", len(names))
+	for _, info := range infos {
+		if !selected[info.Name] {
+			continue
+		}
+		fmt.Printf("\n%s\n", info)
+		if info.Environment != "" {
+			fmt.Printf("  Environment: %s\n", info.Environment)
+		}
+		if len(info.Dependencies) > 0 {
+			fmt.Printf("  Depends on: %s\n", strings.Join(info.Dependencies, ", "))
+		}
+	}
+}
+
+// filterDrift applies the ignore rules and, unless a new baseline is being recorded, the baseline
+func filterDrift(results []cdk.DriftResult, drift driftConfig) error {
+	if drift.ignoreFile != "" {
+		rules, err := cdk.LoadDriftIgnoreRules(drift.ignoreFile)
+		if err != nil {
+			return err
+		}
+		rules.Apply(results, time.Now())
+	}
+
+	if drift.baselineFile != "" && !drift.recordBaseline {
+		baseline, err := cdk.LoadDriftBaseline(drift.baselineFile)
+		if err != nil {
+			return err
+		}
+		baseline.Apply(results)
+	}
+
+	return nil
+}
+
+// printDriftResults prints drift results, hiding drift already recorded in the baseline
+func printDriftResults(results []cdk.DriftResult) {
+	for _, r := range results {
+		fmt.Printf("\nStack: %s\n", r.StackName)
+		fmt.Printf("Drift Status: %s\n", r.DriftStatus)
+
+		baselined := 0
+		printed := 0
+		for _, dr := range r.DriftedResources {
+			if dr.InBaseline {
+				baselined++
+				continue
+			}
+			if printed == 0 {
 				fmt.Println("Drifted Resources:")
-				for _, dr := range r.DriftedResources {
-					fmt.Printf("  - %s (%s)\n", dr.LogicalID, dr.ResourceType)
-					fmt.Printf("    Physical ID: %s\n", dr.PhysicalID)
-					fmt.Printf("    Status: %s\n", dr.DriftStatus)
-					if len(dr.PropertyDiffs) > 0 {
-						fmt.Println("    Property Differences:")
-						for _, pd := range dr.PropertyDiffs {
-							fmt.Printf("      %s: expected=%s, actual=%s (%s)\n",
-								pd.PropertyPath, pd.ExpectedValue, pd.ActualValue, pd.DifferenceType)
-						}
+			}
+			printed++
+
+			fmt.Printf("  - %s (%s)\n", dr.LogicalID, dr.ResourceType)
+			fmt.Printf("    Physical ID: %s\n", dr.PhysicalID)
+			fmt.Printf("    Status: %s\n", dr.DriftStatus)
+			if dr.Suppressed {
+				fmt.Printf("    Suppressed: %s\n", dr.SuppressionReason)
+			}
+			if len(dr.PropertyDiffs) > 0 {
+				fmt.Println("    Property Differences:")
+				for _, pd := range dr.PropertyDiffs {
+					if pd.InBaseline {
+						continue
 					}
+					suffix := ""
+					if pd.Suppressed {
+						suffix = fmt.Sprintf(" [suppressed: %s]", pd.SuppressionReason)
+					}
+					fmt.Printf("      %s: expected=%s, actual=%s (%s)%s\n",
+						pd.PropertyPath, pd.ExpectedValue, pd.ActualValue, pd.DifferenceType, suffix)
 				}
-			} else {
-				fmt.Println("No drifted resources found.")
 			}
 		}
 
+		if printed == 0 {
+			fmt.Println("No drifted resources found.")
+		}
+		if baselined > 0 {
+			fmt.Printf("%d drifted resource(s) already recorded in the baseline\n", baselined)
+		}
+	}
+}
+
+// remediateDrift walks the drifted stacks and remediates them interactively
+func remediateDrift(ctx context.Context, cdkApp *cdk.CDK, results []cdk.DriftResult, drift driftConfig) error {
+	if err := os.MkdirAll(drift.remediationDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create remediation directory: %w", err)
+	}
+
+	opts := cdk.RemediationOptions{
+		Strategy:  drift.remediationStrategy,
+		OutputDir: drift.remediationDir,
+		Prompter:  consolePrompter(),
+	}
+
+	for i := range results {
+		if len(results[i].DriftedResources) == 0 {
+			continue
+		}
+
+		fmt.Printf("\nRemediating drift in stack %s\n", results[i].StackName)
+		result, err := cdkApp.RemediateDrift(ctx, &results[i], opts)
+		if err != nil {
+			return fmt.Errorf("stack %s: %w", results[i].StackName, err)
+		}
+
+		if result.Executed {
+			fmt.Printf("Change set executed, stack status: %s\n", result.Status)
+		}
+		if result.OverridesFile != "" {
+			fmt.Printf("Review actual values in %s\n", result.OverridesFile)
+		}
+		if result.PlanFile != "" {
+			fmt.Printf("Review remediation plan in %s\n", result.PlanFile)
+		}
+	}
+
+	return nil
+}
+
+// newLocker creates the lock backend for per-stack deployment locks; nil disables locking
+func newLocker(ctx context.Context, settings config.LockSettings) (lock.Locker, error) {
+	switch settings.Backend {
+	case "none":
+		return nil, nil
+	case "dynamodb":
+		var opts []lock.DynamoDBOption
+		if settings.Endpoint != "" {
+			opts = append(opts, lock.WithEndpoint(settings.Endpoint))
+		}
+		return lock.NewDynamoDBLocker(ctx, settings.Table, opts...)
 	default:
-		return fmt.Errorf("unknown command: %s (use 'synth', 'deploy', or 'drift')", command)
+		dir := settings.Dir
+		if dir == "" {
+			dir = defaultLockDir()
+		}
+		return lock.NewFileLocker(dir)
+	}
+}
+
+// expandLockedStacks replaces the glob patterns among stacks with the locked stacks
+// they match; plain names are kept as they are
+func expandLockedStacks(ctx context.Context, deployer *cdk.Deployer, stacks []string) ([]string, error) {
+	var locked []string
+	var expanded []string
+	seen := make(map[string]bool)
+	for _, stack := range stacks {
+		if !strings.ContainsAny(stack, "*?") {
+			if !seen[stack] {
+				seen[stack] = true
+				expanded = append(expanded, stack)
+			}
+			continue
+		}
+		if locked == nil {
+			var err error
+			if locked, err = deployer.LockedStacks(ctx); err != nil {
+				return nil, err
+			}
+		}
+		matched := false
+		for _, name := range locked {
+			if cdk.MatchGlob(stack, name) {
+				matched = true
+				if !seen[name] {
+					seen[name] = true
+					expanded = append(expanded, name)
+				}
+			}
+		}
+		if !matched {
+			fmt.Printf("No locked stack matches %s\n", stack)
+		}
+	}
+	return expanded, nil
+}
+
+// defaultLockDir returns the per-user directory of the file lock backend. A directory
+// shared by all users of the host would be owned by whoever ran first.
+func defaultLockDir() string {
+	if cache, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cache, "cdk-deployer", "locks")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("cdk-deployer-locks-%d", os.Getuid()))
+}
+
+// unlockStacks removes the deployment locks of stacks, e.g. after a run crashed.
+// Glob patterns select among the stacks currently locked.
+func unlockStacks(ctx context.Context, settings config.Settings, stacks []string, logger *slog.Logger) error {
+	if len(stacks) == 0 {
+		return fmt.Errorf("-cmd unlock requires the stacks to unlock in -stack")
+	}
+	if settings.Lock.Backend == "none" {
+		return fmt.Errorf("stack locking is disabled")
+	}
+
+	locker, err := newLocker(ctx, settings.Lock)
+	if err != nil {
+		return err
+	}
+	deployer, err := cdk.NewDeployer(ctx, nil, cdk.WithLocking(locker, settings.Lock.LockOptions), cdk.WithDeployerLogger(logger))
+	if err != nil {
+		return err
+	}
+	stacks, err = expandLockedStacks(ctx, deployer, stacks)
+	if err != nil {
+		return err
+	}
+
+	prompter := interactivePrompter()
+	for _, stack := range stacks {
+		info, err := deployer.InspectLock(ctx, stack)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			fmt.Printf("Stack %s is not locked\n", stack)
+			continue
+		}
+
+		state := "held"
+		if info.Expired(time.Now()) {
+			state = "expired"
+		}
+		fmt.Printf("Stack %s is locked (%s)\n", info.Key, state)
+		fmt.Printf("  Holder:    %s (pid %d)\n", info.Holder, info.PID)
+		fmt.Printf("  Operation: %s\n", info.Operation)
+		fmt.Printf("  Acquired:  %s\n", info.AcquiredAt.Local().Format(time.RFC3339))
+		fmt.Printf("  Expires:   %s\n", info.ExpiresAt.Local().Format(time.RFC3339))
+
+		if prompter != nil && state == "held" {
+			ok, err := prompter.Confirm(fmt.Sprintf("Remove the lock of %s? Only do this if the holder is no longer running", stack))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if err := deployer.ForceUnlock(ctx, stack); err != nil {
+			return err
+		}
+		fmt.Printf("Removed the lock of %s\n", stack)
+	}
+	return nil
+}
+
+// apiTokensEnv holds comma-separated bearer tokens for serve
+const apiTokensEnv = "CDK_DEPLOYER_API_TOKENS"
+
+type serveFlags struct {
+	listen       string
+	dataDir      string
+	workers      int
+	tokens       string
+	tokenFile    string
+	webhooks     string
+	history      string
+	gitCache     config.GitCacheSettings
+	noSubmodules bool
+	noLFS        bool
+	verify       config.VerifySettings
+	repos        config.RepoSettings
+	sandbox      config.SandboxSettings
+	lock         config.LockSettings
+	logger       *slog.Logger
+}
+
+// runServe runs the HTTP API server until ctx is cancelled
+func runServe(ctx context.Context, f serveFlags) error {
+	tokens := splitList(f.tokens)
+	tokens = append(tokens, splitList(os.Getenv(apiTokensEnv))...)
+	if f.tokenFile != "" {
+		data, err := os.ReadFile(f.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read API token file: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				tokens = append(tokens, line)
+			}
+		}
+	}
+
+	locker, err := newLocker(ctx, f.lock)
+	if err != nil {
+		return err
+	}
+
+	cloneOpts, err := cloneOptions(config.Settings{GitCache: f.gitCache, NoSubmodules: f.noSubmodules, NoLFS: f.noLFS, Verify: f.verify, Repos: f.repos})
+	if err != nil {
+		return err
+	}
+	sandbox, err := f.sandbox.Resolve()
+	if err != nil {
+		return err
+	}
+
+	opts := []server.Option{
+		server.WithDeployerOptions(cdk.WithLocking(locker, f.lock.LockOptions)),
+		server.WithCloneOptions(cloneOpts...),
+		server.WithCDKOptions(cdk.WithSandbox(sandbox)),
+		server.WithTokens(tokens...),
+		server.WithWorkers(f.workers),
+		server.WithLogger(f.logger),
+	}
+	// Jobs are recorded next to the job database unless a history file is given
+	historyFile := f.history
+	if historyFile == "" {
+		historyFile = filepath.Join(f.dataDir, "history.jsonl")
+	}
+	store, err := historyStore(historyFile)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		opts = append(opts, server.WithHistory(store))
+	}
+	if f.webhooks != "" {
+		cfg, err := server.LoadWebhookConfig(f.webhooks)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, server.WithWebhooks(cfg))
+	}
+
+	srv, err := server.New(f.dataDir, opts...)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	return srv.ListenAndServe(ctx, f.listen)
+}
+
+// watchFlags holds the drift-watch flags
+type watchFlags struct {
+	configFile string
+	interval   time.Duration
+	regions    string
+	stacks     string
+	stateFile  string
+	ignoreFile string
+	baseline   string
+	webhook    string
+	alertFile  string
+	logger     *slog.Logger
+}
+
+// runDriftWatch runs drift detection periodically until interrupted
+func runDriftWatch(ctx context.Context, f watchFlags) error {
+	cfg := &watch.Config{}
+	if f.configFile != "" {
+		loaded, err := watch.LoadConfig(f.configFile)
+		if err != nil {
+			return err
+		}
+		cfg = loaded
+	}
+
+	if f.interval > 0 {
+		cfg.Interval = watch.Duration(f.interval)
+	}
+	if f.stateFile != "" {
+		cfg.StateFile = f.stateFile
+	}
+	if f.ignoreFile != "" {
+		cfg.IgnoreFile = f.ignoreFile
+	}
+	if f.baseline != "" {
+		cfg.BaselineFile = f.baseline
+	}
+	if f.webhook != "" {
+		cfg.Alerts = append(cfg.Alerts, alert.SinkConfig{Type: "webhook", URL: f.webhook})
+	}
+	if f.alertFile != "" {
+		cfg.Alerts = append(cfg.Alerts, alert.SinkConfig{Type: "file", Path: f.alertFile})
+	}
+
+	if f.stacks != "" {
+		stacks := splitList(f.stacks)
+		regions := splitList(f.regions)
+		if len(regions) == 0 {
+			regions = []string{""}
+		}
+		for _, region := range regions {
+			cfg.Targets = append(cfg.Targets, watch.Target{Region: region, Stacks: stacks})
+		}
+	}
+
+	watcher, err := watch.New(*cfg, watch.WithLogger(f.logger))
+	if err != nil {
+		return err
 	}
 
+	return watcher.Run(ctx)
+}
+
+// consolePrompter is the one prompter reading stdin, so that buffered input is never
+// split between prompters and questions are asked one at a time
+var consolePrompter = sync.OnceValue(func() *cdk.ConsolePrompter {
+	return cdk.NewConsolePrompter(os.Stdin, os.Stdout)
+})
+
+// interactivePrompter returns the console prompter when stdin is a terminal, nil otherwise
+func interactivePrompter() cdk.Prompter {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return consolePrompter()
+}
+
+// contextFlag collects repeated -context key=value flags
+type contextFlag map[string]string
+
+func (f contextFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f contextFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	f[strings.TrimSpace(key)] = val
 	return nil
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,170 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Alert describes a change in the drift status of a stack
+type Alert struct {
+	Time           time.Time  `json:"time"`
+	Region         string     `json:"region"`
+	StackName      string     `json:"stackName"`
+	PreviousStatus string     `json:"previousStatus"`
+	Status         string     `json:"status"`
+	Message        string     `json:"message"`
+	Resources      []Resource `json:"resources,omitempty"`
+}
+
+// Resource identifies a drifted resource in an alert
+type Resource struct {
+	LogicalID    string `json:"logicalId"`
+	PhysicalID   string `json:"physicalId"`
+	ResourceType string `json:"resourceType"`
+	DriftStatus  string `json:"driftStatus"`
+}
+
+// Sink delivers alerts to a destination
+type Sink interface {
+	Send(ctx context.Context, a Alert) error
+}
+
+// SinkConfig describes an alert sink in a configuration file
+type SinkConfig struct {
+	// Type is one of "stdout", "file" or "webhook"
+	Type string `json:"type"`
+	// Path is the file alerts are appended to for the file sink
+	Path string `json:"path,omitempty"`
+	// URL receives a JSON POST for the webhook sink
+	URL string `json:"url,omitempty"`
+	// Headers are added to webhook requests, e.g. for authentication
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// NewSink creates a sink from its configuration
+func NewSink(cfg SinkConfig) (Sink, error) {
+	switch cfg.Type {
+	case "stdout", "":
+		return NewWriterSink(os.Stdout), nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("file alert sink requires a path")
+		}
+		return NewFileSink(cfg.Path), nil
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook alert sink requires a url")
+		}
+		return NewWebhookSink(cfg.URL, cfg.Headers), nil
+	default:
+		return nil, fmt.Errorf("unknown alert sink type: %s", cfg.Type)
+	}
+}
+
+// WriterSink prints alerts as human-readable text
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a sink printing to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Send prints the alert
+func (s *WriterSink) Send(ctx context.Context, a Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(s.w, "[%s] ALERT %s/%s: %s\n", a.Time.Format(time.RFC3339), a.Region, a.StackName, a.Message)
+	for _, r := range a.Resources {
+		fmt.Fprintf(s.w, "  - %s (%s) %s\n", r.LogicalID, r.ResourceType, r.DriftStatus)
+	}
+	return nil
+}
+
+// FileSink appends alerts as JSON lines to a file
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSink creates a sink appending to path
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Send appends the alert to the file
+func (s *FileSink) Send(ctx context.Context, a Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open alert file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write alert: %w", err)
+	}
+	return nil
+}
+
+// WebhookSink posts alerts as JSON to an HTTP endpoint
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewWebhookSink creates a sink posting to url
+func NewWebhookSink(url string, headers map[string]string) *WebhookSink {
+	return &WebhookSink{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send posts the alert and fails on non-2xx responses
+func (s *WebhookSink) Send(ctx context.Context, a Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package assembly
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"cdk-deployer/pkg/extract"
+)
+
+// Pack writes the cloud assembly in dir as a gzipped tarball. Entries are sorted and
+// carry no timestamps or owners, so the same assembly always packs to the same bytes.
+func Pack(dir string, w io.Writer) error {
+	var paths []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read cloud assembly: %w", err)
+	}
+	sort.Strings(paths)
+
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+	for _, p := range paths {
+		if err := packEntry(tw, dir, p); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write cloud assembly archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write cloud assembly archive: %w", err)
+	}
+	return nil
+}
+
+// packEntry adds a single file, directory or symlink to the tarball
+func packEntry(tw *tar.Writer, dir, p string) error {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return err
+	}
+	name := filepath.ToSlash(rel)
+
+	info, err := os.Lstat(p)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	hdr := &tar.Header{Name: name, Format: tar.FormatPAX}
+	switch {
+	case info.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		hdr.Mode = 0o755
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(p)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = filepath.ToSlash(target)
+		hdr.Mode = 0o777
+	case info.Mode().IsRegular():
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+		hdr.Mode = 0o644
+		if info.Mode()&0o111 != 0 {
+			hdr.Mode = 0o755
+		}
+	default:
+		// Sockets, devices and the like have no place in an assembly
+		return nil
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write cloud assembly archive: %w", err)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", name, err)
+	}
+	return nil
+}
+
+// Unpack extracts a tarball written by Pack into dir. Entries and symlinks pointing
+// outside dir are rejected.
+func Unpack(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read cloud assembly archive: %w", err)
+	}
+	defer gz.Close()
+
+	if err := extract.Tar(gz, dir); err != nil {
+		return fmt.Errorf("failed to unpack cloud assembly archive: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package assembly
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Ref is the index entry of an assembly archived for a repository commit
+type Ref struct {
+	Repo   string `json:"repo"`
+	Commit string `json:"commit"`
+	// Hash is the SHA-256 of the tarball, which is also its name in the archive
+	Hash      string    `json:"hash"`
+	Object    string    `json:"object"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Archive packs the cloud assembly in dir and stores it under its hash. With a repo
+// and commit it is also indexed under them for Lookup. A tarball already in the
+// archive is not uploaded again.
+func Archive(ctx context.Context, store Store, dir, repo, commit string) (*Ref, error) {
+	tmp, err := os.CreateTemp("", "cdk-assembly-*.tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if err := Pack(dir, io.MultiWriter(tmp, h)); err != nil {
+		return nil, err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	ref := &Ref{
+		Repo:      repo,
+		Commit:    commit,
+		Hash:      "sha256:" + hash,
+		Object:    objectKey(hash),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	existing, err := store.Get(ctx, ref.Object)
+	switch {
+	case err == nil:
+		existing.Close()
+	case errors.Is(err, ErrNotFound):
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := store.Put(ctx, ref.Object, tmp); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if repo == "" || !commitSHA.MatchString(commit) {
+		return ref, nil
+	}
+	data, err := json.MarshalIndent(ref, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Put(ctx, refKey(repo, commit), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// Lookup returns the index entry of the assembly archived for a repository commit
+func Lookup(ctx context.Context, store Store, repo, commit string) (*Ref, error) {
+	if !commitSHA.MatchString(commit) {
+		return nil, fmt.Errorf("archived assemblies are looked up by full commit SHA, got %q", commit)
+	}
+	r, err := store.Get(ctx, refKey(repo, commit))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("no assembly archived for %s at %s: %w", repo, commit, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var ref Ref
+	if err := json.NewDecoder(r).Decode(&ref); err != nil {
+		return nil, fmt.Errorf("failed to parse assembly index of %s at %s: %w", repo, commit, err)
+	}
+	return &ref, nil
+}
+
+// Extract unpacks an indexed assembly into dir, checking its hash
+func Extract(ctx context.Context, store Store, ref *Ref, dir string) error {
+	r, err := store.Get(ctx, ref.Object)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return unpackVerified(r, strings.TrimPrefix(ref.Hash, "sha256:"), dir)
+}
+
+// Fetch unpacks the tarball at location, a local path or an s3:// or https:// URL,
+// into dir. Tarballs named by their hash, as in the archive, are checked against it;
+// unencrypted http:// URLs are only accepted for those.
+func Fetch(ctx context.Context, location, dir string) error {
+	want := ""
+	if name := path.Base(location); objectName.MatchString(name) {
+		want = strings.TrimSuffix(name, ".tar.gz")
+	}
+	if strings.HasPrefix(location, "http://") && want == "" {
+		return fmt.Errorf("refusing to fetch %s over unencrypted http without a checksum: use https:// or a tarball named <sha256>.tar.gz", location)
+	}
+
+	r, err := open(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return unpackVerified(r, want, dir)
+}
+
+// IsTarball reports whether location names a tarball rather than an archive
+func IsTarball(location string) bool {
+	return strings.HasSuffix(location, ".tar.gz") || strings.HasSuffix(location, ".tgz")
+}
+
+// IsDir reports whether location is a local cloud assembly directory, such as a
+// cdk.out left by a build step, that is deployed in place
+func IsDir(location string) bool {
+	info, err := os.Stat(filepath.Join(location, "manifest.json"))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// open opens a tarball by path or URL
+func open(ctx context.Context, location string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		bucket, key, err := parseS3URL(location)
+		if err != nil {
+			return nil, err
+		}
+		store, err := NewS3Store(ctx, "s3://"+bucket)
+		if err != nil {
+			return nil, err
+		}
+		return store.Get(ctx, key)
+	case strings.HasPrefix(location, "https://"), strings.HasPrefix(location, "http://"):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid assembly URL: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download assembly: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to download assembly: %s", resp.Status)
+		}
+		return resp.Body, nil
+	default:
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open assembly: %w", err)
+		}
+		return f, nil
+	}
+}
+
+// unpackVerified unpacks a tarball and, when want is set, checks its SHA-256 first,
+// so that nothing of a corrupt or tampered tarball is extracted
+func unpackVerified(r io.Reader, want, dir string) error {
+	if want == "" {
+		return Unpack(r, dir)
+	}
+
+	tmp, err := os.CreateTemp("", "cdk-deployer-assembly-*.tar.gz")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return fmt.Errorf("failed to read cloud assembly archive: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("cloud assembly archive is corrupt: expected hash %s, got %s", want, got)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read cloud assembly archive: %w", err)
+	}
+	return Unpack(tmp, dir)
+}
+
+// commitSHA matches the full SHA-1 or SHA-256 commit IDs assemblies are indexed by
+var commitSHA = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// objectName matches the file names of archived tarballs
+var objectName = regexp.MustCompile(`^[0-9a-f]{64}\.tar\.gz$`)
+
+// objectKey returns the archive key of a tarball
+func objectKey(hash string) string {
+	return "objects/" + hash + ".tar.gz"
+}
+
+// refKey returns the archive key of the index entry of a repository commit
+func refKey(repo, commit string) string {
+	return "refs/" + RepoKey(repo) + "/" + commit + ".json"
+}
+
+// unsafeKeyChars matches characters left out of archive keys
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._/-]+`)
+
+// RepoKey turns a repository URL into a path, e.g. github.com/acme/platform for both
+// https://github.com/acme/platform.git and git@github.com:acme/platform.git
+func RepoKey(repo string) string {
+	key := repo
+	if i := strings.Index(key, "://"); i >= 0 {
+		key = key[i+3:]
+	} else if at := strings.Index(key, "@"); at >= 0 && strings.Contains(key[at:], ":") {
+		// scp-like syntax: user@host:path
+		key = strings.Replace(key[at+1:], ":", "/", 1)
+	}
+	host, _, _ := strings.Cut(key, "/")
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		key = key[at+1:]
+	}
+	key = strings.TrimSuffix(strings.Trim(key, "/"), ".git")
+	key = unsafeKeyChars.ReplaceAllString(key, "_")
+
+	var parts []string
+	for _, part := range strings.Split(key, "/") {
+		if part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,162 @@
+package assembly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrNotFound is returned by stores for keys they do not hold
+var ErrNotFound = errors.New("not found in the assembly archive")
+
+// Store keeps archived assemblies and their index under slash-separated keys
+type Store interface {
+	// Put stores the content of body under key
+	Put(ctx context.Context, key string, body io.ReadSeeker) error
+	// Get opens the content stored under key, or returns ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// URL returns where the content of key is stored, for display and -assembly
+	URL(key string) string
+}
+
+// NewStore opens the archive at location: an s3://bucket/prefix URL or a local directory
+func NewStore(ctx context.Context, location string) (Store, error) {
+	if strings.HasPrefix(location, "s3://") {
+		return NewS3Store(ctx, location)
+	}
+	if location == "" {
+		return nil, fmt.Errorf("an archive location is required")
+	}
+	return NewDirStore(location), nil
+}
+
+// DirStore keeps the archive in a local directory
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore creates a store in a local directory
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{dir: dir}
+}
+
+// Put writes the content to a temporary file first so readers never see partial content
+func (s *DirStore) Put(_ context.Context, key string, body io.ReadSeeker) error {
+	target := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get opens a file of the archive
+func (s *DirStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// URL returns the path of a file of the archive
+func (s *DirStore) URL(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// S3Store keeps the archive under a prefix of an S3 bucket
+type S3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store creates a store for an s3://bucket/prefix URL using the default AWS
+// credentials
+func NewS3Store(ctx context.Context, location string) (*S3Store, error) {
+	bucket, prefix, err := parseS3URL(location)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return &S3Store{client: s3.NewFromConfig(cfg), bucket: bucket, prefix: prefix}, nil
+}
+
+// Put uploads an object
+func (s *S3Store) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+		Body:   body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", s.URL(key), err)
+	}
+	return nil
+}
+
+// Get downloads an object
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, fmt.Errorf("%s: %w", s.URL(key), ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", s.URL(key), err)
+	}
+	return out.Body, nil
+}
+
+// URL returns the s3:// URL of an object
+func (s *S3Store) URL(key string) string {
+	return "s3://" + s.bucket + "/" + s.key(key)
+}
+
+// key returns the object key of an archive key
+func (s *S3Store) key(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+// parseS3URL splits an s3://bucket/key URL
+func parseS3URL(location string) (bucket, key string, err error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid S3 URL %q (use s3://bucket/prefix)", location)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
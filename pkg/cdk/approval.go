@@ -0,0 +1,106 @@
+package cdk
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// deployWithApproval deploys a stack through a change set that is reviewed before it
+// is executed, as required by the approval policy
+func (d *Deployer) deployWithApproval(ctx context.Context, stackName, templateBody string, exists bool) (*DeployResult, error) {
+	changeSetType := types.ChangeSetTypeUpdate
+	if !exists {
+		changeSetType = types.ChangeSetTypeCreate
+	}
+
+	summary, err := d.createChangeSet(ctx, changeSetRequest{
+		StackName:     stackName,
+		ChangeSetName: fmt.Sprintf("cdk-deployer-%d", time.Now().Unix()),
+		ChangeSetType: changeSetType,
+		TemplateBody:  templateBody,
+		Description:   "Deployment by cdk-deployer",
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.logChangeSet(summary)
+
+	if !summary.HasChanges() {
+		d.stackLogger(stackName).Info("Stack is already up to date")
+		if err := d.deleteChangeSet(ctx, summary); err != nil {
+			return nil, err
+		}
+		return d.deployResult(ctx, stackName, "", summary)
+	}
+
+	if d.approval == ApprovalAlways || (d.approval == ApprovalDestructive && summary.IsDestructive()) {
+		approved := false
+		if d.prompter != nil {
+			approved, err = d.prompter.Confirm(fmt.Sprintf("Deploy change set to %s?", stackName))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !approved {
+			if err := d.discardChangeSet(ctx, summary, exists); err != nil {
+				d.stackLogger(stackName).Warn("Failed to discard change set", "error", err)
+			}
+			if d.prompter == nil {
+				return nil, fmt.Errorf("deployment of stack %s requires approval but no interactive terminal is available", stackName)
+			}
+			return nil, fmt.Errorf("deployment of stack %s was not approved", stackName)
+		}
+	}
+
+	status, err := d.executeChangeSet(ctx, summary)
+	if err != nil {
+		d.handleFailedOperation(ctx, stackName, status)
+		return nil, err
+	}
+
+	return d.deployResult(ctx, stackName, status, summary)
+}
+
+// discardChangeSet deletes a rejected change set, including the empty stack a
+// rejected create change set leaves behind
+func (d *Deployer) discardChangeSet(ctx context.Context, summary *ChangeSetSummary, exists bool) error {
+	if err := d.deleteChangeSet(ctx, summary); err != nil {
+		return err
+	}
+	if !exists {
+		return d.deleteStack(ctx, summary.StackName)
+	}
+	return nil
+}
+
+// deployResult collects the status and outputs of a stack deployed through a change set
+func (d *Deployer) deployResult(ctx context.Context, stackName, status string, summary *ChangeSetSummary) (*DeployResult, error) {
+	if status == "" {
+		var err error
+		status, err = d.getStackStatus(ctx, stackName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	outputs, err := d.getStackOutputs(ctx, stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	stackID, err := d.getStackID(ctx, stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeployResult{
+		StackName: stackName,
+		StackID:   stackID,
+		Status:    status,
+		Outputs:   outputs,
+		ChangeSet: summary,
+	}, nil
+}
@@ -0,0 +1,317 @@
+package cdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cdk-deployer/pkg/git"
+)
+
+// artifactTypeAssetManifest is the cloud assembly artifact type of an asset manifest
+const artifactTypeAssetManifest = "cdk:asset-manifest"
+
+// maxAssemblyVersion is the newest cloud assembly schema major version known to work.
+// The deployer only reads stable parts of the schema, so newer versions are allowed
+// with a warning.
+const maxAssemblyVersion = 48
+
+// assetManifest is the subset of an asset manifest (<stack>.assets.json) we use
+type assetManifest struct {
+	Files        map[string]assetEntry `json:"files"`
+	DockerImages map[string]assetEntry `json:"dockerImages"`
+}
+
+// assetEntry is a single file or container image asset
+type assetEntry struct {
+	Source struct {
+		// Path is the file or directory of a file asset, relative to the assembly
+		Path string `json:"path"`
+		// Directory is the build context of a container image asset
+		Directory string `json:"directory"`
+	} `json:"source"`
+}
+
+// artifactTypeNestedAssembly is the cloud assembly artifact type of the nested
+// assembly of a stage
+const artifactTypeNestedAssembly = "cdk:cloud-assembly"
+
+// maxAssemblyDepth limits how deeply stage assemblies are nested
+const maxAssemblyDepth = 16
+
+// ValidateAssembly checks that a pre-synthesized cloud assembly can be deployed: the
+// manifest has a known schema version, needs no further context lookups, and every
+// template and asset it references is present, in the nested assemblies of stages too
+func (s *Synthesizer) ValidateAssembly() error {
+	stacks, problems := s.validateAssembly(s.outputDir, "", 0)
+	if stacks == 0 {
+		problems = append(problems, errors.New("no stacks in the cloud assembly"))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("cloud assembly %s cannot be deployed: %w", s.outputDir, errors.Join(problems...))
+	}
+	return nil
+}
+
+// validateAssembly checks the assembly in dir and the nested assemblies of its stages,
+// returning the number of stacks and the problems found. Problems of a nested assembly
+// are prefixed with its path.
+func (s *Synthesizer) validateAssembly(dir, prefix string, depth int) (int, []error) {
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return 0, []error{withPrefix(prefix, err)}
+	}
+
+	var problems []error
+	major, err := assemblyMajorVersion(manifest.Version)
+	if err != nil {
+		return 0, []error{withPrefix(prefix, err)}
+	}
+	if major > maxAssemblyVersion {
+		s.logger.Warn("Cloud assembly schema is newer than known to this deployer",
+			"assembly", orRoot(prefix), "version", manifest.Version, "known", fmt.Sprintf("%d.x", maxAssemblyVersion))
+	}
+
+	if len(manifest.Missing) > 0 {
+		problems = append(problems, withPrefix(prefix, &MissingContextError{Keys: missingKeys(manifest)}))
+	}
+
+	ids := make([]string, 0, len(manifest.Artifacts))
+	for id := range manifest.Artifacts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	stacks := 0
+	for _, id := range ids {
+		artifact := manifest.Artifacts[id]
+		switch artifact.Type {
+		case artifactTypeStack:
+			stacks++
+			template, _ := artifact.Properties["templateFile"].(string)
+			if template == "" {
+				template = id + ".template.json"
+			}
+			if err := checkAssemblyFile(dir, template, false); err != nil {
+				problems = append(problems, withPrefix(prefix, fmt.Errorf("stack %s: template %w", id, err)))
+			}
+		case artifactTypeAssetManifest:
+			file, _ := artifact.Properties["file"].(string)
+			if err := checkAssets(dir, file); err != nil {
+				problems = append(problems, withPrefix(prefix, fmt.Errorf("asset manifest %s: %w", id, err)))
+			}
+		case artifactTypeNestedAssembly:
+			name, _ := artifact.Properties["directoryName"].(string)
+			if err := checkAssemblyFile(dir, name, true); err != nil {
+				problems = append(problems, withPrefix(prefix, fmt.Errorf("nested assembly %s: %w", id, err)))
+				continue
+			}
+			if depth+1 >= maxAssemblyDepth {
+				problems = append(problems, withPrefix(prefix, fmt.Errorf("nested assembly %s is nested too deeply", id)))
+				continue
+			}
+			nested, nestedProblems := s.validateAssembly(filepath.Join(dir, filepath.FromSlash(name)), path.Join(prefix, name), depth+1)
+			stacks += nested
+			problems = append(problems, nestedProblems...)
+		}
+	}
+	return stacks, problems
+}
+
+// withPrefix names the nested assembly a problem was found in
+func withPrefix(prefix string, err error) error {
+	if prefix == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", prefix, err)
+}
+
+// orRoot names an assembly for log messages
+func orRoot(prefix string) string {
+	if prefix == "" {
+		return "."
+	}
+	return prefix
+}
+
+// LFSPointerError reports an asset that contains Git LFS pointer files instead of
+// the content they stand for, which would be deployed as is
+type LFSPointerError struct {
+	Asset string
+	Files []string
+}
+
+func (e *LFSPointerError) Error() string {
+	return fmt.Sprintf("asset %s contains Git LFS pointer files instead of their content: %s "+
+		"(fetch LFS objects when cloning, i.e. drop -no-lfs, or check the LFS server of the repository)",
+		e.Asset, strings.Join(e.Files, ", "))
+}
+
+// checkAssets checks that the sources of the assets listed in an asset manifest are
+// in the assembly. Assets built by a command at publish time have no source to check.
+func checkAssets(dir, file string) error {
+	if err := checkAssemblyFile(dir, file, false); err != nil {
+		return err
+	}
+	manifest, err := readAssetManifest(dir, file)
+	if err != nil {
+		return err
+	}
+
+	var problems []error
+	for _, hash := range assetHashes(manifest.Files) {
+		if p := manifest.Files[hash].Source.Path; p != "" {
+			if err := checkAssemblyFile(dir, p, true); err != nil {
+				problems = append(problems, fmt.Errorf("file asset %s: %w", hash, err))
+			}
+		}
+	}
+	for _, hash := range assetHashes(manifest.DockerImages) {
+		if d := manifest.DockerImages[hash].Source.Directory; d != "" {
+			if err := checkAssemblyFile(dir, d, true); err != nil {
+				problems = append(problems, fmt.Errorf("container image asset %s: %w", hash, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Join(problems...)
+	}
+	return checkAssetContent(dir, manifest)
+}
+
+// readAssetManifest reads an asset manifest of the assembly
+func readAssetManifest(dir, file string) (*assetManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	var manifest assetManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return &manifest, nil
+}
+
+// checkLFSAssets checks the assets of every asset manifest in the assembly for Git
+// LFS pointer files
+func (s *Synthesizer) checkLFSAssets() error {
+	manifest, err := readManifest(s.outputDir)
+	if err != nil {
+		return err
+	}
+	var problems []error
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Type != artifactTypeAssetManifest {
+			continue
+		}
+		file, _ := artifact.Properties["file"].(string)
+		if file == "" || !filepath.IsLocal(filepath.FromSlash(file)) {
+			continue
+		}
+		assets, err := readAssetManifest(s.outputDir, file)
+		if err != nil {
+			return err
+		}
+		if err := checkAssetContent(s.outputDir, assets); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// checkAssetContent checks that the file and container image asset sources of an
+// asset manifest hold no Git LFS pointer files. An asset that was cloned without its
+// LFS objects would otherwise be uploaded with the pointers in place of its content.
+func checkAssetContent(dir string, manifest *assetManifest) error {
+	var problems []error
+	check := func(hash, name string) {
+		if name == "" || !filepath.IsLocal(filepath.FromSlash(name)) {
+			return
+		}
+		files, err := lfsPointers(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("asset %s: %w", hash, err))
+		} else if len(files) > 0 {
+			problems = append(problems, &LFSPointerError{Asset: hash, Files: files})
+		}
+	}
+	for _, hash := range assetHashes(manifest.Files) {
+		check(hash, manifest.Files[hash].Source.Path)
+	}
+	for _, hash := range assetHashes(manifest.DockerImages) {
+		check(hash, manifest.DockerImages[hash].Source.Directory)
+	}
+	return errors.Join(problems...)
+}
+
+// lfsPointers returns the LFS pointer files of an asset source, a file or a directory
+func lfsPointers(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return git.FindLFSPointers(path)
+	}
+	if info.Size() > git.MaxLFSPointerSize {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := git.ParseLFSPointer(data); ok {
+		return []string{filepath.Base(path)}, nil
+	}
+	return nil, nil
+}
+
+// checkAssemblyFile checks that a path referenced by the manifest stays within the
+// assembly and exists. Only asset sources may be directories.
+func checkAssemblyFile(dir, name string, allowDir bool) error {
+	if name == "" {
+		return errors.New("file is not named in the manifest")
+	}
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return fmt.Errorf("%s is outside the assembly", name)
+	}
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return fmt.Errorf("%s is missing", name)
+	}
+	if info.IsDir() && !allowDir {
+		return fmt.Errorf("%s is a directory", name)
+	}
+	return nil
+}
+
+// assemblyMajorVersion parses the major version of a cloud assembly schema version
+// such as 36.0.0
+func assemblyMajorVersion(version string) (int, error) {
+	if version == "" {
+		return 0, errors.New("cloud assembly manifest has no version")
+	}
+	majorPart, _, _ := strings.Cut(version, ".")
+	major, err := strconv.Atoi(majorPart)
+	if err != nil || major < 0 {
+		return 0, fmt.Errorf("cloud assembly manifest has an invalid version %q", version)
+	}
+	return major, nil
+}
+
+// assetHashes returns the hashes of assets in order, for stable error messages
+func assetHashes(m map[string]assetEntry) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,34 +3,130 @@ package cdk
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"time"
+
+	"cdk-deployer/pkg/event"
+	"cdk-deployer/pkg/logging"
 )
 
 // CDK is the main interface for CDK operations
 type CDK struct {
-	projectPath string
-	synthesizer *Synthesizer
-	deployer    *Deployer
+	projectPath     string
+	synthesizer     *Synthesizer
+	deployer        *Deployer
+	deployerOptions []DeployerOption
+	logger          *slog.Logger
+	events          event.Handler
+}
+
+// Option configures a CDK instance
+type Option func(*CDK)
+
+// WithDeployerOptions sets the options used when the deployer is created
+func WithDeployerOptions(opts ...DeployerOption) Option {
+	return func(c *CDK) {
+		c.deployerOptions = append(c.deployerOptions, opts...)
+	}
+}
+
+// WithSynthTimeout limits how long synthesis may run
+func WithSynthTimeout(timeout time.Duration) Option {
+	return func(c *CDK) {
+		c.synthesizer.timeout = timeout
+	}
+}
+
+// WithContext sets CDK context values passed to the app during synthesis
+func WithContext(values map[string]string) Option {
+	return func(c *CDK) {
+		c.synthesizer.context = values
+	}
+}
+
+// WithContextFile seeds cdk.context.json with the values of a file before synthesis
+func WithContextFile(path string) Option {
+	return func(c *CDK) {
+		c.synthesizer.contextSeed = path
+	}
+}
+
+// WithContextCache keeps cdk.context.json in a file outside the clone, restoring it
+// before synthesis and saving new lookups after it
+func WithContextCache(path string) Option {
+	return func(c *CDK) {
+		c.synthesizer.contextCache = path
+	}
+}
+
+// WithSynthMode selects the CDK CLI (the default), native synthesis, or native
+// synthesis with lookups by the CLI
+func WithSynthMode(mode SynthMode) Option {
+	return func(c *CDK) {
+		c.synthesizer.mode = mode
+	}
+}
+
+// WithAssembly uses an existing cloud assembly directory instead of the app's cdk.out.
+// Such an assembly is deployed as is, without Initialize and Synth.
+func WithAssembly(dir string) Option {
+	return func(c *CDK) {
+		c.synthesizer.outputDir = dir
+	}
+}
+
+// WithLogger sets the logger for synthesis and deployment progress; nil keeps the
+// default logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *CDK) {
+		c.logger = logging.OrDefault(logger)
+		c.synthesizer.logger = c.logger
+	}
+}
+
+// WithEventHandler sets the handler receiving install, synth, stack and drift events
+func WithEventHandler(h event.Handler) Option {
+	return func(c *CDK) {
+		c.events = h
+		c.synthesizer.events = h
+	}
+}
+
+// WithEventChannel delivers events to a channel, which must be drained while operations run
+func WithEventChannel(ch chan<- event.Event) Option {
+	return WithEventHandler(event.Channel(ch))
 }
 
 // New creates a new CDK instance for a project
-func New(projectPath string) *CDK {
-	return &CDK{
+func New(projectPath string, opts ...Option) *CDK {
+	c := &CDK{
 		projectPath: projectPath,
 		synthesizer: NewSynthesizer(projectPath),
+		logger:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 // Initialize prepares the CDK project for synthesis
 func (c *CDK) Initialize() error {
+	return c.InitializeContext(context.Background())
+}
+
+// InitializeContext prepares the CDK project for synthesis, stopping the install of
+// its dependencies when ctx is cancelled
+func (c *CDK) InitializeContext(ctx context.Context) error {
 	// Detect project type
 	projectType, err := c.synthesizer.DetectProjectType()
 	if err != nil {
 		return fmt.Errorf("failed to detect project type: %w", err)
 	}
-	fmt.Printf("Detected project type: %s\n", projectType)
+	c.logger.Info("Detected project type", "type", projectType)
 
 	// Install dependencies
-	if err := c.synthesizer.InstallDependencies(projectType); err != nil {
+	if err := c.synthesizer.InstallDependenciesContext(ctx, projectType); err != nil {
 		return fmt.Errorf("failed to install dependencies: %w", err)
 	}
 
@@ -42,14 +138,37 @@ func (c *CDK) Synth() (*SynthResult, error) {
 	return c.synthesizer.Synth()
 }
 
+// SynthContext synthesizes the CDK app, stopping it when ctx is cancelled
+func (c *CDK) SynthContext(ctx context.Context) (*SynthResult, error) {
+	return c.synthesizer.SynthContext(ctx)
+}
+
+// Stacks returns the stacks of the synthesized cloud assembly
+func (c *CDK) Stacks() ([]StackInfo, error) {
+	return c.synthesizer.StackInfos()
+}
+
+// TemplateBody returns the synthesized template of a stack
+func (c *CDK) TemplateBody(stackName string) (string, error) {
+	return c.synthesizer.GetTemplateBody(stackName)
+}
+
+// ReplaceTemplate deploys a stack from the given template instead of the synthesized
+// one. Without a synthesized app the template alone makes up the assembly.
+func (c *CDK) ReplaceTemplate(stackName, templateBody string) error {
+	return c.synthesizer.WriteTemplate(stackName, templateBody)
+}
+
+// ValidateAssembly checks that the cloud assembly given with WithAssembly can be
+// deployed without synthesis
+func (c *CDK) ValidateAssembly() error {
+	return c.synthesizer.ValidateAssembly()
+}
+
 // Deploy deploys all stacks
 func (c *CDK) Deploy(ctx context.Context, stacks []string) ([]DeployResult, error) {
-	if c.deployer == nil {
-		deployer, err := NewDeployer(ctx, c.synthesizer)
-		if err != nil {
-			return nil, err
-		}
-		c.deployer = deployer
+	if err := c.ensureDeployer(ctx); err != nil {
+		return nil, err
 	}
 
 	return c.deployer.DeployAll(ctx, stacks)
@@ -58,31 +177,94 @@ func (c *CDK) Deploy(ctx context.Context, stacks []string) ([]DeployResult, erro
 // SynthAndDeploy synthesizes and deploys all stacks
 func (c *CDK) SynthAndDeploy(ctx context.Context) ([]DeployResult, error) {
 	// Initialize project
-	if err := c.Initialize(); err != nil {
+	if err := c.InitializeContext(ctx); err != nil {
 		return nil, err
 	}
 
 	// Synthesize
-	synthResult, err := c.Synth()
+	synthResult, err := c.SynthContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("synthesis failed: %w", err)
 	}
 
-	fmt.Printf("Synthesized %d stack(s): %v\n", len(synthResult.Stacks), synthResult.Stacks)
+	c.logger.Info("Synthesized stacks", "count", len(synthResult.Stacks), "stacks", synthResult.Stacks)
 
 	// Deploy
 	return c.Deploy(ctx, synthResult.Stacks)
 }
 
+// Destroy deletes the given stacks, dependent stacks first
+func (c *CDK) Destroy(ctx context.Context, stacks []string) error {
+	if err := c.ensureDeployer(ctx); err != nil {
+		return err
+	}
+
+	return c.deployer.DestroyAll(ctx, stacks)
+}
+
 // DetectDrift detects drift for specified stacks
 func (c *CDK) DetectDrift(ctx context.Context, stacks []string) ([]DriftResult, error) {
-	if c.deployer == nil {
-		deployer, err := NewDeployer(ctx, c.synthesizer)
+	if err := c.ensureDeployer(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.deployer.DetectDriftAll(ctx, stacks)
+}
+
+// RemediateDrift remediates the drifted resources reported for a stack
+func (c *CDK) RemediateDrift(ctx context.Context, drift *DriftResult, opts RemediationOptions) (*RemediationResult, error) {
+	if err := c.ensureDeployer(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.deployer.RemediateDrift(ctx, drift, opts)
+}
+
+// Import adopts existing resources into the given stacks before deploying them
+func (c *CDK) Import(ctx context.Context, stacks []string, mapping ImportMapping, prompter Prompter) ([]ImportResult, error) {
+	if err := c.ensureDeployer(ctx); err != nil {
+		return nil, err
+	}
+
+	var results []ImportResult
+	for _, stackName := range stacks {
+		result, err := c.deployer.Import(ctx, stackName, ImportOptions{
+			Identifiers: mapping[stackName],
+			Prompter:    prompter,
+		})
 		if err != nil {
-			return nil, err
+			return results, fmt.Errorf("failed to import into stack %s: %w", stackName, err)
 		}
-		c.deployer = deployer
+		results = append(results, *result)
 	}
 
-	return c.deployer.DetectDriftAll(ctx, stacks)
+	return results, nil
+}
+
+// CallerIdentity returns the AWS identity and region deployments run as
+func (c *CDK) CallerIdentity(ctx context.Context) (*CallerIdentity, string, error) {
+	if err := c.ensureDeployer(ctx); err != nil {
+		return nil, "", err
+	}
+	identity, err := c.deployer.CallerIdentity(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return identity, c.deployer.Region(), nil
+}
+
+// ensureDeployer lazily creates the CloudFormation deployer
+func (c *CDK) ensureDeployer(ctx context.Context) error {
+	if c.deployer != nil {
+		return nil
+	}
+
+	// The CDK logger and event handler are the defaults; explicit deployer options take precedence
+	opts := append([]DeployerOption{WithDeployerLogger(c.logger), WithDeployerEventHandler(c.events)}, c.deployerOptions...)
+	deployer, err := NewDeployer(ctx, c.synthesizer, opts...)
+	if err != nil {
+		return err
+	}
+	c.deployer = deployer
+	return nil
 }
@@ -0,0 +1,245 @@
+package cdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/smithy-go"
+)
+
+// changeSetRequest describes a change set to be created
+type changeSetRequest struct {
+	StackName     string
+	ChangeSetName string
+	ChangeSetType types.ChangeSetType
+	TemplateBody  string
+	Description   string
+	// ResourcesToImport is required for IMPORT change sets
+	ResourcesToImport []types.ResourceToImport
+	// Parameters replace the configured parameter values when set
+	Parameters []types.Parameter
+}
+
+// createChangeSet creates a change set and waits until it is ready for review
+func (d *Deployer) createChangeSet(ctx context.Context, req changeSetRequest) (*ChangeSetSummary, error) {
+	d.stackLogger(req.StackName).Info("Creating change set", "changeSet", req.ChangeSetName)
+
+	opts := d.stackOptions(req.StackName)
+	input := &cloudformation.CreateChangeSetInput{
+		StackName:     aws.String(req.StackName),
+		ChangeSetName: aws.String(req.ChangeSetName),
+		ChangeSetType: req.ChangeSetType,
+		TemplateBody:  aws.String(req.TemplateBody),
+		Capabilities: []types.Capability{
+			types.CapabilityCapabilityIam,
+			types.CapabilityCapabilityNamedIam,
+			types.CapabilityCapabilityAutoExpand,
+		},
+		RollbackConfiguration: opts.rollbackConfiguration(),
+		Parameters:            opts.parameters(),
+		Tags:                  opts.tags(),
+		RoleARN:               opts.roleARN(),
+	}
+	if req.Description != "" {
+		input.Description = aws.String(req.Description)
+	}
+	if len(req.ResourcesToImport) > 0 {
+		input.ResourcesToImport = req.ResourcesToImport
+	}
+	if len(req.Parameters) > 0 {
+		input.Parameters = req.Parameters
+	}
+	if req.ChangeSetType == types.ChangeSetTypeCreate {
+		// Mirrors the OnFailure handling of createStack
+		if opts.rollbackDisabled() {
+			input.OnStackFailure = types.OnStackFailureDoNothing
+		} else if opts.OnFailure != "" {
+			input.OnStackFailure = types.OnStackFailure(opts.OnFailure)
+		}
+	}
+
+	output, err := d.cfnClient.CreateChangeSet(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create change set: %w", err)
+	}
+
+	return d.waitForChangeSet(ctx, req.StackName, aws.ToString(output.Id))
+}
+
+// waitForChangeSet waits for a change set to finish creating and returns its summary
+func (d *Deployer) waitForChangeSet(ctx context.Context, stackName, changeSetID string) (*ChangeSetSummary, error) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	timeout := time.After(10 * time.Minute)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout:
+			return nil, fmt.Errorf("timeout waiting for change set %s", changeSetID)
+		case <-ticker.C:
+			summary, err := d.describeChangeSet(ctx, stackName, changeSetID)
+			if err != nil {
+				return nil, err
+			}
+
+			switch types.ChangeSetStatus(summary.Status) {
+			case types.ChangeSetStatusCreateComplete:
+				return summary, nil
+			case types.ChangeSetStatusFailed:
+				// CloudFormation refuses to create empty change sets; that is not an error for us
+				if isEmptyChangeSet(summary) {
+					return summary, nil
+				}
+				return summary, fmt.Errorf("change set failed: %s", summary.StatusReason)
+			}
+		}
+	}
+}
+
+// describeChangeSet returns the status and resource changes of a change set
+func (d *Deployer) describeChangeSet(ctx context.Context, stackName, changeSetID string) (*ChangeSetSummary, error) {
+	summary := &ChangeSetSummary{
+		StackName: stackName,
+	}
+
+	var nextToken *string
+	for {
+		output, err := d.cfnClient.DescribeChangeSet(ctx, &cloudformation.DescribeChangeSetInput{
+			StackName:     aws.String(stackName),
+			ChangeSetName: aws.String(changeSetID),
+			NextToken:     nextToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe change set: %w", err)
+		}
+
+		summary.ChangeSetID = aws.ToString(output.ChangeSetId)
+		summary.ChangeSetName = aws.ToString(output.ChangeSetName)
+		summary.Status = string(output.Status)
+		summary.StatusReason = aws.ToString(output.StatusReason)
+		summary.ExecutionStatus = string(output.ExecutionStatus)
+
+		for _, c := range output.Changes {
+			if c.ResourceChange == nil {
+				continue
+			}
+			rc := c.ResourceChange
+			summary.Changes = append(summary.Changes, ResourceChange{
+				Action:       string(rc.Action),
+				LogicalID:    aws.ToString(rc.LogicalResourceId),
+				PhysicalID:   aws.ToString(rc.PhysicalResourceId),
+				ResourceType: aws.ToString(rc.ResourceType),
+				Replacement:  string(rc.Replacement),
+			})
+		}
+
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+
+	return summary, nil
+}
+
+// executeChangeSet executes a change set and waits for the stack operation to complete
+func (d *Deployer) executeChangeSet(ctx context.Context, summary *ChangeSetSummary) (string, error) {
+	d.stackLogger(summary.StackName).Info("Executing change set", "changeSet", summary.ChangeSetName)
+	d.emitStackStarted(summary.StackName, "execute-change-set")
+
+	input := &cloudformation.ExecuteChangeSetInput{
+		StackName:     aws.String(summary.StackName),
+		ChangeSetName: aws.String(summary.ChangeSetID),
+	}
+	if d.stackOptions(summary.StackName).rollbackDisabled() {
+		input.DisableRollback = aws.Bool(true)
+	}
+
+	_, err := d.cfnClient.ExecuteChangeSet(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute change set: %w", err)
+	}
+
+	return d.waitForStack(ctx, summary.StackName)
+}
+
+// deleteChangeSet removes a change set that will not be executed
+func (d *Deployer) deleteChangeSet(ctx context.Context, summary *ChangeSetSummary) error {
+	_, err := d.cfnClient.DeleteChangeSet(ctx, &cloudformation.DeleteChangeSetInput{
+		StackName:     aws.String(summary.StackName),
+		ChangeSetName: aws.String(summary.ChangeSetID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete change set: %w", err)
+	}
+	return nil
+}
+
+// isEmptyChangeSet reports whether a failed change set failed only because it was
+// empty. The API reports this only in the status reason, so the reason must be one of
+// the messages for an empty change set and the change set must neither list changes
+// nor be executable.
+func isEmptyChangeSet(summary *ChangeSetSummary) bool {
+	if summary.HasChanges() || summary.ExecutionStatus != string(types.ExecutionStatusUnavailable) {
+		return false
+	}
+	return strings.HasPrefix(summary.StatusReason, "The submitted information didn't contain changes.") ||
+		strings.HasPrefix(summary.StatusReason, "No updates are to be performed.")
+}
+
+// isNoUpdatesError reports whether UpdateStack was rejected because the stack is
+// already up to date, which CloudFormation reports as a ValidationError
+func isNoUpdatesError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationError" &&
+		strings.HasPrefix(apiErr.ErrorMessage(), "No updates are to be performed")
+}
+
+// PrintChangeSet writes a human-readable preview of a change set
+func PrintChangeSet(w io.Writer, summary *ChangeSetSummary) {
+	fmt.Fprintf(w, "\nChange set %s for stack %s:\n", summary.ChangeSetName, summary.StackName)
+	if !summary.HasChanges() {
+		fmt.Fprintln(w, "  (no changes)")
+		return
+	}
+	for _, c := range summary.Changes {
+		fmt.Fprintf(w, "  %-8s %s (%s)%s\n", c.Action, c.LogicalID, c.ResourceType, replacementLabel(c))
+	}
+}
+
+// logChangeSet logs the preview of a change set, one record per change
+func (d *Deployer) logChangeSet(summary *ChangeSetSummary) {
+	logger := d.stackLogger(summary.StackName).With("changeSet", summary.ChangeSetName)
+	if !summary.HasChanges() {
+		logger.Info("Change set contains no changes")
+		return
+	}
+	for _, c := range summary.Changes {
+		attrs := []any{"action", c.Action, "logicalId", c.LogicalID, "type", c.ResourceType}
+		if label := replacementLabel(c); label != "" {
+			attrs = append(attrs, "replacement", c.Replacement)
+		}
+		logger.Info("Change set change", attrs...)
+	}
+}
+
+// replacementLabel marks changes that replace the resource
+func replacementLabel(c ResourceChange) string {
+	switch c.Replacement {
+	case string(types.ReplacementTrue):
+		return " [REPLACEMENT]"
+	case string(types.ReplacementConditional):
+		return " [POSSIBLE REPLACEMENT]"
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package cdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// orderByDependencies returns the stacks sorted so that every stack comes after the
+// selected stacks it depends on, keeping the original order otherwise
+func orderByDependencies(stacks []string, deps map[string][]string) []string {
+	selected := make(map[string]bool, len(stacks))
+	for _, s := range stacks {
+		selected[s] = true
+	}
+
+	ordered := make([]string, 0, len(stacks))
+	visited := make(map[string]bool, len(stacks))
+
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, dep := range deps[name] {
+			if selected[dep] {
+				visit(dep)
+			}
+		}
+		ordered = append(ordered, name)
+	}
+
+	for _, s := range stacks {
+		visit(s)
+	}
+	return ordered
+}
+
+// deployConcurrently deploys up to d.concurrency stacks at a time. A stack starts once
+// all of its selected dependencies are deployed and is skipped if one of them failed.
+func (d *Deployer) deployConcurrently(ctx context.Context, stacks []string, deps map[string][]string) ([]DeployResult, error) {
+	done := make(map[string]chan struct{}, len(stacks))
+	for _, s := range stacks {
+		done[s] = make(chan struct{})
+	}
+
+	var (
+		mu      sync.Mutex
+		failed  = make(map[string]bool)
+		results = make([]*DeployResult, len(stacks))
+		errs    = make([]error, len(stacks))
+		sem     = make(chan struct{}, d.concurrency)
+		wg      sync.WaitGroup
+	)
+
+	for i, stackName := range stacks {
+		wg.Add(1)
+		go func(i int, stackName string) {
+			defer wg.Done()
+			defer close(done[stackName])
+
+			fail := func(err error) {
+				mu.Lock()
+				failed[stackName] = true
+				mu.Unlock()
+				errs[i] = fmt.Errorf("failed to deploy stack %s: %w", stackName, err)
+			}
+
+			for _, dep := range deps[stackName] {
+				ch, ok := done[dep]
+				if !ok {
+					continue
+				}
+				select {
+				case <-ch:
+				case <-ctx.Done():
+					fail(ctx.Err())
+					return
+				}
+				mu.Lock()
+				depFailed := failed[dep]
+				mu.Unlock()
+				if depFailed {
+					fail(fmt.Errorf("dependency %s failed", dep))
+					return
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				fail(ctx.Err())
+				return
+			}
+			defer func() { <-sem }()
+
+			result, err := d.Deploy(ctx, stackName)
+			if err != nil {
+				fail(err)
+				return
+			}
+			results[i] = result
+		}(i, stackName)
+	}
+
+	wg.Wait()
+
+	var deployed []DeployResult
+	for _, r := range results {
+		if r != nil {
+			deployed = append(deployed, *r)
+		}
+	}
+	return deployed, errors.Join(errs...)
+}
@@ -0,0 +1,132 @@
+package cdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// contextFileName is the file the CDK CLI caches context lookups in
+const contextFileName = "cdk.context.json"
+
+// ContextValues are the entries of a cdk.context.json file
+type ContextValues map[string]interface{}
+
+// LoadContextFile reads a cdk.context.json file. A missing file yields no values.
+func LoadContextFile(path string) (ContextValues, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ContextValues{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context file: %w", err)
+	}
+
+	values := ContextValues{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse context file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Save writes the values as a cdk.context.json file
+func (v ContextValues) Save(path string) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode context: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create context directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write context file: %w", err)
+	}
+	return nil
+}
+
+// Keys returns the keys in a stable order
+func (v ContextValues) Keys() []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reset removes the keys matching a glob pattern, where '*' matches any characters,
+// and returns them
+func (v ContextValues) Reset(pattern string) []string {
+	var removed []string
+	for _, k := range v.Keys() {
+		if matchFlat(pattern, k) {
+			delete(v, k)
+			removed = append(removed, k)
+		}
+	}
+	return removed
+}
+
+// IsLookupKey reports whether a context key holds the result of an environment
+// lookup such as vpc-provider or ami, rather than a user-supplied value. Lookup
+// keys are scoped to the account and region they were made in.
+func IsLookupKey(key string) bool {
+	return strings.Contains(key, ":account=")
+}
+
+// restoreContext seeds the project's cdk.context.json with the seed file and the
+// cache, so lookups done by earlier runs are not repeated
+func (s *Synthesizer) restoreContext() error {
+	if s.contextSeed == "" && s.contextCache == "" {
+		return nil
+	}
+
+	projectFile := filepath.Join(s.projectPath, contextFileName)
+	values, err := LoadContextFile(projectFile)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{s.contextSeed, s.contextCache} {
+		if path == "" {
+			continue
+		}
+		cached, err := LoadContextFile(path)
+		if err != nil {
+			return err
+		}
+		for k, val := range cached {
+			values[k] = val
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+	s.logger.Info("Restored context values", "count", len(values), "file", contextFileName)
+	return values.Save(projectFile)
+}
+
+// persistContext copies the project's cdk.context.json, including new lookups, to the cache
+func (s *Synthesizer) persistContext() error {
+	if s.contextCache == "" {
+		return nil
+	}
+
+	values, err := LoadContextFile(filepath.Join(s.projectPath, contextFileName))
+	if err != nil {
+		return err
+	}
+	if err := values.Save(s.contextCache); err != nil {
+		return err
+	}
+	s.logger.Info("Saved context values", "count", len(values), "file", s.contextCache)
+	return nil
+}
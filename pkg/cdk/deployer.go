@@ -2,24 +2,135 @@ package cdk
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"cdk-deployer/pkg/event"
+	"cdk-deployer/pkg/lock"
+	"cdk-deployer/pkg/logging"
 )
 
 // Deployer handles CloudFormation deployment operations
 type Deployer struct {
 	cfnClient   *cloudformation.Client
 	synthesizer *Synthesizer
+	region      string
+	recovery    RecoveryOptions
+	options     StackOptionsFunc
+	concurrency int
+	timeouts    Timeouts
+	approval    ApprovalPolicy
+	prompter    Prompter
+	logger      *slog.Logger
+	events      event.Handler
+	locker      lock.Locker
+	lockOptions LockOptions
+
+	stsClient  *sts.Client
+	identityMu sync.Mutex
+	identity   *CallerIdentity
+}
+
+// DeployerOption configures a Deployer
+type DeployerOption func(*deployerOptions)
+
+// deployerOptions holds the settings collected from DeployerOptions
+type deployerOptions struct {
+	region      string
+	recovery    RecoveryOptions
+	options     StackOptionsFunc
+	concurrency int
+	timeouts    Timeouts
+	approval    ApprovalPolicy
+	prompter    Prompter
+	logger      *slog.Logger
+	events      event.Handler
+	locker      lock.Locker
+	lockOptions LockOptions
+}
+
+// WithRegion overrides the AWS region from the default configuration
+func WithRegion(region string) DeployerOption {
+	return func(o *deployerOptions) {
+		o.region = region
+	}
+}
+
+// WithRecovery configures the handling of stacks stuck in failed states
+func WithRecovery(recovery RecoveryOptions) DeployerOption {
+	return func(o *deployerOptions) {
+		o.recovery = recovery
+	}
+}
+
+// WithStackOptions sets the resolver for per-stack deployment options
+func WithStackOptions(fn StackOptionsFunc) DeployerOption {
+	return func(o *deployerOptions) {
+		o.options = fn
+	}
+}
+
+// WithConcurrency sets how many stacks DeployAll deploys in parallel
+func WithConcurrency(n int) DeployerOption {
+	return func(o *deployerOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithTimeouts overrides the default operation timeouts
+func WithTimeouts(timeouts Timeouts) DeployerOption {
+	return func(o *deployerOptions) {
+		o.timeouts = timeouts
+	}
+}
+
+// WithApproval sets the approval policy and the prompter used to ask for approval
+func WithApproval(policy ApprovalPolicy, prompter Prompter) DeployerOption {
+	return func(o *deployerOptions) {
+		o.approval = policy
+		o.prompter = prompter
+	}
+}
+
+// WithDeployerLogger sets the logger for deployment progress
+func WithDeployerLogger(logger *slog.Logger) DeployerOption {
+	return func(o *deployerOptions) {
+		o.logger = logger
+	}
+}
+
+// WithDeployerEventHandler sets the handler receiving stack and drift events
+func WithDeployerEventHandler(h event.Handler) DeployerOption {
+	return func(o *deployerOptions) {
+		o.events = h
+	}
 }
 
 // NewDeployer creates a new CloudFormation deployer
-func NewDeployer(ctx context.Context, synthesizer *Synthesizer) (*Deployer, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+func NewDeployer(ctx context.Context, synthesizer *Synthesizer, opts ...DeployerOption) (*Deployer, error) {
+	var options deployerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := options.approval.Validate(); err != nil {
+		return nil, err
+	}
+
+	var loadOpts []func(*config.LoadOptions) error
+	if options.region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(options.region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
@@ -27,22 +138,100 @@ func NewDeployer(ctx context.Context, synthesizer *Synthesizer) (*Deployer, erro
 	return &Deployer{
 		cfnClient:   cloudformation.NewFromConfig(cfg),
 		synthesizer: synthesizer,
+		region:      cfg.Region,
+		recovery:    options.recovery,
+		options:     options.options,
+		concurrency: options.concurrency,
+		timeouts:    options.timeouts,
+		approval:    options.approval,
+		prompter:    options.prompter,
+		logger:      logging.OrDefault(options.logger),
+		events:      options.events,
+		locker:      options.locker,
+		lockOptions: options.lockOptions,
+		stsClient:   sts.NewFromConfig(cfg),
 	}, nil
 }
 
+// stackLogger returns the logger with the stack name attached
+func (d *Deployer) stackLogger(stackName string) *slog.Logger {
+	return d.logger.With("stack", stackName)
+}
+
+// Region returns the AWS region the deployer operates in
+func (d *Deployer) Region() string {
+	return d.region
+}
+
+// CallerIdentity returns the AWS identity of the deployer's credentials. Only a
+// successful lookup is cached, so a failed or cancelled one is retried by the next call.
+func (d *Deployer) CallerIdentity(ctx context.Context) (*CallerIdentity, error) {
+	d.identityMu.Lock()
+	defer d.identityMu.Unlock()
+	if d.identity != nil {
+		return d.identity, nil
+	}
+
+	out, err := d.stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine AWS caller identity: %w", err)
+	}
+	d.identity = &CallerIdentity{
+		Account: aws.ToString(out.Account),
+		ARN:     aws.ToString(out.Arn),
+		UserID:  aws.ToString(out.UserId),
+	}
+	return d.identity, nil
+}
+
+// stackOptions returns the deployment options for a stack
+func (d *Deployer) stackOptions(stackName string) StackOptions {
+	if d.options == nil {
+		return StackOptions{}
+	}
+	return d.options(stackName)
+}
+
 // Deploy deploys a CloudFormation stack
 func (d *Deployer) Deploy(ctx context.Context, stackName string) (*DeployResult, error) {
+	ctx, unlock, err := d.lockStack(ctx, stackName, "deploy")
+	if err != nil {
+		d.events.Emit(event.Event{Type: event.StackFailed, Stack: stackName, Err: err})
+		return nil, err
+	}
+	defer unlock()
+
+	result, err := d.deploy(ctx, stackName)
+	if err != nil {
+		d.events.Emit(event.Event{Type: event.StackFailed, Stack: stackName, Err: err})
+		return nil, err
+	}
+
+	d.events.Emit(event.Event{Type: event.StackCompleted, Stack: stackName, Status: result.Status})
+	return result, nil
+}
+
+// deploy creates or updates a stack, recovering it first if needed
+func (d *Deployer) deploy(ctx context.Context, stackName string) (*DeployResult, error) {
 	templateBody, err := d.synthesizer.GetTemplateBody(stackName)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check if stack exists
-	exists, err := d.stackExists(ctx, stackName)
+	if err := d.stackOptions(stackName).Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options for stack %s: %w", stackName, err)
+	}
+
+	// Check if stack exists and bring stuck stacks into a deployable state
+	exists, err := d.prepareStack(ctx, stackName)
 	if err != nil {
 		return nil, err
 	}
 
+	if d.approval == ApprovalAlways || d.approval == ApprovalDestructive || d.approval == ApprovalPreview {
+		return d.deployWithApproval(ctx, stackName, templateBody, exists)
+	}
+
 	var stackID string
 	if exists {
 		stackID, err = d.updateStack(ctx, stackName, templateBody)
@@ -50,14 +239,24 @@ func (d *Deployer) Deploy(ctx context.Context, stackName string) (*DeployResult,
 		stackID, err = d.createStack(ctx, stackName, templateBody)
 	}
 
-	if err != nil {
-		return nil, err
-	}
+	var status string
+	if errors.Is(err, errNoUpdates) {
+		d.stackLogger(stackName).Info("Stack is already up to date")
+		status, err = d.getStackStatus(ctx, stackName)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if err != nil {
+			return nil, err
+		}
 
-	// Wait for stack operation to complete
-	status, err := d.waitForStack(ctx, stackName)
-	if err != nil {
-		return nil, err
+		// Wait for stack operation to complete
+		status, err = d.waitForStack(ctx, stackName)
+		if err != nil {
+			d.handleFailedOperation(ctx, stackName, status)
+			return nil, err
+		}
 	}
 
 	// Get stack outputs
@@ -91,8 +290,10 @@ func (d *Deployer) stackExists(ctx context.Context, stackName string) (bool, err
 
 // createStack creates a new CloudFormation stack
 func (d *Deployer) createStack(ctx context.Context, stackName, templateBody string) (string, error) {
-	fmt.Printf("Creating stack: %s\n", stackName)
+	d.stackLogger(stackName).Info("Creating stack")
+	d.emitStackStarted(stackName, "create")
 
+	opts := d.stackOptions(stackName)
 	input := &cloudformation.CreateStackInput{
 		StackName:    aws.String(stackName),
 		TemplateBody: aws.String(templateBody),
@@ -101,7 +302,19 @@ func (d *Deployer) createStack(ctx context.Context, stackName, templateBody stri
 			types.CapabilityCapabilityNamedIam,
 			types.CapabilityCapabilityAutoExpand,
 		},
-		OnFailure: types.OnFailureRollback,
+		RollbackConfiguration: opts.rollbackConfiguration(),
+		Parameters:            opts.parameters(),
+		Tags:                  opts.tags(),
+		RoleARN:               opts.roleARN(),
+	}
+
+	// DisableRollback and OnFailure are mutually exclusive
+	if opts.rollbackDisabled() {
+		input.DisableRollback = aws.Bool(true)
+	} else if opts.OnFailure != "" {
+		input.OnFailure = types.OnFailure(opts.OnFailure)
+	} else {
+		input.OnFailure = types.OnFailureRollback
 	}
 
 	output, err := d.cfnClient.CreateStack(ctx, input)
@@ -112,10 +325,15 @@ func (d *Deployer) createStack(ctx context.Context, stackName, templateBody stri
 	return aws.ToString(output.StackId), nil
 }
 
+// errNoUpdates is returned by updateStack when the template matches the deployed stack
+var errNoUpdates = errors.New("no updates are to be performed")
+
 // updateStack updates an existing CloudFormation stack
 func (d *Deployer) updateStack(ctx context.Context, stackName, templateBody string) (string, error) {
-	fmt.Printf("Updating stack: %s\n", stackName)
+	d.stackLogger(stackName).Info("Updating stack")
+	d.emitStackStarted(stackName, "update")
 
+	opts := d.stackOptions(stackName)
 	input := &cloudformation.UpdateStackInput{
 		StackName:    aws.String(stackName),
 		TemplateBody: aws.String(templateBody),
@@ -124,10 +342,20 @@ func (d *Deployer) updateStack(ctx context.Context, stackName, templateBody stri
 			types.CapabilityCapabilityNamedIam,
 			types.CapabilityCapabilityAutoExpand,
 		},
+		RollbackConfiguration: opts.rollbackConfiguration(),
+		Parameters:            opts.parameters(),
+		Tags:                  opts.tags(),
+		RoleARN:               opts.roleARN(),
+	}
+	if opts.rollbackDisabled() {
+		input.DisableRollback = aws.Bool(true)
 	}
 
 	output, err := d.cfnClient.UpdateStack(ctx, input)
 	if err != nil {
+		if isNoUpdatesError(err) {
+			return "", errNoUpdates
+		}
 		return "", fmt.Errorf("failed to update stack: %w", err)
 	}
 
@@ -136,16 +364,25 @@ func (d *Deployer) updateStack(ctx context.Context, stackName, templateBody stri
 
 // waitForStack waits for a stack operation to complete
 func (d *Deployer) waitForStack(ctx context.Context, stackName string) (string, error) {
-	fmt.Printf("Waiting for stack %s to complete...\n", stackName)
+	logger := d.stackLogger(stackName)
+	logger.Info("Waiting for stack operation to complete")
 
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	timeout := time.After(30 * time.Minute)
+	timeout := time.After(d.stackTimeout())
+
+	var tracker *stackEventTracker
+	if d.events != nil {
+		tracker = newStackEventTracker(stackName)
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
+			if d.recovery.CancelOnInterrupt {
+				d.cancelUpdate(stackName)
+			}
 			return "", ctx.Err()
 		case <-timeout:
 			return "", fmt.Errorf("timeout waiting for stack %s", stackName)
@@ -155,19 +392,26 @@ func (d *Deployer) waitForStack(ctx context.Context, stackName string) (string,
 				return "", err
 			}
 
-			fmt.Printf("Stack status: %s\n", status)
+			logger.Info("Stack status", "status", status)
+			if tracker != nil {
+				d.emitResourceEvents(ctx, tracker)
+			}
 
 			switch status {
 			case string(types.StackStatusCreateComplete),
-				string(types.StackStatusUpdateComplete):
+				string(types.StackStatusUpdateComplete),
+				string(types.StackStatusImportComplete):
 				return status, nil
 			case string(types.StackStatusCreateFailed),
+				string(types.StackStatusUpdateFailed),
 				string(types.StackStatusRollbackComplete),
 				string(types.StackStatusRollbackFailed),
 				string(types.StackStatusUpdateRollbackComplete),
 				string(types.StackStatusUpdateRollbackFailed),
 				string(types.StackStatusDeleteComplete),
-				string(types.StackStatusDeleteFailed):
+				string(types.StackStatusDeleteFailed),
+				string(types.StackStatusImportRollbackComplete),
+				string(types.StackStatusImportRollbackFailed):
 				return status, fmt.Errorf("stack operation failed with status: %s", status)
 			}
 		}
@@ -192,6 +436,43 @@ func (d *Deployer) getStackStatus(ctx context.Context, stackName string) (string
 	return string(output.Stacks[0].StackStatus), nil
 }
 
+// getStackID returns the unique ID of a stack
+func (d *Deployer) getStackID(ctx context.Context, stackName string) (string, error) {
+	output, err := d.cfnClient.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe stack: %w", err)
+	}
+	if len(output.Stacks) == 0 {
+		return "", fmt.Errorf("stack %s not found", stackName)
+	}
+	return aws.ToString(output.Stacks[0].StackId), nil
+}
+
+// getPreviousParameters returns the parameters of a deployed stack set to keep their
+// current values
+func (d *Deployer) getPreviousParameters(ctx context.Context, stackName string) ([]types.Parameter, error) {
+	output, err := d.cfnClient.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe stack: %w", err)
+	}
+	if len(output.Stacks) == 0 {
+		return nil, fmt.Errorf("stack %s not found", stackName)
+	}
+
+	var params []types.Parameter
+	for _, p := range output.Stacks[0].Parameters {
+		params = append(params, types.Parameter{
+			ParameterKey:     p.ParameterKey,
+			UsePreviousValue: aws.Bool(true),
+		})
+	}
+	return params, nil
+}
+
 // getStackOutputs returns the outputs of a stack
 func (d *Deployer) getStackOutputs(ctx context.Context, stackName string) ([]StackOutput, error) {
 	input := &cloudformation.DescribeStacksInput{
@@ -218,8 +499,31 @@ func (d *Deployer) getStackOutputs(ctx context.Context, stackName string) ([]Sta
 	return outputs, nil
 }
 
-// DeployAll deploys all stacks from the synthesized output
+// stackTimeout returns how long to wait for a stack operation
+func (d *Deployer) stackTimeout() time.Duration {
+	if d.timeouts.Stack > 0 {
+		return d.timeouts.Stack
+	}
+	return 30 * time.Minute
+}
+
+// driftTimeout returns how long to wait for drift detection
+func (d *Deployer) driftTimeout() time.Duration {
+	if d.timeouts.Drift > 0 {
+		return d.timeouts.Drift
+	}
+	return 10 * time.Minute
+}
+
+// DeployAll deploys all stacks from the synthesized output, dependencies first
 func (d *Deployer) DeployAll(ctx context.Context, stacks []string) ([]DeployResult, error) {
+	deps := d.synthesizer.stackDependencies()
+	stacks = orderByDependencies(stacks, deps)
+
+	if d.concurrency > 1 {
+		return d.deployConcurrently(ctx, stacks, deps)
+	}
+
 	var results []DeployResult
 
 	for _, stackName := range stacks {
@@ -233,6 +537,51 @@ func (d *Deployer) DeployAll(ctx context.Context, stacks []string) ([]DeployResu
 	return results, nil
 }
 
+// Destroy deletes a stack, doing nothing if it does not exist
+func (d *Deployer) Destroy(ctx context.Context, stackName string) error {
+	ctx, unlock, err := d.lockStack(ctx, stackName, "destroy")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	exists, err := d.stackExists(ctx, stackName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		d.stackLogger(stackName).Info("Stack does not exist, nothing to destroy")
+		return nil
+	}
+
+	d.emitStackStarted(stackName, "delete")
+	if err := d.deleteStack(ctx, stackName); err != nil {
+		d.events.Emit(event.Event{Type: event.StackFailed, Stack: stackName, Operation: "delete", Err: err})
+		return err
+	}
+
+	d.events.Emit(event.Event{
+		Type:      event.StackCompleted,
+		Stack:     stackName,
+		Operation: "delete",
+		Status:    string(types.StackStatusDeleteComplete),
+	})
+	return nil
+}
+
+// DestroyAll deletes stacks, dependent stacks before the stacks they depend on
+func (d *Deployer) DestroyAll(ctx context.Context, stacks []string) error {
+	ordered := orderByDependencies(stacks, d.synthesizer.stackDependencies())
+
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if err := d.Destroy(ctx, ordered[i]); err != nil {
+			return fmt.Errorf("failed to destroy stack %s: %w", ordered[i], err)
+		}
+	}
+
+	return nil
+}
+
 // DetectDrift initiates drift detection for a stack and returns the results
 func (d *Deployer) DetectDrift(ctx context.Context, stackName string) (*DriftResult, error) {
 	// Check if stack exists
@@ -244,7 +593,8 @@ func (d *Deployer) DetectDrift(ctx context.Context, stackName string) (*DriftRes
 		return nil, fmt.Errorf("stack %s does not exist", stackName)
 	}
 
-	fmt.Printf("Initiating drift detection for stack: %s\n", stackName)
+	logger := d.stackLogger(stackName)
+	logger.Info("Initiating drift detection")
 
 	// Start drift detection
 	detectInput := &cloudformation.DetectStackDriftInput{
@@ -257,10 +607,10 @@ func (d *Deployer) DetectDrift(ctx context.Context, stackName string) (*DriftRes
 	}
 
 	driftDetectionId := aws.ToString(detectOutput.StackDriftDetectionId)
-	fmt.Printf("Drift detection started (ID: %s)\n", driftDetectionId)
+	logger.Info("Drift detection started", "detectionId", driftDetectionId)
 
 	// Wait for drift detection to complete
-	status, err := d.waitForDriftDetection(ctx, driftDetectionId)
+	status, err := d.waitForDriftDetection(ctx, logger, driftDetectionId)
 	if err != nil {
 		return nil, err
 	}
@@ -270,17 +620,28 @@ func (d *Deployer) DetectDrift(ctx context.Context, stackName string) (*DriftRes
 	}
 
 	// Get drift detection results
-	return d.getDriftResults(ctx, stackName)
+	result, err := d.getDriftResults(ctx, stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := &event.Drift{Status: result.DriftStatus}
+	for _, dr := range result.DriftedResources {
+		drift.DriftedResources = append(drift.DriftedResources, dr.LogicalID)
+	}
+	d.events.Emit(event.Event{Type: event.DriftResult, Stack: stackName, Status: result.DriftStatus, Drift: drift})
+
+	return result, nil
 }
 
 // waitForDriftDetection waits for drift detection to complete
-func (d *Deployer) waitForDriftDetection(ctx context.Context, driftDetectionId string) (string, error) {
-	fmt.Println("Waiting for drift detection to complete...")
+func (d *Deployer) waitForDriftDetection(ctx context.Context, logger *slog.Logger, driftDetectionId string) (string, error) {
+	logger.Info("Waiting for drift detection to complete")
 
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
-	timeout := time.After(10 * time.Minute)
+	timeout := time.After(d.driftTimeout())
 
 	for {
 		select {
@@ -299,7 +660,7 @@ func (d *Deployer) waitForDriftDetection(ctx context.Context, driftDetectionId s
 			}
 
 			status := string(output.DetectionStatus)
-			fmt.Printf("Drift detection status: %s\n", status)
+			logger.Info("Drift detection status", "status", status)
 
 			switch output.DetectionStatus {
 			case types.StackDriftDetectionStatusDetectionComplete:
@@ -0,0 +1,232 @@
+package cdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DriftIgnoreRule suppresses expected drift. Empty patterns match anything; a rule
+// without a property path suppresses the whole resource.
+type DriftIgnoreRule struct {
+	Stack        string `json:"stack"`
+	LogicalID    string `json:"logicalId"`
+	ResourceType string `json:"resourceType"`
+	PropertyPath string `json:"propertyPath"`
+	Reason       string `json:"reason"`
+	// Expires is a date (YYYY-MM-DD) after which the rule no longer applies
+	Expires string `json:"expires"`
+
+	expiresAt time.Time
+	// expiryLogged records that the expiry was reported, so a watch warns only once
+	expiryLogged bool
+}
+
+// DriftIgnoreRules is the parsed content of a drift ignore file
+type DriftIgnoreRules struct {
+	Rules []DriftIgnoreRule `json:"rules"`
+
+	mu sync.Mutex
+}
+
+// LoadDriftIgnoreRules reads and validates a drift ignore file
+func LoadDriftIgnoreRules(path string) (*DriftIgnoreRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read drift ignore file: %w", err)
+	}
+
+	var rules DriftIgnoreRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse drift ignore file: %w", err)
+	}
+
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.Reason == "" {
+			return nil, fmt.Errorf("drift ignore rule %d has no reason", i+1)
+		}
+		if rule.Expires != "" {
+			expiresAt, err := time.Parse(time.DateOnly, rule.Expires)
+			if err != nil {
+				return nil, fmt.Errorf("drift ignore rule %d has an invalid expiry date: %w", i+1, err)
+			}
+			// A rule stays valid for the whole expiry day
+			rule.expiresAt = expiresAt.AddDate(0, 0, 1)
+		}
+	}
+
+	return &rules, nil
+}
+
+// Apply marks the diffs and resources matched by unexpired rules as suppressed. An
+// expired rule is reported the first time it is skipped.
+func (r *DriftIgnoreRules) Apply(results []DriftResult, now time.Time) {
+	r.mu.Lock()
+	active := make([]DriftIgnoreRule, 0, len(r.Rules))
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if !rule.expiresAt.IsZero() && !now.Before(rule.expiresAt) {
+			if !rule.expiryLogged {
+				slog.Warn("Drift ignore rule expired", "stack", orAny(rule.Stack), "logicalId", orAny(rule.LogicalID),
+					"reason", rule.Reason, "expired", rule.Expires)
+				rule.expiryLogged = true
+			}
+			continue
+		}
+		active = append(active, *rule)
+	}
+	r.mu.Unlock()
+
+	for i := range results {
+		for j := range results[i].DriftedResources {
+			applyIgnoreRules(active, results[i].StackName, &results[i].DriftedResources[j])
+		}
+	}
+}
+
+// applyIgnoreRules suppresses a single drifted resource and its diffs
+func applyIgnoreRules(rules []DriftIgnoreRule, stackName string, dr *DriftedResource) {
+	for _, rule := range rules {
+		if !MatchGlob(rule.Stack, stackName) ||
+			!MatchGlob(rule.LogicalID, dr.LogicalID) ||
+			!MatchGlob(rule.ResourceType, dr.ResourceType) {
+			continue
+		}
+
+		if rule.PropertyPath == "" {
+			dr.Suppressed = true
+			dr.SuppressionReason = rule.Reason
+			for k := range dr.PropertyDiffs {
+				dr.PropertyDiffs[k].Suppressed = true
+				dr.PropertyDiffs[k].SuppressionReason = rule.Reason
+			}
+			return
+		}
+
+		for k := range dr.PropertyDiffs {
+			pd := &dr.PropertyDiffs[k]
+			if !pd.Suppressed && MatchGlob(rule.PropertyPath, pd.PropertyPath) {
+				pd.Suppressed = true
+				pd.SuppressionReason = rule.Reason
+			}
+		}
+	}
+
+	// A resource whose every diff is suppressed is suppressed as a whole
+	if len(dr.PropertyDiffs) > 0 {
+		for _, pd := range dr.PropertyDiffs {
+			if !pd.Suppressed {
+				return
+			}
+		}
+		dr.Suppressed = true
+		dr.SuppressionReason = dr.PropertyDiffs[0].SuppressionReason
+	}
+}
+
+// orAny renders an empty pattern as a wildcard for messages
+func orAny(pattern string) string {
+	if pattern == "" {
+		return "*"
+	}
+	return pattern
+}
+
+// DriftBaseline is a snapshot of known drift, keyed by stack and logical ID
+type DriftBaseline struct {
+	RecordedAt string                                 `json:"recordedAt"`
+	Stacks     map[string]map[string]baselineResource `json:"stacks"`
+}
+
+// baselineResource records the drift of a single resource in a baseline
+type baselineResource struct {
+	DriftStatus string `json:"driftStatus"`
+	// Properties maps property paths to their actual values at recording time
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// NewDriftBaseline builds a baseline from drift detection results
+func NewDriftBaseline(results []DriftResult, now time.Time) *DriftBaseline {
+	baseline := &DriftBaseline{
+		RecordedAt: now.UTC().Format(time.RFC3339),
+		Stacks:     make(map[string]map[string]baselineResource),
+	}
+
+	for _, result := range results {
+		resources := make(map[string]baselineResource)
+		for _, dr := range result.DriftedResources {
+			br := baselineResource{
+				DriftStatus: dr.DriftStatus,
+				Properties:  make(map[string]string),
+			}
+			for _, pd := range dr.PropertyDiffs {
+				br.Properties[pd.PropertyPath] = pd.ActualValue
+			}
+			resources[dr.LogicalID] = br
+		}
+		baseline.Stacks[result.StackName] = resources
+	}
+
+	return baseline
+}
+
+// LoadDriftBaseline reads a drift baseline file
+func LoadDriftBaseline(path string) (*DriftBaseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read drift baseline: %w", err)
+	}
+
+	var baseline DriftBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("failed to parse drift baseline: %w", err)
+	}
+
+	return &baseline, nil
+}
+
+// Save writes the baseline to a file
+func (b *DriftBaseline) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode drift baseline: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write drift baseline: %w", err)
+	}
+	return nil
+}
+
+// Apply marks the drift already present in the baseline, leaving only new drift reportable.
+// A diff counts as new when its property was not drifted before or its actual value changed.
+func (b *DriftBaseline) Apply(results []DriftResult) {
+	for i := range results {
+		known, ok := b.Stacks[results[i].StackName]
+		if !ok {
+			continue
+		}
+
+		for j := range results[i].DriftedResources {
+			dr := &results[i].DriftedResources[j]
+			br, ok := known[dr.LogicalID]
+			if !ok || br.DriftStatus != dr.DriftStatus {
+				continue
+			}
+
+			allKnown := true
+			for k := range dr.PropertyDiffs {
+				pd := &dr.PropertyDiffs[k]
+				if actual, ok := br.Properties[pd.PropertyPath]; ok && actual == pd.ActualValue {
+					pd.InBaseline = true
+				} else {
+					allKnown = false
+				}
+			}
+			dr.InBaseline = allKnown
+		}
+	}
+}
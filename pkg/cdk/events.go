@@ -0,0 +1,106 @@
+package cdk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+
+	"cdk-deployer/pkg/event"
+)
+
+// stackEventTracker finds the CloudFormation events of a single stack operation
+type stackEventTracker struct {
+	stackName   string
+	seen        map[string]bool
+	initialized bool
+}
+
+// newStackEventTracker creates a tracker for the operation currently running on a stack
+func newStackEventTracker(stackName string) *stackEventTracker {
+	return &stackEventTracker{
+		stackName: stackName,
+		seen:      make(map[string]bool),
+	}
+}
+
+// poll returns the events not reported yet, oldest first. The first poll reports the
+// events since the user-initiated start of the operation; if the start is not on the
+// first page of events, only later events are reported.
+func (t *stackEventTracker) poll(ctx context.Context, client *cloudformation.Client) ([]types.StackEvent, error) {
+	output, err := client.DescribeStackEvents(ctx, &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(t.stackName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe stack events: %w", err)
+	}
+
+	// Events are returned newest first
+	var fresh []types.StackEvent
+	foundStart := false
+	for _, e := range output.StackEvents {
+		if t.seen[aws.ToString(e.EventId)] {
+			break
+		}
+		fresh = append(fresh, e)
+		if !t.initialized && isOperationStart(e) {
+			foundStart = true
+			break
+		}
+	}
+	for _, e := range output.StackEvents {
+		t.seen[aws.ToString(e.EventId)] = true
+	}
+
+	if !t.initialized {
+		t.initialized = true
+		if !foundStart {
+			return nil, nil
+		}
+	}
+
+	for i, j := 0, len(fresh)-1; i < j; i, j = i+1, j-1 {
+		fresh[i], fresh[j] = fresh[j], fresh[i]
+	}
+	return fresh, nil
+}
+
+// isOperationStart reports whether an event marks the start of a stack operation
+func isOperationStart(e types.StackEvent) bool {
+	return aws.ToString(e.ResourceType) == "AWS::CloudFormation::Stack" &&
+		strings.HasSuffix(string(e.ResourceStatus), "_IN_PROGRESS") &&
+		aws.ToString(e.ResourceStatusReason) == "User Initiated"
+}
+
+// emitResourceEvents reports new stack events to the event handler
+func (d *Deployer) emitResourceEvents(ctx context.Context, tracker *stackEventTracker) {
+	events, err := tracker.poll(ctx, d.cfnClient)
+	if err != nil {
+		d.stackLogger(tracker.stackName).Debug("Failed to poll stack events", "error", err)
+		return
+	}
+
+	for _, e := range events {
+		d.events.Emit(event.Event{
+			Type:   event.ResourceEvent,
+			Stack:  tracker.stackName,
+			Status: string(e.ResourceStatus),
+			Resource: &event.Resource{
+				LogicalID:    aws.ToString(e.LogicalResourceId),
+				PhysicalID:   aws.ToString(e.PhysicalResourceId),
+				ResourceType: aws.ToString(e.ResourceType),
+				Status:       string(e.ResourceStatus),
+				StatusReason: aws.ToString(e.ResourceStatusReason),
+				Timestamp:    aws.ToTime(e.Timestamp),
+			},
+		})
+	}
+}
+
+// emitStackStarted reports that a stack operation was submitted
+func (d *Deployer) emitStackStarted(stackName, operation string) {
+	d.events.Emit(event.Event{Type: event.StackStarted, Stack: stackName, Operation: operation})
+}
@@ -0,0 +1,74 @@
+package cdk
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	globCacheMu sync.Mutex
+	globCache   = make(map[globKey]*regexp.Regexp)
+)
+
+// globKey identifies a compiled pattern and whether '*' crosses '/'
+type globKey struct {
+	pattern string
+	flat    bool
+}
+
+// MatchGlob reports whether s matches a glob pattern. A single '*' matches any
+// run of characters except '/', '**' matches across '/' and '?' matches one character.
+// An empty pattern matches everything.
+func MatchGlob(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	return compileGlob(pattern, false).MatchString(s)
+}
+
+// matchFlat is like MatchGlob for strings that are not paths, such as context keys:
+// '*' matches any run of characters, '/' included
+func matchFlat(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	return compileGlob(pattern, true).MatchString(s)
+}
+
+// compileGlob converts a glob pattern into an anchored regular expression. With flat
+// set, '*' matches across '/' like '**'.
+func compileGlob(pattern string, flat bool) *regexp.Regexp {
+	globCacheMu.Lock()
+	defer globCacheMu.Unlock()
+
+	key := globKey{pattern: pattern, flat: flat}
+	if re, ok := globCache[key]; ok {
+		return re
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else if flat {
+				b.WriteString(".*")
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	re := regexp.MustCompile(b.String())
+	globCache[key] = re
+	return re
+}
@@ -0,0 +1,465 @@
+package cdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// ImportMapping maps stack names to logical IDs to the identifier properties of
+// existing resources, e.g. {"MyStack": {"Bucket": {"BucketName": "my-bucket"}}}
+type ImportMapping map[string]map[string]map[string]string
+
+// LoadImportMapping reads a resource import mapping file
+func LoadImportMapping(path string) (ImportMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import mapping: %w", err)
+	}
+
+	var mapping ImportMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse import mapping: %w", err)
+	}
+
+	return mapping, nil
+}
+
+// ImportOptions configures the import of existing resources into a stack
+type ImportOptions struct {
+	// Identifiers maps logical IDs to identifier properties of the resources to import
+	Identifiers map[string]map[string]string
+	// Prompter asks for identifiers missing from the mapping and confirms the import.
+	// Without a prompter, resources missing from the mapping are created normally.
+	Prompter Prompter
+}
+
+// ImportResult contains the outcome of importing resources into a stack
+type ImportResult struct {
+	StackName string
+	Imported  []string
+	ChangeSet *ChangeSetSummary
+	Status    string
+	// Deploy is the result of the update applying the remaining changes
+	Deploy *DeployResult
+}
+
+// Import adopts existing resources into a stack. New resources in the synthesized
+// template whose physical identifiers are known are imported with an IMPORT change
+// set, then a normal deploy applies the remaining changes.
+func (d *Deployer) Import(ctx context.Context, stackName string, opts ImportOptions) (*ImportResult, error) {
+	ctx, unlock, err := d.lockStack(ctx, stackName, "import")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result := &ImportResult{
+		StackName: stackName,
+	}
+
+	synthBody, err := d.synthesizer.GetTemplateBody(stackName)
+	if err != nil {
+		return nil, err
+	}
+	synthTemplate, err := parseTemplate(synthBody)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := d.stackExists(ctx, stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	var baseTemplate map[string]interface{}
+	if exists {
+		deployedBody, err := d.getDeployedTemplate(ctx, stackName)
+		if err != nil {
+			return nil, err
+		}
+		baseTemplate, err = parseTemplate(deployedBody)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		baseTemplate = newImportBaseTemplate(synthTemplate)
+	}
+
+	identifierProps, err := d.getResourceIdentifiers(ctx, synthBody)
+	if err != nil {
+		return nil, err
+	}
+
+	toImport, err := selectResourcesToImport(d.stackLogger(stackName), synthTemplate, baseTemplate, identifierProps, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(toImport) > 0 {
+		importBody, err := buildImportTemplate(d.stackLogger(stackName), baseTemplate, synthTemplate, toImport)
+		if err != nil {
+			return nil, err
+		}
+
+		summary, err := d.createChangeSet(ctx, changeSetRequest{
+			StackName:         stackName,
+			ChangeSetName:     fmt.Sprintf("cdk-deployer-import-%d", time.Now().Unix()),
+			ChangeSetType:     types.ChangeSetTypeImport,
+			TemplateBody:      importBody,
+			Description:       "Import of existing resources",
+			ResourcesToImport: toImport,
+		})
+		if err != nil {
+			return result, err
+		}
+		result.ChangeSet = summary
+		d.logChangeSet(summary)
+
+		if opts.Prompter != nil {
+			ok, err := opts.Prompter.Confirm(fmt.Sprintf("Import %d resource(s) into %s?", len(toImport), stackName))
+			if err != nil {
+				return result, err
+			}
+			if !ok {
+				d.stackLogger(stackName).Info("Import cancelled, deleting change set")
+				return result, d.deleteChangeSet(ctx, summary)
+			}
+		}
+
+		status, err := d.executeChangeSet(ctx, summary)
+		result.Status = status
+		if err != nil {
+			return result, err
+		}
+		for _, r := range toImport {
+			result.Imported = append(result.Imported, aws.ToString(r.LogicalResourceId))
+		}
+	} else {
+		d.stackLogger(stackName).Info("No resources to import")
+	}
+
+	// Apply the remaining changes with the regular create/update flow
+	deployResult, err := d.Deploy(ctx, stackName)
+	if err != nil {
+		return result, err
+	}
+	result.Deploy = deployResult
+	result.Status = deployResult.Status
+
+	return result, nil
+}
+
+// getResourceIdentifiers returns the identifier property names of importable resources by logical ID
+func (d *Deployer) getResourceIdentifiers(ctx context.Context, templateBody string) (map[string][]string, error) {
+	output, err := d.cfnClient.GetTemplateSummary(ctx, &cloudformation.GetTemplateSummaryInput{
+		TemplateBody: aws.String(templateBody),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template summary: %w", err)
+	}
+
+	identifiers := make(map[string][]string)
+	for _, summary := range output.ResourceIdentifierSummaries {
+		for _, id := range summary.LogicalResourceIds {
+			identifiers[id] = summary.ResourceIdentifiers
+		}
+	}
+	return identifiers, nil
+}
+
+// selectResourcesToImport finds new importable resources and resolves their identifiers
+func selectResourcesToImport(logger *slog.Logger, synthTemplate, baseTemplate map[string]interface{}, identifierProps map[string][]string, opts ImportOptions) ([]types.ResourceToImport, error) {
+	synthResources, _ := synthTemplate["Resources"].(map[string]interface{})
+	baseResources, _ := baseTemplate["Resources"].(map[string]interface{})
+
+	var newIDs []string
+	for id := range synthResources {
+		if _, ok := baseResources[id]; !ok {
+			newIDs = append(newIDs, id)
+		}
+	}
+	sort.Strings(newIDs)
+
+	var toImport []types.ResourceToImport
+	for _, id := range newIDs {
+		props, importable := identifierProps[id]
+		if !importable {
+			continue
+		}
+		resource, _ := synthResources[id].(map[string]interface{})
+		resourceType, _ := resource["Type"].(string)
+
+		identifier := opts.Identifiers[id]
+		if identifier == nil && opts.Prompter != nil {
+			var err error
+			identifier, err = promptIdentifier(opts.Prompter, logger, id, resourceType, props)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if identifier == nil {
+			continue
+		}
+
+		for _, p := range props {
+			if identifier[p] == "" {
+				return nil, fmt.Errorf("identifier %s missing for resource %s (%s)", p, id, resourceType)
+			}
+		}
+
+		toImport = append(toImport, types.ResourceToImport{
+			LogicalResourceId:  aws.String(id),
+			ResourceType:       aws.String(resourceType),
+			ResourceIdentifier: identifier,
+		})
+	}
+
+	return toImport, nil
+}
+
+// promptIdentifier asks for the identifier properties of a resource; an empty
+// answer means the resource is created instead of imported
+func promptIdentifier(prompter Prompter, logger *slog.Logger, logicalID, resourceType string, props []string) (map[string]string, error) {
+	logger.Info("New resource can be imported", "logicalId", logicalID, "type", resourceType,
+		"identifiedBy", strings.Join(props, ", "))
+
+	identifier := make(map[string]string)
+	for _, p := range props {
+		value, err := prompter.Ask(fmt.Sprintf("%s of existing resource (empty to create a new one)", p))
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return nil, nil
+		}
+		identifier[p] = value
+	}
+	return identifier, nil
+}
+
+// newImportBaseTemplate returns the starting template for importing into a new stack
+func newImportBaseTemplate(synthTemplate map[string]interface{}) map[string]interface{} {
+	base := map[string]interface{}{
+		"Resources": map[string]interface{}{},
+	}
+	for _, section := range []string{"AWSTemplateFormatVersion", "Parameters", "Mappings", "Conditions", "Rules"} {
+		if v, ok := synthTemplate[section]; ok {
+			base[section] = v
+		}
+	}
+	return base
+}
+
+// buildImportTemplate adds the imported resources to the base template. CloudFormation
+// requires a DeletionPolicy on imported resources, so Retain is set where none is given.
+// References to resources, parameters and conditions the import template lacks are
+// dropped: CloudFormation rejects them, and the deployment following the import
+// applies the full template anyway.
+func buildImportTemplate(logger *slog.Logger, baseTemplate, synthTemplate map[string]interface{}, toImport []types.ResourceToImport) (string, error) {
+	synthResources, _ := synthTemplate["Resources"].(map[string]interface{})
+	baseResources, ok := baseTemplate["Resources"].(map[string]interface{})
+	if !ok {
+		baseResources = map[string]interface{}{}
+		baseTemplate["Resources"] = baseResources
+	}
+
+	known := make(map[string]bool)
+	for id := range baseResources {
+		known[id] = true
+	}
+	if params, ok := baseTemplate["Parameters"].(map[string]interface{}); ok {
+		for name := range params {
+			known[name] = true
+		}
+	}
+	for _, r := range toImport {
+		known[aws.ToString(r.LogicalResourceId)] = true
+	}
+	conditions, _ := baseTemplate["Conditions"].(map[string]interface{})
+
+	for _, r := range toImport {
+		id := aws.ToString(r.LogicalResourceId)
+		synthResource, ok := synthResources[id].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("resource %s not found in synthesized template", id)
+		}
+		resource := make(map[string]interface{}, len(synthResource))
+		for k, v := range synthResource {
+			resource[k] = v
+		}
+		if _, ok := resource["DeletionPolicy"]; !ok {
+			resource["DeletionPolicy"] = "Retain"
+		}
+
+		if dropped := dropDanglingRefs(resource, known, conditions, r.ResourceIdentifier); len(dropped) > 0 {
+			logger.Info("Leaving references out of the import until the deployment", "logicalId", id,
+				"dropped", strings.Join(dropped, ", "))
+		}
+		for prop := range r.ResourceIdentifier {
+			if props, _ := resource["Properties"].(map[string]interface{}); props != nil && hasDanglingRef(props[prop], known) {
+				return "", fmt.Errorf("identifier %s of resource %s refers to a resource that is not imported", prop, id)
+			}
+		}
+		baseResources[id] = resource
+	}
+
+	data, err := json.Marshal(baseTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode import template: %w", err)
+	}
+	return string(data), nil
+}
+
+// dropDanglingRefs removes the DependsOn entries, the condition and the properties
+// of a resource that refer to names not known to the template, except identifier
+// properties, and returns what it removed
+func dropDanglingRefs(resource map[string]interface{}, known map[string]bool, conditions map[string]interface{}, identifier map[string]string) []string {
+	var dropped []string
+
+	switch deps := resource["DependsOn"].(type) {
+	case string:
+		if !known[deps] {
+			delete(resource, "DependsOn")
+			dropped = append(dropped, "DependsOn "+deps)
+		}
+	case []interface{}:
+		var kept []interface{}
+		for _, dep := range deps {
+			if name, _ := dep.(string); !known[name] {
+				dropped = append(dropped, fmt.Sprintf("DependsOn %v", dep))
+				continue
+			}
+			kept = append(kept, dep)
+		}
+		if len(kept) > 0 {
+			resource["DependsOn"] = kept
+		} else {
+			delete(resource, "DependsOn")
+		}
+	}
+
+	if cond, ok := resource["Condition"].(string); ok && conditions[cond] == nil {
+		delete(resource, "Condition")
+		dropped = append(dropped, "Condition "+cond)
+	}
+
+	if props, ok := resource["Properties"].(map[string]interface{}); ok {
+		kept := make(map[string]interface{}, len(props))
+		for _, name := range sortedKeys(props) {
+			if _, isID := identifier[name]; !isID && hasDanglingRef(props[name], known) {
+				dropped = append(dropped, "property "+name)
+				continue
+			}
+			kept[name] = props[name]
+		}
+		resource["Properties"] = kept
+	}
+	return dropped
+}
+
+// hasDanglingRef reports whether a template value uses Ref, Fn::GetAtt or Fn::Sub on
+// a name not known to the template. Pseudo parameters such as AWS::Region are always
+// known.
+func hasDanglingRef(v interface{}, known map[string]bool) bool {
+	dangling := func(name string) bool {
+		return !strings.HasPrefix(name, "AWS::") && !known[name]
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if name, ok := v["Ref"].(string); ok {
+				return dangling(name)
+			}
+			switch att := v["Fn::GetAtt"].(type) {
+			case []interface{}:
+				if len(att) > 0 {
+					if name, ok := att[0].(string); ok && dangling(name) {
+						return true
+					}
+				}
+			case string:
+				name, _, _ := strings.Cut(att, ".")
+				return dangling(name)
+			}
+			if sub, ok := v["Fn::Sub"]; ok && hasDanglingSub(sub, known, dangling) {
+				return true
+			}
+		}
+		for _, item := range v {
+			if hasDanglingRef(item, known) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasDanglingRef(item, known) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasDanglingSub reports whether the ${Name} and ${Name.Attribute} variables of an
+// Fn::Sub refer to an unknown name that is not one of its own variables
+func hasDanglingSub(sub interface{}, known map[string]bool, dangling func(string) bool) bool {
+	var text string
+	vars := map[string]interface{}{}
+	switch sub := sub.(type) {
+	case string:
+		text = sub
+	case []interface{}:
+		if len(sub) > 0 {
+			text, _ = sub[0].(string)
+		}
+		if len(sub) > 1 {
+			vars, _ = sub[1].(map[string]interface{})
+			for _, value := range vars {
+				if hasDanglingRef(value, known) {
+					return true
+				}
+			}
+		}
+	}
+
+	for rest := text; ; {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			return false
+		}
+		rest = rest[start+2:]
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return false
+		}
+		name, _, _ := strings.Cut(rest[:end], ".")
+		rest = rest[end+1:]
+		if strings.HasPrefix(name, "!") {
+			// ${!Literal} is not a variable
+			continue
+		}
+		if _, ok := vars[name]; !ok && dangling(name) {
+			return true
+		}
+	}
+}
+
+// parseTemplate decodes a JSON CloudFormation template
+func parseTemplate(body string) (map[string]interface{}, error) {
+	var template map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &template); err != nil {
+		return nil, fmt.Errorf("failed to parse template (only JSON templates are supported): %w", err)
+	}
+	return template, nil
+}
@@ -0,0 +1,229 @@
+package cdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"cdk-deployer/pkg/lock"
+)
+
+// LockOptions configures per-stack deployment locks
+type LockOptions struct {
+	// TTL is the lease of a lock; it is renewed while the operation runs (default 5m)
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// Wait is how long to wait for a lock held by someone else (default: fail at once)
+	Wait time.Duration `yaml:"wait,omitempty"`
+}
+
+// ttl returns the lease of a lock
+func (o LockOptions) ttl() time.Duration {
+	if o.TTL > 0 {
+		return o.TTL
+	}
+	return 5 * time.Minute
+}
+
+// WithLocking makes the deployer lock every stack per account and region before
+// changing it, so that concurrent runs cannot deploy the same stack at once
+func WithLocking(locker lock.Locker, opts LockOptions) DeployerOption {
+	return func(o *deployerOptions) {
+		o.locker = locker
+		o.lockOptions = opts
+	}
+}
+
+// lockedStacksKey is the context key of the stacks locked by the current operation
+type lockedStacksKey struct{}
+
+// lockKey returns the lock key of a stack in the deployer's account and region
+func (d *Deployer) lockKey(ctx context.Context, stackName string) (lock.Key, error) {
+	account, err := d.accountID(ctx)
+	if err != nil {
+		return lock.Key{}, err
+	}
+	return lock.Key{Account: account, Region: d.region, Stack: stackName}, nil
+}
+
+// accountID returns the AWS account of the deployer's credentials
+func (d *Deployer) accountID(ctx context.Context) (string, error) {
+	identity, err := d.CallerIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	return identity.Account, nil
+}
+
+// lockStack acquires the lock of a stack for an operation and keeps renewing it until
+// the returned release function is called. The returned context is cancelled when the
+// lock is lost, so the operation stops instead of running unprotected. Without a
+// locker, or when ctx already holds the lock of the stack, it does nothing.
+func (d *Deployer) lockStack(ctx context.Context, stackName, operation string) (context.Context, func(), error) {
+	noop := func() {}
+	if d.locker == nil {
+		return ctx, noop, nil
+	}
+	if held, _ := ctx.Value(lockedStacksKey{}).(map[string]bool); held[stackName] {
+		return ctx, noop, nil
+	}
+
+	key, err := d.lockKey(ctx, stackName)
+	if err != nil {
+		return ctx, noop, err
+	}
+	info, err := d.acquireLock(ctx, key, operation)
+	if err != nil {
+		return ctx, noop, err
+	}
+
+	logger := d.stackLogger(stackName)
+	logger.Debug("Acquired stack lock", "lock", key.String())
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.renewLock(info, stop, cancel)
+	}()
+
+	release := func() {
+		close(stop)
+		wg.Wait()
+		cancel(nil)
+
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := d.locker.Unlock(releaseCtx, info); err != nil {
+			logger.Warn("Failed to release stack lock", "lock", key.String(), "error", err)
+			return
+		}
+		logger.Debug("Released stack lock", "lock", key.String())
+	}
+
+	held, _ := ctx.Value(lockedStacksKey{}).(map[string]bool)
+	locked := map[string]bool{stackName: true}
+	for name := range held {
+		locked[name] = true
+	}
+	return context.WithValue(ctx, lockedStacksKey{}, locked), release, nil
+}
+
+// acquireLock takes a lock, waiting for the configured time while someone else holds it
+func (d *Deployer) acquireLock(ctx context.Context, key lock.Key, operation string) (lock.Info, error) {
+	deadline := time.Now().Add(d.lockOptions.Wait)
+	waiting := false
+
+	for {
+		info := lock.NewInfo(key, operation, d.lockOptions.ttl())
+		err := d.locker.TryLock(ctx, info)
+		if err == nil {
+			return info, nil
+		}
+
+		var held *lock.HeldError
+		if !errors.As(err, &held) {
+			return lock.Info{}, fmt.Errorf("failed to lock stack %s: %w", key.Stack, err)
+		}
+		if !time.Now().Before(deadline) {
+			return lock.Info{}, fmt.Errorf("%w; use -cmd unlock to remove a stale lock", err)
+		}
+		if !waiting {
+			d.stackLogger(key.Stack).Info("Waiting for stack lock",
+				"holder", held.Info.Holder, "operation", held.Info.Operation, "expires", held.Info.ExpiresAt)
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return lock.Info{}, ctx.Err()
+		case <-time.After(min(5*time.Second, time.Until(deadline))):
+		}
+	}
+}
+
+// renewLock extends the lease of a held lock until stop is closed. When the lock is
+// lost it cancels the operation holding it.
+func (d *Deployer) renewLock(info lock.Info, stop <-chan struct{}, abort context.CancelCauseFunc) {
+	ttl := d.lockOptions.ttl()
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+			info.ExpiresAt = time.Now().UTC().Add(ttl)
+			err := d.locker.Renew(ctx, info)
+			cancel()
+			if errors.Is(err, lock.ErrLost) {
+				d.stackLogger(info.Key.Stack).Error("Stack lock was lost; stopping the operation",
+					"lock", info.Key.String())
+				abort(fmt.Errorf("lock of stack %s was lost", info.Key.Stack))
+				return
+			}
+			if err != nil {
+				d.stackLogger(info.Key.Stack).Warn("Failed to renew stack lock", "lock", info.Key.String(), "error", err)
+			}
+		}
+	}
+}
+
+// InspectLock returns the lock of a stack, or nil when it is not locked
+func (d *Deployer) InspectLock(ctx context.Context, stackName string) (*lock.Info, error) {
+	if d.locker == nil {
+		return nil, fmt.Errorf("stack locking is not configured")
+	}
+	key, err := d.lockKey(ctx, stackName)
+	if err != nil {
+		return nil, err
+	}
+	return d.locker.Inspect(ctx, key)
+}
+
+// LockedStacks returns the names of the stacks locked in the deployer's account and
+// region, whoever holds the locks
+func (d *Deployer) LockedStacks(ctx context.Context) ([]string, error) {
+	if d.locker == nil {
+		return nil, fmt.Errorf("stack locking is not configured")
+	}
+	account, err := d.accountID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	locks, err := d.locker.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var stacks []string
+	for _, info := range locks {
+		if info.Key.Account == account && info.Key.Region == d.region {
+			stacks = append(stacks, info.Key.Stack)
+		}
+	}
+	sort.Strings(stacks)
+	return stacks, nil
+}
+
+// ForceUnlock removes the lock of a stack regardless of its holder, e.g. after the
+// holder crashed and its lease has not yet expired
+func (d *Deployer) ForceUnlock(ctx context.Context, stackName string) error {
+	if d.locker == nil {
+		return fmt.Errorf("stack locking is not configured")
+	}
+	key, err := d.lockKey(ctx, stackName)
+	if err != nil {
+		return err
+	}
+	if err := d.locker.ForceUnlock(ctx, key); err != nil {
+		return err
+	}
+	d.stackLogger(stackName).Info("Stack lock removed", "lock", key.String())
+	return nil
+}
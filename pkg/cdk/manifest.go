@@ -0,0 +1,131 @@
+package cdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// artifactTypeStack is the cloud assembly artifact type of a CloudFormation stack
+const artifactTypeStack = "aws:cloudformation:stack"
+
+// assemblyManifest is the subset of the cloud assembly manifest.json we use
+type assemblyManifest struct {
+	Version   string                      `json:"version"`
+	Artifacts map[string]manifestArtifact `json:"artifacts"`
+	Missing   []missingContext            `json:"missing"`
+}
+
+// missingContext is a context lookup the app needed but found no cached value for
+type missingContext struct {
+	Key      string `json:"key"`
+	Provider string `json:"provider"`
+}
+
+// manifestArtifact is a single artifact of the cloud assembly
+type manifestArtifact struct {
+	Type         string                 `json:"type"`
+	Environment  string                 `json:"environment"`
+	DisplayName  string                 `json:"displayName"`
+	Dependencies []string               `json:"dependencies"`
+	Properties   map[string]interface{} `json:"properties"`
+}
+
+// StackInfo describes a stack in the cloud assembly
+type StackInfo struct {
+	// Name is the artifact ID, which is also the template file name
+	Name string
+	// DisplayName is the hierarchical construct path, e.g. Prod/Api
+	DisplayName string
+	// Environment is the target environment, e.g. aws://123456789012/us-east-1
+	Environment string
+	// Dependencies are the names of the stacks this stack depends on
+	Dependencies []string
+}
+
+// readManifest reads the cloud assembly manifest from a directory
+func readManifest(dir string) (*assemblyManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cloud assembly manifest: %w", err)
+	}
+
+	var manifest assemblyManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse cloud assembly manifest: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// stacks returns the stack artifacts of the manifest sorted by name. Dependencies
+// on non-stack artifacts such as asset manifests are omitted.
+func (m *assemblyManifest) stacks() []StackInfo {
+	var stacks []StackInfo
+	for id, artifact := range m.Artifacts {
+		if artifact.Type != artifactTypeStack {
+			continue
+		}
+
+		info := StackInfo{
+			Name:        id,
+			DisplayName: artifact.DisplayName,
+			Environment: artifact.Environment,
+		}
+		if info.DisplayName == "" {
+			info.DisplayName = id
+		}
+		for _, dep := range artifact.Dependencies {
+			if m.Artifacts[dep].Type == artifactTypeStack {
+				info.Dependencies = append(info.Dependencies, dep)
+			}
+		}
+		sort.Strings(info.Dependencies)
+		stacks = append(stacks, info)
+	}
+
+	sort.Slice(stacks, func(i, j int) bool {
+		return stacks[i].Name < stacks[j].Name
+	})
+	return stacks
+}
+
+// StackInfos returns the stacks of the synthesized cloud assembly. Without a
+// manifest the stacks are derived from the template files.
+func (s *Synthesizer) StackInfos() ([]StackInfo, error) {
+	manifest, err := readManifest(s.outputDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		names, err := s.findGeneratedStacks()
+		if err != nil {
+			return nil, err
+		}
+		stacks := make([]StackInfo, len(names))
+		for i, name := range names {
+			stacks[i] = StackInfo{Name: name, DisplayName: name}
+		}
+		return stacks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest.stacks(), nil
+}
+
+// stackDependencies returns the stack dependencies from the manifest, or nil if the
+// assembly has no manifest
+func (s *Synthesizer) stackDependencies() map[string][]string {
+	infos, err := s.StackInfos()
+	if err != nil {
+		return nil
+	}
+
+	deps := make(map[string][]string, len(infos))
+	for _, info := range infos {
+		deps[info.Name] = info.Dependencies
+	}
+	return deps
+}
@@ -0,0 +1,163 @@
+package cdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// SynthMode selects how the cloud assembly is produced
+type SynthMode string
+
+const (
+	// SynthModeNative runs the app command from cdk.json directly
+	SynthModeNative SynthMode = "native"
+	// SynthModeCLI runs cdk synth through the CDK CLI; it is the default
+	SynthModeCLI SynthMode = "cli"
+	// SynthModeAuto synthesizes natively and performs missing context lookups with the CLI
+	SynthModeAuto SynthMode = "auto"
+)
+
+// Validate checks that the mode is known
+func (m SynthMode) Validate() error {
+	switch m {
+	case "", SynthModeNative, SynthModeCLI, SynthModeAuto:
+		return nil
+	default:
+		return fmt.Errorf("invalid synth mode %q (use native, cli or auto)", m)
+	}
+}
+
+// MissingContextError reports context lookups the app needs but that are not cached.
+// Only the CDK CLI can perform the lookups.
+type MissingContextError struct {
+	Keys []string
+}
+
+func (e *MissingContextError) Error() string {
+	return fmt.Sprintf("synthesis needs %d context lookup(s) not in cdk.context.json: %s",
+		len(e.Keys), strings.Join(e.Keys, ", "))
+}
+
+// runNativeSynth runs the app command directly with the environment the CDK CLI would
+// provide and checks the resulting cloud assembly
+func (s *Synthesizer) runNativeSynth(ctx context.Context, cdkConfig *CDKConfig, appCmd string, env []string) error {
+	s.logger.Info("Synthesizing CDK app")
+
+	// Start from an empty assembly so no stale templates are picked up
+	if err := os.RemoveAll(s.outputDir); err != nil {
+		return fmt.Errorf("failed to clean output directory: %w", err)
+	}
+	if err := os.MkdirAll(s.outputDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	appContext, err := s.appContext(cdkConfig)
+	if err != nil {
+		return err
+	}
+	contextJSON, err := json.Marshal(appContext)
+	if err != nil {
+		return fmt.Errorf("failed to encode context: %w", err)
+	}
+	env = append(env, "CDK_CONTEXT_JSON="+string(contextJSON))
+	env = append(env, s.defaultEnvironment()...)
+
+	if err := s.runCommand(ctx, stepSynth, env, "synth", "sh", "-c", appCmd); err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(s.outputDir)
+	if err != nil {
+		return fmt.Errorf("app did not produce a cloud assembly: %w", err)
+	}
+	if len(manifest.Missing) > 0 {
+		return &MissingContextError{Keys: missingKeys(manifest)}
+	}
+
+	return nil
+}
+
+// missingLookups returns the context lookups the assembly in the output directory
+// lacks, if there is one
+func (s *Synthesizer) missingLookups() []string {
+	manifest, err := readManifest(s.outputDir)
+	if err != nil {
+		return nil
+	}
+	return missingKeys(manifest)
+}
+
+// missingKeys returns the keys of the context lookups a manifest lists as missing
+func missingKeys(manifest *assemblyManifest) []string {
+	keys := make([]string, len(manifest.Missing))
+	for i, m := range manifest.Missing {
+		keys[i] = m.Key
+	}
+	return keys
+}
+
+// appContext merges the context the app is run with, in increasing precedence: the
+// cdk.json context, cdk.context.json, the configured values and the defaults the CDK CLI sets
+func (s *Synthesizer) appContext(cdkConfig *CDKConfig) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for k, v := range cdkConfig.Context {
+		merged[k] = v
+	}
+
+	cached, err := LoadContextFile(filepath.Join(s.projectPath, contextFileName))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cached {
+		merged[k] = v
+	}
+
+	for k, v := range s.context {
+		merged[k] = v
+	}
+
+	merged["aws:cdk:enable-path-metadata"] = true
+	merged["aws:cdk:enable-asset-metadata"] = true
+	merged["aws:cdk:bundling-stacks"] = []string{"**"}
+	return merged, nil
+}
+
+// defaultEnvironment returns CDK_DEFAULT_ACCOUNT and CDK_DEFAULT_REGION for
+// environment-agnostic stacks, resolved from the AWS credentials unless already set
+func (s *Synthesizer) defaultEnvironment() []string {
+	if os.Getenv("CDK_DEFAULT_ACCOUNT") != "" && os.Getenv("CDK_DEFAULT_REGION") != "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		s.logger.Warn("Failed to load AWS config for the default environment", "error", err)
+		return nil
+	}
+
+	var env []string
+	if os.Getenv("CDK_DEFAULT_REGION") == "" && cfg.Region != "" {
+		env = append(env, "CDK_DEFAULT_REGION="+cfg.Region)
+	}
+	if os.Getenv("CDK_DEFAULT_ACCOUNT") == "" {
+		identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			s.logger.Warn("Failed to resolve the default account", "error", err)
+		} else {
+			env = append(env, "CDK_DEFAULT_ACCOUNT="+aws.ToString(identity.Account))
+		}
+	}
+	return env
+}
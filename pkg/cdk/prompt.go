@@ -0,0 +1,83 @@
+package cdk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Prompter asks the operator for decisions during interactive operations
+type Prompter interface {
+	// Confirm asks a yes/no question and returns true only on an explicit yes
+	Confirm(question string) (bool, error)
+	// Choose asks the operator to pick one of the given options
+	Choose(question string, options []string) (string, error)
+	// Ask asks a free-form question; an empty answer means no value
+	Ask(question string) (string, error)
+}
+
+// ConsolePrompter reads answers from a terminal. It is safe for concurrent use: the
+// questions of stacks deployed in parallel are asked one at a time.
+type ConsolePrompter struct {
+	mu  sync.Mutex
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewConsolePrompter creates a prompter reading from in and writing questions to out
+func NewConsolePrompter(in io.Reader, out io.Writer) *ConsolePrompter {
+	return &ConsolePrompter{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// Confirm asks a yes/no question
+func (p *ConsolePrompter) Confirm(question string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	answer, err := p.ask(fmt.Sprintf("%s [y/N]: ", question))
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
+
+// Choose asks the operator to pick one of the given options, either by name or by its first letter
+func (p *ConsolePrompter) Choose(question string, options []string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		answer, err := p.ask(fmt.Sprintf("%s [%s]: ", question, strings.Join(options, "/")))
+		if err != nil {
+			return "", err
+		}
+		answer = strings.ToLower(answer)
+		for _, o := range options {
+			if answer == strings.ToLower(o) || (len(answer) == 1 && strings.HasPrefix(strings.ToLower(o), answer)) {
+				return o, nil
+			}
+		}
+		fmt.Fprintf(p.out, "Please answer one of: %s\n", strings.Join(options, ", "))
+	}
+}
+
+// Ask asks a free-form question
+func (p *ConsolePrompter) Ask(question string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ask(question + ": ")
+}
+
+// ask prints a question and reads a single trimmed line
+func (p *ConsolePrompter) ask(question string) (string, error) {
+	fmt.Fprint(p.out, question)
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,262 @@
+package cdk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// prepareStack checks whether a stack exists and recovers stacks that cannot be
+// updated in their current state. It reports whether the stack exists afterwards.
+func (d *Deployer) prepareStack(ctx context.Context, stackName string) (bool, error) {
+	exists, err := d.stackExists(ctx, stackName)
+	if err != nil || !exists {
+		return exists, err
+	}
+
+	status, err := d.getStackStatus(ctx, stackName)
+	if err != nil {
+		return false, err
+	}
+
+	switch types.StackStatus(status) {
+	case types.StackStatusReviewInProgress:
+		// Left behind by a create change set that was never executed
+		question := fmt.Sprintf("Stack %s is in REVIEW_IN_PROGRESS with a create change set that was never executed. Delete and recreate it?", stackName)
+		ok, err := d.confirmRecovery(d.recovery.RecreateFailedStacks, question)
+		if err != nil {
+			return true, err
+		}
+		if !ok {
+			return true, fmt.Errorf("stack %s is in REVIEW_IN_PROGRESS; delete it or deploy with -recreate-failed", stackName)
+		}
+		if err := d.deleteStack(ctx, stackName); err != nil {
+			return true, err
+		}
+		return false, nil
+
+	case types.StackStatusRollbackComplete:
+		// A stack whose first create failed can only be deleted
+		question := fmt.Sprintf("Stack %s is in ROLLBACK_COMPLETE after a failed create and cannot be updated. Delete and recreate it?", stackName)
+		ok, err := d.confirmRecovery(d.recovery.RecreateFailedStacks, question)
+		if err != nil {
+			return true, err
+		}
+		if !ok {
+			return true, fmt.Errorf("stack %s is in ROLLBACK_COMPLETE; delete it or deploy with -recreate-failed", stackName)
+		}
+		if err := d.deleteStack(ctx, stackName); err != nil {
+			return true, err
+		}
+		return false, nil
+
+	case types.StackStatusUpdateRollbackFailed:
+		if err := d.continueRollback(ctx, stackName); err != nil {
+			return true, err
+		}
+
+	case types.StackStatusCreateFailed, types.StackStatusUpdateFailed:
+		// Left behind by a deploy with rollback disabled. With rollback still disabled the
+		// update is simply retried; otherwise the stack is first rolled back to its last
+		// stable state.
+		if d.stackOptions(stackName).rollbackDisabled() {
+			d.stackLogger(stackName).Info("Retrying the deploy with rollback disabled", "status", status)
+			return true, nil
+		}
+		if err := d.rollbackStack(ctx, stackName); err != nil {
+			return true, err
+		}
+		// A rolled back create ends in ROLLBACK_COMPLETE, which needs further handling
+		return d.prepareStack(ctx, stackName)
+	}
+
+	return true, nil
+}
+
+// rollbackStack rolls a stack in CREATE_FAILED or UPDATE_FAILED back to its last stable state
+func (d *Deployer) rollbackStack(ctx context.Context, stackName string) error {
+	d.stackLogger(stackName).Info("Rolling back stack to its last stable state")
+
+	_, err := d.cfnClient.RollbackStack(ctx, &cloudformation.RollbackStackInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to roll back stack: %w", err)
+	}
+
+	status, err := d.waitForSettled(ctx, stackName, stackName)
+	if err != nil {
+		return err
+	}
+
+	switch types.StackStatus(status) {
+	case types.StackStatusRollbackComplete, types.StackStatusUpdateRollbackComplete:
+		return nil
+	default:
+		return fmt.Errorf("rollback of stack %s ended with status %s", stackName, status)
+	}
+}
+
+// handleFailedOperation tries to leave a stack in a usable state after a failed deploy
+func (d *Deployer) handleFailedOperation(ctx context.Context, stackName, status string) {
+	logger := d.stackLogger(stackName)
+	switch types.StackStatus(status) {
+	case types.StackStatusUpdateRollbackFailed:
+		if err := d.continueRollback(ctx, stackName); err != nil {
+			logger.Warn("Stack remains in UPDATE_ROLLBACK_FAILED", "error", err)
+		}
+	case types.StackStatusRollbackComplete:
+		logger.Warn("Stack failed to create and was rolled back; the next deploy with -recreate-failed deletes and recreates it")
+	case types.StackStatusCreateFailed, types.StackStatusUpdateFailed:
+		logger.Warn("Stack failed with rollback disabled; fix the failure and deploy again to retry, "+
+			"or deploy with rollback enabled to roll it back first", "status", status)
+	}
+}
+
+// continueRollback resumes a rollback stuck in UPDATE_ROLLBACK_FAILED, optionally skipping resources
+func (d *Deployer) continueRollback(ctx context.Context, stackName string) error {
+	question := fmt.Sprintf("Stack %s is stuck in UPDATE_ROLLBACK_FAILED. Continue the rollback?", stackName)
+	ok, err := d.confirmRecovery(d.recovery.ContinueRollback, question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("stack %s is stuck in UPDATE_ROLLBACK_FAILED; continue the rollback with -continue-rollback", stackName)
+	}
+
+	skip := d.recovery.ResourcesToSkip
+	if len(skip) == 0 && !d.recovery.ContinueRollback && d.recovery.Prompter != nil {
+		answer, err := d.recovery.Prompter.Ask("Logical IDs of resources to skip (comma-separated, empty for none)")
+		if err != nil {
+			return err
+		}
+		for _, id := range strings.Split(answer, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				skip = append(skip, id)
+			}
+		}
+	}
+
+	d.stackLogger(stackName).Info("Continuing rollback", "skip", skip)
+
+	_, err = d.cfnClient.ContinueUpdateRollback(ctx, &cloudformation.ContinueUpdateRollbackInput{
+		StackName:       aws.String(stackName),
+		ResourcesToSkip: skip,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to continue update rollback: %w", err)
+	}
+
+	status, err := d.waitForSettled(ctx, stackName, stackName)
+	if err != nil {
+		return err
+	}
+	if types.StackStatus(status) != types.StackStatusUpdateRollbackComplete {
+		return fmt.Errorf("rollback of stack %s ended with status %s", stackName, status)
+	}
+
+	d.stackLogger(stackName).Info("Stack rolled back to its previous state")
+	return nil
+}
+
+// deleteStack deletes a stack and waits for the deletion to finish
+func (d *Deployer) deleteStack(ctx context.Context, stackName string) error {
+	output, err := d.cfnClient.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe stack: %w", err)
+	}
+	if len(output.Stacks) == 0 {
+		return nil
+	}
+	// Deleted stacks can only be described by their ID
+	stackID := aws.ToString(output.Stacks[0].StackId)
+
+	d.stackLogger(stackName).Info("Deleting stack")
+	_, err = d.cfnClient.DeleteStack(ctx, &cloudformation.DeleteStackInput{
+		StackName: aws.String(stackID),
+		RoleARN:   d.stackOptions(stackName).roleARN(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete stack: %w", err)
+	}
+
+	status, err := d.waitForSettled(ctx, stackName, stackID)
+	if err != nil {
+		return err
+	}
+	if types.StackStatus(status) != types.StackStatusDeleteComplete {
+		return fmt.Errorf("deletion of stack %s ended with status %s", stackName, status)
+	}
+
+	d.stackLogger(stackName).Info("Stack deleted")
+	return nil
+}
+
+// waitForSettled waits until a stack is no longer in an *_IN_PROGRESS state. The stack
+// is polled by stackID, its name or ID, and logged by its name.
+func (d *Deployer) waitForSettled(ctx context.Context, stackName, stackID string) (string, error) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	timeout := time.After(d.stackTimeout())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			return "", fmt.Errorf("timeout waiting for stack %s", stackName)
+		case <-ticker.C:
+			status, err := d.getStackStatus(ctx, stackID)
+			if err != nil {
+				return "", err
+			}
+			d.stackLogger(stackName).Info("Stack status", "status", status)
+
+			if !strings.HasSuffix(status, "_IN_PROGRESS") {
+				return status, nil
+			}
+		}
+	}
+}
+
+// cancelUpdate cancels an in-progress update after the deploy context was cancelled,
+// so that the stack rolls back instead of being left mid-update
+func (d *Deployer) cancelUpdate(stackName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	status, err := d.getStackStatus(ctx, stackName)
+	if err != nil || types.StackStatus(status) != types.StackStatusUpdateInProgress {
+		return
+	}
+
+	logger := d.stackLogger(stackName)
+	logger.Info("Cancelling stack update")
+	_, err = d.cfnClient.CancelUpdateStack(ctx, &cloudformation.CancelUpdateStackInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		logger.Warn("Failed to cancel stack update", "error", err)
+		return
+	}
+	logger.Info("Stack update cancelled; CloudFormation is rolling it back")
+}
+
+// confirmRecovery returns true when the recovery is enabled, otherwise asks the prompter
+func (d *Deployer) confirmRecovery(enabled bool, question string) (bool, error) {
+	if enabled {
+		return true, nil
+	}
+	if d.recovery.Prompter == nil {
+		return false, nil
+	}
+	return d.recovery.Prompter.Confirm(question)
+}
@@ -0,0 +1,476 @@
+package cdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// remediationMetadataKey is the template metadata key bumped to force a resource update
+const remediationMetadataKey = "cdk-deployer:remediation"
+
+// RemediationStrategies lists the strategies offered for a drifted resource
+var RemediationStrategies = []string{
+	string(RemediationReapply),
+	string(RemediationImport),
+	string(RemediationPlan),
+	string(RemediationSkip),
+}
+
+// driftOverrides is the on-disk format of an imported drift override file
+type driftOverrides struct {
+	StackName   string                        `json:"stackName"`
+	GeneratedAt string                        `json:"generatedAt"`
+	Resources   map[string]driftOverrideEntry `json:"resources"`
+}
+
+// driftOverrideEntry holds the actual values of a single drifted resource
+type driftOverrideEntry struct {
+	ResourceType string            `json:"resourceType"`
+	PhysicalID   string            `json:"physicalId"`
+	DriftStatus  string            `json:"driftStatus"`
+	Properties   map[string]string `json:"properties"`
+}
+
+// RemediateDrift brings the drifted resources of a stack back in line with its template.
+// Every remediation is previewed as a change set and requires confirmation from the
+// prompter.
+func (d *Deployer) RemediateDrift(ctx context.Context, drift *DriftResult, opts RemediationOptions) (*RemediationResult, error) {
+	if opts.Prompter == nil {
+		return nil, fmt.Errorf("a prompter is required to confirm drift remediation")
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = "."
+	}
+
+	ctx, unlock, err := d.lockStack(ctx, drift.StackName, "remediate")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result := &RemediationResult{
+		StackName: drift.StackName,
+	}
+
+	logger := d.stackLogger(drift.StackName)
+
+	var reapply, imports, plans []DriftedResource
+	for _, dr := range drift.DriftedResources {
+		if dr.DriftStatus == string(types.StackResourceDriftStatusDeleted) && !dr.Suppressed {
+			logger.Warn("Drifted resource was deleted and cannot be remediated; remove it from the stack and deploy, or recreate and import it",
+				"logicalId", dr.LogicalID, "type", dr.ResourceType)
+		}
+		if !isRemediable(dr) {
+			continue
+		}
+
+		strategy := opts.Strategy
+		if strategy == "" {
+			logger.Info("Drifted resource", "logicalId", dr.LogicalID, "type", dr.ResourceType, "status", dr.DriftStatus)
+			for _, pd := range dr.PropertyDiffs {
+				logger.Info("Drifted property", "logicalId", dr.LogicalID, "path", pd.PropertyPath,
+					"expected", pd.ExpectedValue, "actual", pd.ActualValue)
+			}
+			choice, err := opts.Prompter.Choose("Remediation strategy", RemediationStrategies)
+			if err != nil {
+				return result, err
+			}
+			strategy = RemediationStrategy(choice)
+		}
+
+		switch strategy {
+		case RemediationReapply:
+			reapply = append(reapply, dr)
+		case RemediationImport:
+			imports = append(imports, dr)
+		case RemediationPlan:
+			plans = append(plans, dr)
+		case RemediationSkip:
+		default:
+			return result, fmt.Errorf("unknown remediation strategy: %s", strategy)
+		}
+
+		result.Actions = append(result.Actions, RemediationAction{
+			LogicalID:    dr.LogicalID,
+			ResourceType: dr.ResourceType,
+			Strategy:     strategy,
+		})
+	}
+
+	if len(imports) > 0 {
+		// Preview what adopting the actual values in the template would change
+		for _, dr := range imports {
+			for _, pd := range dr.PropertyDiffs {
+				logger.Info("Drift override", "logicalId", dr.LogicalID, "path", pd.PropertyPath, "actual", pd.ActualValue)
+			}
+		}
+		summary, err := d.previewOverrides(ctx, drift.StackName, imports)
+		if err != nil {
+			return result, err
+		}
+		d.logChangeSet(summary)
+		if err := d.deleteChangeSet(ctx, summary); err != nil {
+			return result, err
+		}
+
+		path := filepath.Join(opts.OutputDir, drift.StackName+".drift-overrides.json")
+		ok, err := opts.Prompter.Confirm(fmt.Sprintf("Write actual values of %d resource(s) to %s?", len(imports), path))
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			if err := writeDriftOverrides(path, drift.StackName, imports); err != nil {
+				return result, err
+			}
+			result.OverridesFile = path
+			logger.Info("Drift overrides written", "file", path)
+		}
+	}
+
+	if len(plans) > 0 {
+		summary, err := d.previewRemediation(ctx, drift.StackName, plans)
+		if err != nil {
+			return result, err
+		}
+		d.logChangeSet(summary)
+		if err := d.deleteChangeSet(ctx, summary); err != nil {
+			return result, err
+		}
+
+		path := filepath.Join(opts.OutputDir, drift.StackName+".remediation-plan.md")
+		ok, err := opts.Prompter.Confirm(fmt.Sprintf("Write remediation plan to %s?", path))
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			if err := writeRemediationPlan(path, drift.StackName, plans, summary); err != nil {
+				return result, err
+			}
+			result.PlanFile = path
+			logger.Info("Remediation plan written", "file", path)
+		}
+	}
+
+	if len(reapply) > 0 {
+		summary, err := d.previewRemediation(ctx, drift.StackName, reapply)
+		if err != nil {
+			return result, err
+		}
+		result.ChangeSet = summary
+		d.logChangeSet(summary)
+
+		if !summary.HasChanges() {
+			return result, d.deleteChangeSet(ctx, summary)
+		}
+
+		ok, err := opts.Prompter.Confirm(fmt.Sprintf("Execute change set to re-apply %d resource(s) in %s?", len(reapply), drift.StackName))
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			logger.Info("Remediation cancelled, deleting change set")
+			return result, d.deleteChangeSet(ctx, summary)
+		}
+
+		status, err := d.executeChangeSet(ctx, summary)
+		result.Status = status
+		if err != nil {
+			return result, err
+		}
+		result.Executed = true
+	}
+
+	return result, nil
+}
+
+// isRemediable reports whether a drifted resource can be acted upon. Deleted resources
+// cannot: re-applying the template does not recreate them and they have no actual
+// values to import.
+func isRemediable(dr DriftedResource) bool {
+	if dr.Suppressed {
+		return false
+	}
+	return dr.DriftStatus == string(types.StackResourceDriftStatusModified)
+}
+
+// previewRemediation creates a change set re-applying the deployed template and
+// parameter values with the metadata of the given resources bumped so that
+// CloudFormation updates them
+func (d *Deployer) previewRemediation(ctx context.Context, stackName string, resources []DriftedResource) (*ChangeSetSummary, error) {
+	return d.remediationChangeSet(ctx, stackName, resources, "Drift remediation", func(templateBody string, logicalIDs []string) (string, error) {
+		return bumpResourceMetadata(templateBody, logicalIDs, time.Now().UTC().Format(time.RFC3339))
+	})
+}
+
+// previewOverrides creates a change set of the deployed template with the drifted
+// properties of the given resources set to their actual values, previewing what
+// adopting the drift overrides in the code would change
+func (d *Deployer) previewOverrides(ctx context.Context, stackName string, resources []DriftedResource) (*ChangeSetSummary, error) {
+	return d.remediationChangeSet(ctx, stackName, resources, "Drift overrides", func(templateBody string, _ []string) (string, error) {
+		return applyDriftOverrides(templateBody, resources)
+	})
+}
+
+// remediationChangeSet creates a change set of the deployed template, changed by edit,
+// with the previous parameter values
+func (d *Deployer) remediationChangeSet(ctx context.Context, stackName string, resources []DriftedResource, purpose string, edit func(string, []string) (string, error)) (*ChangeSetSummary, error) {
+	templateBody, err := d.getDeployedTemplate(ctx, stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	logicalIDs := make([]string, 0, len(resources))
+	for _, r := range resources {
+		logicalIDs = append(logicalIDs, r.LogicalID)
+	}
+
+	edited, err := edit(templateBody, logicalIDs)
+	if err != nil {
+		return nil, err
+	}
+	params, err := d.getPreviousParameters(ctx, stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.createChangeSet(ctx, changeSetRequest{
+		StackName:     stackName,
+		ChangeSetName: fmt.Sprintf("cdk-deployer-remediate-%d", time.Now().Unix()),
+		ChangeSetType: types.ChangeSetTypeUpdate,
+		TemplateBody:  edited,
+		Description:   purpose + " for " + strings.Join(logicalIDs, ", "),
+		Parameters:    params,
+	})
+}
+
+// getDeployedTemplate returns the original template of a deployed stack
+func (d *Deployer) getDeployedTemplate(ctx context.Context, stackName string) (string, error) {
+	output, err := d.cfnClient.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     aws.String(stackName),
+		TemplateStage: types.TemplateStageOriginal,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get template for stack %s: %w", stackName, err)
+	}
+	return aws.ToString(output.TemplateBody), nil
+}
+
+// bumpResourceMetadata sets a remediation marker in the metadata of the given resources
+func bumpResourceMetadata(templateBody string, logicalIDs []string, marker string) (string, error) {
+	template, err := parseTemplate(templateBody)
+	if err != nil {
+		return "", err
+	}
+
+	resources, ok := template["Resources"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("template has no Resources section")
+	}
+
+	for _, id := range logicalIDs {
+		resource, ok := resources[id].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("resource %s not found in template", id)
+		}
+		metadata, ok := resource["Metadata"].(map[string]interface{})
+		if !ok {
+			metadata = map[string]interface{}{}
+			resource["Metadata"] = metadata
+		}
+		metadata[remediationMetadataKey] = marker
+	}
+
+	data, err := json.Marshal(template)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode template: %w", err)
+	}
+	return string(data), nil
+}
+
+// applyDriftOverrides sets the drifted properties of the given resources to their
+// actual values in a template. Property paths are JSON pointers into the resource
+// properties; values that are not JSON are taken as strings.
+func applyDriftOverrides(templateBody string, resources []DriftedResource) (string, error) {
+	template, err := parseTemplate(templateBody)
+	if err != nil {
+		return "", err
+	}
+
+	templateResources, ok := template["Resources"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("template has no Resources section")
+	}
+
+	for _, r := range resources {
+		resource, ok := templateResources[r.LogicalID].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("resource %s not found in template", r.LogicalID)
+		}
+		var properties interface{} = resource["Properties"]
+		if properties == nil {
+			properties = map[string]interface{}{}
+		}
+		for _, pd := range r.PropertyDiffs {
+			remove := pd.DifferenceType == string(types.DifferenceTypeRemove)
+			var value interface{} = pd.ActualValue
+			if !remove && json.Valid([]byte(pd.ActualValue)) {
+				json.Unmarshal([]byte(pd.ActualValue), &value)
+			}
+			properties, err = setPointer(properties, splitPointer(pd.PropertyPath), value, remove)
+			if err != nil {
+				return "", fmt.Errorf("failed to override %s of %s: %w", pd.PropertyPath, r.LogicalID, err)
+			}
+		}
+		resource["Properties"] = properties
+	}
+
+	data, err := json.Marshal(template)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode template: %w", err)
+	}
+	return string(data), nil
+}
+
+// splitPointer splits a JSON pointer such as /Tags/0/Value into unescaped segments
+func splitPointer(pointer string) []string {
+	pointer = strings.TrimPrefix(pointer, "/")
+	if pointer == "" {
+		return nil
+	}
+	segments := strings.Split(pointer, "/")
+	for i, seg := range segments {
+		segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(seg)
+	}
+	return segments
+}
+
+// setPointer sets or removes the value at a path below node and returns the updated
+// node. Missing objects along the path are created.
+func setPointer(node interface{}, path []string, value interface{}, remove bool) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	key, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case nil:
+		if remove {
+			return nil, nil
+		}
+		child, err := setPointer(nil, rest, value, false)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{key: child}, nil
+
+	case map[string]interface{}:
+		if remove && len(rest) == 0 {
+			delete(n, key)
+			return n, nil
+		}
+		child, err := setPointer(n[key], rest, value, remove)
+		if err != nil {
+			return nil, err
+		}
+		n[key] = child
+		return n, nil
+
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i > len(n) || (i == len(n) && remove) {
+			return nil, fmt.Errorf("invalid list index %q", key)
+		}
+		if remove && len(rest) == 0 {
+			return append(n[:i], n[i+1:]...), nil
+		}
+		if i == len(n) {
+			n = append(n, nil)
+		}
+		child, err := setPointer(n[i], rest, value, remove)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+
+	default:
+		return nil, fmt.Errorf("%q is not an object or list", key)
+	}
+}
+
+// writeDriftOverrides writes the actual values of drifted resources to a JSON file
+func writeDriftOverrides(path, stackName string, resources []DriftedResource) error {
+	overrides := driftOverrides{
+		StackName:   stackName,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Resources:   make(map[string]driftOverrideEntry),
+	}
+
+	for _, r := range resources {
+		entry := driftOverrideEntry{
+			ResourceType: r.ResourceType,
+			PhysicalID:   r.PhysicalID,
+			DriftStatus:  r.DriftStatus,
+			Properties:   make(map[string]string),
+		}
+		for _, pd := range r.PropertyDiffs {
+			entry.Properties[pd.PropertyPath] = pd.ActualValue
+		}
+		overrides.Resources[r.LogicalID] = entry
+	}
+
+	data, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode drift overrides: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write drift overrides: %w", err)
+	}
+	return nil
+}
+
+// writeRemediationPlan writes a Markdown remediation plan for the given resources
+func writeRemediationPlan(path, stackName string, resources []DriftedResource, preview *ChangeSetSummary) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Drift remediation plan for %s\n\n", stackName)
+	fmt.Fprintf(&b, "Generated at %s\n\n", time.Now().UTC().Format(time.RFC3339))
+
+	for _, r := range resources {
+		fmt.Fprintf(&b, "## %s (%s)\n\n", r.LogicalID, r.ResourceType)
+		fmt.Fprintf(&b, "- Physical ID: `%s`\n", r.PhysicalID)
+		fmt.Fprintf(&b, "- Drift status: %s\n\n", r.DriftStatus)
+
+		b.WriteString("| Property | Expected | Actual | Difference |\n")
+		b.WriteString("|----------|----------|--------|------------|\n")
+		for _, pd := range r.PropertyDiffs {
+			fmt.Fprintf(&b, "| `%s` | `%s` | `%s` | %s |\n", pd.PropertyPath, pd.ExpectedValue, pd.ActualValue, pd.DifferenceType)
+		}
+		b.WriteString("\nEither re-apply the template to restore the expected values, ")
+		b.WriteString("or update the CDK code to match the actual values.\n\n")
+	}
+
+	b.WriteString("## Change set preview for re-applying the template\n\n")
+	if !preview.HasChanges() {
+		b.WriteString("Re-applying the template produces no changes.\n")
+	} else {
+		for _, c := range preview.Changes {
+			fmt.Fprintf(&b, "- %s `%s` (%s), replacement: %s\n", c.Action, c.LogicalID, c.ResourceType, c.Replacement)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write remediation plan: %w", err)
+	}
+	return nil
+}
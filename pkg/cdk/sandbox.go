@@ -0,0 +1,296 @@
+package cdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Isolation selects how the commands run from a project are isolated from the host
+type Isolation string
+
+const (
+	// IsolationNone runs the commands as plain child processes
+	IsolationNone Isolation = "none"
+	// IsolationNamespaces runs the commands in new user, PID, IPC and UTS namespaces,
+	// and a new network namespace when the network is disabled (Linux only)
+	IsolationNamespaces Isolation = "namespaces"
+	// IsolationBwrap runs the commands under bubblewrap with a read-only root file
+	// system, a private /tmp and home directory, and optionally a seccomp filter
+	IsolationBwrap Isolation = "bwrap"
+)
+
+// Validate returns an error for an unknown isolation
+func (i Isolation) Validate() error {
+	switch i {
+	case "", IsolationNone, IsolationNamespaces, IsolationBwrap:
+		return nil
+	default:
+		return fmt.Errorf("unknown sandbox isolation %q (use none, namespaces or bwrap)", i)
+	}
+}
+
+// Network selects whether the app may use the network during synthesis
+type Network string
+
+const (
+	// NetworkAllow leaves the network available
+	NetworkAllow Network = "allow"
+	// NetworkNone runs the app without network access; it needs an isolation
+	NetworkNone Network = "none"
+)
+
+// Validate returns an error for an unknown network setting
+func (n Network) Validate() error {
+	switch n {
+	case "", NetworkAllow, NetworkNone:
+		return nil
+	default:
+		return fmt.Errorf("unknown sandbox network %q (use allow or none)", n)
+	}
+}
+
+// Sandbox restricts the commands run from a project: dependency installs, the
+// TypeScript compiler, the app and the CDK CLI. These run code from the repository,
+// so by default they only see an allowlist of environment variables and never the
+// deployer's AWS credentials.
+type Sandbox struct {
+	// Env names further environment variables passed to the commands, where a trailing
+	// * matches a prefix and * alone passes the whole environment. AWS credentials are
+	// never passed this way.
+	Env []string `yaml:"env,omitempty"`
+	// Lookups lets the CDK CLI perform the context lookups a synthesized assembly
+	// lacks. Only this lookup step receives the AWS credentials; the CLI is given the
+	// assembly, not the app, so the app never runs with them.
+	Lookups bool `yaml:"lookups,omitempty"`
+	// Isolation is none, namespaces or bwrap
+	Isolation Isolation `yaml:"isolation,omitempty"`
+	// Network is allow or none; installs always have the network
+	Network Network `yaml:"network,omitempty"`
+	// Seccomp blocks system calls such as ptrace, mount and bpf; it needs bwrap
+	Seccomp bool `yaml:"seccomp,omitempty"`
+	// CPUTime limits the CPU time of each command
+	CPUTime time.Duration `yaml:"cpuTime,omitempty"`
+	// Memory limits the address space of each command in bytes
+	Memory int64 `yaml:"-"`
+	// Timeout limits the wall-clock time of each command, installs included
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// WithSandbox restricts the commands run from the project
+func WithSandbox(sb Sandbox) Option {
+	return func(c *CDK) {
+		c.synthesizer.sandbox = sb
+	}
+}
+
+// Validate returns an error for invalid or contradicting settings
+func (sb Sandbox) Validate() error {
+	if err := sb.Isolation.Validate(); err != nil {
+		return err
+	}
+	if err := sb.Network.Validate(); err != nil {
+		return err
+	}
+	isolated := sb.Isolation != "" && sb.Isolation != IsolationNone
+	if sb.Network == NetworkNone && !isolated {
+		return errors.New("sandbox network none needs namespaces or bwrap isolation")
+	}
+	if sb.Network == NetworkNone && sb.Lookups {
+		return errors.New("sandbox lookups need the network")
+	}
+	if sb.Seccomp && sb.Isolation != IsolationBwrap {
+		return errors.New("sandbox seccomp needs bwrap isolation")
+	}
+	if sb.CPUTime < 0 || sb.Memory < 0 || sb.Timeout < 0 {
+		return errors.New("sandbox limits must not be negative")
+	}
+	if sb.CPUTime > 0 && sb.CPUTime < time.Second {
+		return errors.New("sandbox CPU time must be at least 1s")
+	}
+	return nil
+}
+
+// step is the kind of command run in the sandbox
+type step int
+
+const (
+	// stepInstall installs dependencies and needs the network
+	stepInstall step = iota
+	// stepSynth compiles or runs the app, directly or through the CDK CLI
+	stepSynth
+	// stepLookup runs the CDK CLI on a synthesized assembly to perform its context
+	// lookups; it never runs the app
+	stepLookup
+)
+
+// defaultEnv are the environment variables passed to the commands: the basics of a
+// shell, proxies and CA bundles, and the settings of the package managers
+var defaultEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "TZ", "LANG", "LC_*", "TMPDIR",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"SSL_CERT_FILE", "SSL_CERT_DIR", "NODE_EXTRA_CA_CERTS", "REQUESTS_CA_BUNDLE",
+	"GOPATH", "GOCACHE", "GOMODCACHE", "GOPROXY", "GOFLAGS", "GOPRIVATE", "GONOSUMDB",
+	"JAVA_HOME", "MAVEN_OPTS", "CDK_*", "JSII_*", "AWS_REGION", "AWS_DEFAULT_REGION",
+}
+
+// environ returns the environment of a command of the given step: the allowed
+// variables of the deployer's environment followed by extra
+func (sb Sandbox) environ(st step, extra []string) []string {
+	lookups := st == stepLookup && sb.Lookups
+	allowed := append(append([]string{}, defaultEnv...), sb.Env...)
+
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch {
+		case strings.HasPrefix(name, "AWS_") && name != "AWS_REGION" && name != "AWS_DEFAULT_REGION":
+			// Credentials, profiles and the files holding them
+			if lookups {
+				env = append(env, kv)
+			}
+		case matchEnv(allowed, name):
+			env = append(env, kv)
+		}
+	}
+	if !lookups {
+		// Keep the AWS SDKs from taking credentials from the instance metadata service
+		env = append(env, "AWS_EC2_METADATA_DISABLED=true")
+	}
+	return append(env, extra...)
+}
+
+// matchEnv reports whether a variable name matches one of the patterns
+func matchEnv(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// network reports whether a command of the given step may use the network
+func (sb Sandbox) network(st step) bool {
+	return st == stepInstall || sb.Network != NetworkNone
+}
+
+// limitScript applies the resource limits in a shell before it runs the command
+const limitScript = `ulimit -t "$CDK_DEPLOYER_CPU" && ulimit -v "$CDK_DEPLOYER_MEM" && exec "$@"`
+
+// command builds the command for a step in the project directory, wrapped in the
+// isolation and resource limits. The returned function releases what the command
+// needed and must be called once it has finished.
+func (s *Synthesizer) command(ctx context.Context, st step, extraEnv []string, name string, args ...string) (*exec.Cmd, func(), error) {
+	sb := s.sandbox
+	if err := sb.Validate(); err != nil {
+		return nil, nil, err
+	}
+	env := sb.environ(st, extraEnv)
+
+	argv := append([]string{name}, args...)
+	if sb.CPUTime > 0 || sb.Memory > 0 {
+		cpu, mem := "unlimited", "unlimited"
+		if sb.CPUTime > 0 {
+			cpu = strconv.FormatInt(int64(sb.CPUTime.Round(time.Second)/time.Second), 10)
+		}
+		if sb.Memory > 0 {
+			mem = strconv.FormatInt((sb.Memory+1023)/1024, 10)
+		}
+		env = append(env, "CDK_DEPLOYER_CPU="+cpu, "CDK_DEPLOYER_MEM="+mem)
+		argv = append([]string{"sh", "-c", limitScript, "sh"}, argv...)
+	}
+
+	var extraFiles []*os.File
+	release := func() {
+		for _, f := range extraFiles {
+			f.Close()
+		}
+	}
+
+	if sb.Isolation == IsolationBwrap {
+		bwrap, err := exec.LookPath("bwrap")
+		if err != nil {
+			return nil, nil, fmt.Errorf("bwrap isolation needs bubblewrap: %w", err)
+		}
+		wrapper, err := s.bwrapArgs(st, env)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sb.Seccomp {
+			filter, err := seccompFile()
+			if err != nil {
+				return nil, nil, err
+			}
+			extraFiles = append(extraFiles, filter)
+			// Extra files start at descriptor 3 in the child
+			wrapper = append(wrapper, "--seccomp", "3")
+		}
+		argv = append(append([]string{bwrap}, wrapper...), append([]string{"--"}, argv...)...)
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = s.projectPath
+	cmd.Env = env
+	cmd.ExtraFiles = extraFiles
+	// Children of the killed command may keep its output open; stop waiting for them
+	cmd.WaitDelay = 5 * time.Second
+
+	if sb.Isolation == IsolationNamespaces {
+		if err := isolate(cmd, sb.network(st)); err != nil {
+			release()
+			return nil, nil, err
+		}
+	}
+	return cmd, release, nil
+}
+
+// bwrapArgs returns the bubblewrap options: a read-only view of the host with the
+// project writable, and an empty home directory so files such as ~/.aws/credentials
+// and ~/.ssh stay hidden
+func (s *Synthesizer) bwrapArgs(st step, env []string) ([]string, error) {
+	project, err := filepath.Abs(s.projectPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve project path: %w", err)
+	}
+	args := []string{
+		"--die-with-parent", "--new-session", "--unshare-all",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+	if s.sandbox.network(st) {
+		args = append(args, "--share-net")
+	}
+	if home, err := os.UserHomeDir(); err == nil && home != "/" {
+		args = append(args, "--tmpfs", home)
+		if st == stepLookup && s.sandbox.Lookups {
+			args = append(args, "--ro-bind-try", filepath.Join(home, ".aws"), filepath.Join(home, ".aws"))
+		}
+	}
+	args = append(args, "--bind", project, project)
+	if out, err := filepath.Abs(s.outputDir); err == nil && !strings.HasPrefix(out, project+string(filepath.Separator)) {
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		args = append(args, "--bind", out, out)
+	}
+	// TMPDIR elsewhere than /tmp is read-only in the sandbox
+	for _, kv := range env {
+		if dir, ok := strings.CutPrefix(kv, "TMPDIR="); ok && dir != "" {
+			args = append(args, "--tmpfs", dir)
+		}
+	}
+	return append(args, "--chdir", project), nil
+}
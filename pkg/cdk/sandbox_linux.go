@@ -0,0 +1,108 @@
+//go:build linux
+
+package cdk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isolate runs a command in new user, PID, IPC and UTS namespaces, and in a new
+// network namespace without network access. The command keeps the user's IDs, and
+// killing it kills everything it started.
+func isolate(cmd *exec.Cmd, network bool) error {
+	flags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS)
+	if !network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  flags,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+		Pdeathsig:   syscall.SIGKILL,
+	}
+	return nil
+}
+
+// deniedSyscalls are the system calls the seccomp filter fails with EPERM: debugging
+// and reading other processes, kernel modules and keyrings, mounts and namespaces
+var deniedSyscalls = []uint32{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_KEXEC_LOAD, unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF,
+	unix.SYS_REBOOT, unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY, unix.SYS_SETNS, unix.SYS_UNSHARE,
+}
+
+// auditArch is the seccomp architecture of each supported GOARCH
+var auditArch = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+}
+
+// x32SyscallBit marks the system calls of the x32 ABI, which would bypass the filter
+const x32SyscallBit = 0x40000000
+
+// seccompFilter returns the classic BPF program denying deniedSyscalls
+func seccompFilter() ([]unix.SockFilter, error) {
+	arch, ok := auditArch[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+
+	n := len(deniedSyscalls)
+	// Layout: load arch, check it, kill, load number, check x32, n checks, allow, deny
+	deny := 6 + n
+	jump := func(from, to int) uint8 { return uint8(to - from - 1) }
+
+	prog := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 4},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: arch},
+		{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_KILL_PROCESS},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 0},
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: jump(4, deny), K: x32SyscallBit},
+	}
+	for i, nr := range deniedSyscalls {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: jump(5+i, deny), K: nr})
+	}
+	prog = append(prog,
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ALLOW},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+	)
+	return prog, nil
+}
+
+// seccompFile writes the seccomp filter to an unlinked temporary file for bwrap --seccomp
+func seccompFile() (*os.File, error) {
+	prog, err := seccompFilter()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 8*len(prog))
+	for _, ins := range prog {
+		buf = binary.NativeEndian.AppendUint16(buf, ins.Code)
+		buf = append(buf, ins.Jt, ins.Jf)
+		buf = binary.NativeEndian.AppendUint32(buf, ins.K)
+	}
+
+	f, err := os.CreateTemp("", "cdk-deployer-seccomp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create seccomp filter: %w", err)
+	}
+	os.Remove(f.Name())
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write seccomp filter: %w", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write seccomp filter: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package cdk
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// isolate is only supported on Linux
+func isolate(cmd *exec.Cmd, network bool) error {
+	return errors.New("namespaces isolation is only supported on Linux")
+}
+
+// seccompFile is only supported on Linux
+func seccompFile() (*os.File, error) {
+	return nil, errors.New("seccomp is only supported on Linux")
+}
@@ -0,0 +1,95 @@
+package cdk
+
+import "fmt"
+
+// StackSelection selects stacks of the cloud assembly
+type StackSelection struct {
+	// Include holds stack names or glob patterns matched against the stack name and
+	// its hierarchical display name (e.g. Prod/*); empty selects every stack
+	Include []string
+	// Exclude holds patterns of stacks to leave out, even when they are dependencies
+	Exclude []string
+	// Exclusively skips the automatic inclusion of upstream dependencies
+	Exclusively bool
+}
+
+// SelectStacks returns the names of the selected stacks in assembly order. Unless
+// Exclusively is set, the stacks the selected stacks depend on are included too.
+func SelectStacks(stacks []StackInfo, sel StackSelection) ([]string, error) {
+	byName := make(map[string]StackInfo, len(stacks))
+	for _, s := range stacks {
+		byName[s.Name] = s
+	}
+
+	selected := make(map[string]bool)
+	for _, pattern := range sel.Include {
+		matched := false
+		for _, s := range stacks {
+			if s.matches(pattern) {
+				selected[s.Name] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no stack matches %q", pattern)
+		}
+	}
+	if len(sel.Include) == 0 {
+		for _, s := range stacks {
+			selected[s.Name] = true
+		}
+	}
+
+	excluded := func(s StackInfo) bool {
+		for _, pattern := range sel.Exclude {
+			if s.matches(pattern) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !sel.Exclusively {
+		var addDeps func(name string)
+		addDeps = func(name string) {
+			for _, dep := range byName[name].Dependencies {
+				if !selected[dep] && !excluded(byName[dep]) {
+					selected[dep] = true
+					addDeps(dep)
+				}
+			}
+		}
+		for _, s := range stacks {
+			if selected[s.Name] && !excluded(s) {
+				addDeps(s.Name)
+			}
+		}
+	}
+
+	var names []string
+	for _, s := range stacks {
+		if selected[s.Name] && !excluded(s) {
+			names = append(names, s.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no stacks selected")
+	}
+	return names, nil
+}
+
+// matches reports whether the stack name or display name matches a pattern
+func (s StackInfo) matches(pattern string) bool {
+	if MatchGlob(pattern, s.Name) {
+		return true
+	}
+	return s.DisplayName != "" && MatchGlob(pattern, s.DisplayName)
+}
+
+// String describes the stack with its display name when it differs from the name
+func (s StackInfo) String() string {
+	if s.DisplayName == "" || s.DisplayName == s.Name {
+		return s.Name
+	}
+	return fmt.Sprintf("%s (%s)", s.Name, s.DisplayName)
+}
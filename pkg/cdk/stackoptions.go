@@ -0,0 +1,152 @@
+package cdk
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// StackOptions are per-stack deployment settings
+type StackOptions struct {
+	// RollbackTriggers are CloudWatch alarm ARNs that roll the stack back when they fire
+	RollbackTriggers []string `json:"rollbackTriggers,omitempty" yaml:"rollbackTriggers,omitempty"`
+	// MonitoringTimeMinutes is how long the rollback triggers are watched after a deploy
+	MonitoringTimeMinutes int32 `json:"monitoringTimeMinutes,omitempty" yaml:"monitoringTimeMinutes,omitempty"`
+	// DisableRollback keeps failed resources in place for faster iteration
+	DisableRollback *bool `json:"disableRollback,omitempty" yaml:"disableRollback,omitempty"`
+	// OnFailure is ROLLBACK, DO_NOTHING or DELETE and only applies when creating a stack
+	OnFailure string `json:"onFailure,omitempty" yaml:"onFailure,omitempty"`
+	// Parameters are CloudFormation parameter values
+	Parameters map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	// Tags are applied to the stack and propagated to its resources
+	Tags map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// RoleARN is the service role CloudFormation assumes for stack operations
+	RoleARN string `json:"roleArn,omitempty" yaml:"roleArn,omitempty"`
+}
+
+// Merge returns o overlaid with the values set in other
+func (o StackOptions) Merge(other StackOptions) StackOptions {
+	if len(other.RollbackTriggers) > 0 {
+		o.RollbackTriggers = other.RollbackTriggers
+	}
+	if other.MonitoringTimeMinutes != 0 {
+		o.MonitoringTimeMinutes = other.MonitoringTimeMinutes
+	}
+	if other.DisableRollback != nil {
+		o.DisableRollback = other.DisableRollback
+	}
+	if other.OnFailure != "" {
+		o.OnFailure = other.OnFailure
+	}
+	o.Parameters = MergeMaps(o.Parameters, other.Parameters)
+	o.Tags = MergeMaps(o.Tags, other.Tags)
+	if other.RoleARN != "" {
+		o.RoleARN = other.RoleARN
+	}
+	return o
+}
+
+// MergeMaps returns a new map with the entries of overlay added to base
+func MergeMaps(base, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Validate checks the options for values CloudFormation would reject
+func (o StackOptions) Validate() error {
+	switch types.OnFailure(o.OnFailure) {
+	case "", types.OnFailureRollback, types.OnFailureDoNothing, types.OnFailureDelete:
+	default:
+		return fmt.Errorf("invalid onFailure %q (use ROLLBACK, DO_NOTHING or DELETE)", o.OnFailure)
+	}
+	if o.MonitoringTimeMinutes < 0 || o.MonitoringTimeMinutes > 180 {
+		return fmt.Errorf("monitoringTimeMinutes must be between 0 and 180")
+	}
+	if len(o.RollbackTriggers) > 5 {
+		return fmt.Errorf("at most 5 rollback triggers are supported")
+	}
+	if o.rollbackDisabled() && len(o.RollbackTriggers) > 0 {
+		return fmt.Errorf("rollback triggers cannot be combined with disableRollback")
+	}
+	return nil
+}
+
+// rollbackDisabled reports whether rollback is disabled
+func (o StackOptions) rollbackDisabled() bool {
+	return aws.ToBool(o.DisableRollback)
+}
+
+// rollbackConfiguration converts the rollback triggers for the CloudFormation API
+func (o StackOptions) rollbackConfiguration() *types.RollbackConfiguration {
+	if len(o.RollbackTriggers) == 0 && o.MonitoringTimeMinutes == 0 {
+		return nil
+	}
+
+	cfg := &types.RollbackConfiguration{}
+	if o.MonitoringTimeMinutes > 0 {
+		cfg.MonitoringTimeInMinutes = aws.Int32(o.MonitoringTimeMinutes)
+	}
+	for _, arn := range o.RollbackTriggers {
+		cfg.RollbackTriggers = append(cfg.RollbackTriggers, types.RollbackTrigger{
+			Arn:  aws.String(arn),
+			Type: aws.String("AWS::CloudWatch::Alarm"),
+		})
+	}
+	return cfg
+}
+
+// parameters converts the parameter values for the CloudFormation API
+func (o StackOptions) parameters() []types.Parameter {
+	var params []types.Parameter
+	for _, k := range sortedKeys(o.Parameters) {
+		params = append(params, types.Parameter{
+			ParameterKey:   aws.String(k),
+			ParameterValue: aws.String(o.Parameters[k]),
+		})
+	}
+	return params
+}
+
+// tags converts the tags for the CloudFormation API
+func (o StackOptions) tags() []types.Tag {
+	var tags []types.Tag
+	for _, k := range sortedKeys(o.Tags) {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(o.Tags[k]),
+		})
+	}
+	return tags
+}
+
+// roleARN returns the service role, or nil when none is configured
+func (o StackOptions) roleARN() *string {
+	if o.RoleARN == "" {
+		return nil
+	}
+	return aws.String(o.RoleARN)
+}
+
+// sortedKeys returns the keys of a map in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// StackOptionsFunc resolves the deployment options for a stack
+type StackOptionsFunc func(stackName string) StackOptions
@@ -1,20 +1,42 @@
 package cdk
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"cdk-deployer/pkg/event"
+	"cdk-deployer/pkg/logging"
 )
 
 // Synthesizer handles CDK synthesis operations
 type Synthesizer struct {
-	projectPath string
-	outputDir   string
+	projectPath  string
+	outputDir    string
+	timeout      time.Duration
+	context      map[string]string
+	contextSeed  string
+	contextCache string
+	mode         SynthMode
+	sandbox      Sandbox
+	logger       *slog.Logger
+	events       event.Handler
+}
+
+// installTools names the tool installing the dependencies of each project type
+var installTools = map[string]string{
+	"typescript": "npm",
+	"python":     "pip",
+	"go":         "go",
+	"java":       "mvn",
 }
 
 // NewSynthesizer creates a new CDK synthesizer
@@ -22,6 +44,7 @@ func NewSynthesizer(projectPath string) *Synthesizer {
 	return &Synthesizer{
 		projectPath: projectPath,
 		outputDir:   filepath.Join(projectPath, "cdk.out"),
+		logger:      slog.Default(),
 	}
 }
 
@@ -61,44 +84,57 @@ func (s *Synthesizer) DetectProjectType() (string, error) {
 
 // InstallDependencies installs project dependencies based on project type
 func (s *Synthesizer) InstallDependencies(projectType string) error {
-	var cmd *exec.Cmd
+	return s.InstallDependenciesContext(context.Background(), projectType)
+}
+
+// InstallDependenciesContext is like InstallDependencies but stops the install when
+// ctx is cancelled
+func (s *Synthesizer) InstallDependenciesContext(ctx context.Context, projectType string) error {
+	source := installTools[projectType]
+	start := time.Now()
+	s.events.Emit(event.Event{Type: event.InstallStarted, Source: source})
+
+	err := s.installDependencies(ctx, projectType)
+
+	s.events.Emit(event.Event{Type: event.InstallFinished, Source: source, Duration: time.Since(start), Err: err})
+	return err
+}
+
+// installDependencies runs the installation for a project type
+func (s *Synthesizer) installDependencies(ctx context.Context, projectType string) error {
+	var args []string
 
 	switch projectType {
 	case "typescript":
 		// Check if node_modules exists
 		if _, err := os.Stat(filepath.Join(s.projectPath, "node_modules")); os.IsNotExist(err) {
-			fmt.Println("Installing npm dependencies...")
-			cmd = exec.Command("npm", "install")
+			s.logger.Info("Installing npm dependencies")
+			args = []string{"npm", "install"}
 		} else {
-			fmt.Println("Dependencies already installed")
+			s.logger.Info("Dependencies already installed")
 			return nil
 		}
 	case "python":
-		return s.installPythonDependencies()
+		return s.installPythonDependencies(ctx)
 	case "go":
-		fmt.Println("Installing Go dependencies...")
-		cmd = exec.Command("go", "mod", "download")
+		s.logger.Info("Installing Go dependencies")
+		args = []string{"go", "mod", "download"}
 	case "java":
-		fmt.Println("Installing Java dependencies...")
-		cmd = exec.Command("mvn", "dependency:resolve")
+		s.logger.Info("Installing Java dependencies")
+		args = []string{"mvn", "dependency:resolve"}
 	default:
 		return fmt.Errorf("unsupported project type: %s", projectType)
 	}
 
-	if cmd != nil {
-		cmd.Dir = s.projectPath
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to install dependencies: %w", err)
-		}
+	if err := s.run(ctx, stepInstall, nil, args[0], args[0], args[1:]...); err != nil {
+		return fmt.Errorf("failed to install dependencies: %w", err)
 	}
 
 	return nil
 }
 
 // installPythonDependencies creates a virtual environment and installs dependencies
-func (s *Synthesizer) installPythonDependencies() error {
+func (s *Synthesizer) installPythonDependencies(ctx context.Context) error {
 	venvPath := filepath.Join(s.projectPath, ".venv")
 
 	// Try python3 first, then python
@@ -114,26 +150,18 @@ func (s *Synthesizer) installPythonDependencies() error {
 
 	// Check if venv already exists
 	if _, err := os.Stat(venvPath); os.IsNotExist(err) {
-		fmt.Println("Creating Python virtual environment...")
+		s.logger.Info("Creating Python virtual environment")
 
-		cmd := exec.Command(pythonCmd, "-m", "venv", ".venv")
-		cmd.Dir = s.projectPath
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+		if err := s.run(ctx, stepInstall, nil, "python", pythonCmd, "-m", "venv", ".venv"); err != nil {
 			return fmt.Errorf("failed to create virtual environment: %w", err)
 		}
 	}
 
-	fmt.Println("Installing Python dependencies in virtual environment...")
+	s.logger.Info("Installing Python dependencies in virtual environment")
 
 	// Install dependencies using the venv pip
 	pipPath := filepath.Join(venvPath, "bin", "pip")
-	cmd := exec.Command(pipPath, "install", "-r", "requirements.txt")
-	cmd.Dir = s.projectPath
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := s.run(ctx, stepInstall, nil, "pip", pipPath, "install", "-r", "requirements.txt"); err != nil {
 		return fmt.Errorf("failed to install dependencies: %w", err)
 	}
 
@@ -227,7 +255,7 @@ func (s *Synthesizer) checkPythonCompatibility(pythonCmd string) error {
 	}
 
 	installedVersion := fmt.Sprintf("%d.%d.%d", major, minor, patch)
-	fmt.Printf("Detected Python version: %s\n", installedVersion)
+	s.logger.Info("Detected Python version", "version", installedVersion)
 
 	// Get required Python version
 	reqMajor, reqMinor, err := s.getRequiredPythonVersion()
@@ -236,30 +264,81 @@ func (s *Synthesizer) checkPythonCompatibility(pythonCmd string) error {
 	}
 
 	requiredVersion := fmt.Sprintf("%d.%d", reqMajor, reqMinor)
-	fmt.Printf("Required Python version: >=%s\n", requiredVersion)
+	s.logger.Info("Required Python version", "version", ">="+requiredVersion)
 
 	// Check compatibility
 	if major < reqMajor || (major == reqMajor && minor < reqMinor) {
 		return fmt.Errorf("python version %s is incompatible with project requirements (>=%s)", installedVersion, requiredVersion)
 	}
 
-	fmt.Printf("Python version %s is compatible\n", installedVersion)
+	s.logger.Debug("Python version is compatible", "version", installedVersion)
 	return nil
 }
 
 // Synth synthesizes the CDK app and returns the CloudFormation templates
 func (s *Synthesizer) Synth() (*SynthResult, error) {
+	return s.SynthContext(context.Background())
+}
+
+// SynthContext is like Synth but stops the app when ctx is cancelled
+func (s *Synthesizer) SynthContext(ctx context.Context) (*SynthResult, error) {
+	start := time.Now()
+	result, err := s.synth(ctx)
+
+	e := event.Event{Type: event.SynthFinished, Duration: time.Since(start), Err: err}
+	if result != nil {
+		e.Stacks = result.Stacks
+	}
+	s.events.Emit(e)
+
+	return result, err
+}
+
+// synth runs the synthesis in the configured mode
+func (s *Synthesizer) synth(ctx context.Context) (*SynthResult, error) {
+	if err := s.mode.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.sandbox.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Read cdk.json to get the app command
 	cdkConfig, err := s.readCDKConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("CDK app command: %s\n", cdkConfig.App)
+	s.logger.Info("Read CDK app command", "app", cdkConfig.App)
+
+	if err := s.restoreContext(); err != nil {
+		return nil, err
+	}
+
+	appCmd, env, err := s.prepareApp(ctx, cdkConfig.App)
+	if err != nil {
+		return nil, err
+	}
 
 	// Run the CDK app to generate CloudFormation templates
 	// The app command outputs to cdk.out by default
-	if err := s.runCDKSynth(cdkConfig.App); err != nil {
+	switch s.mode {
+	case SynthModeNative:
+		err = s.runNativeSynth(ctx, cdkConfig, appCmd, env)
+	case SynthModeAuto:
+		err = s.synthWithLookups(ctx, func() error {
+			return s.runNativeSynth(ctx, cdkConfig, appCmd, env)
+		})
+	default:
+		err = s.synthWithLookups(ctx, func() error {
+			return s.runCDKSynth(ctx, appCmd, env)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.persistContext(); err != nil {
 		return nil, err
 	}
 
@@ -269,6 +348,11 @@ func (s *Synthesizer) Synth() (*SynthResult, error) {
 		return nil, err
 	}
 
+	// Assets copied from a clone without its LFS objects must not be published
+	if err := s.checkLFSAssets(); err != nil {
+		return nil, err
+	}
+
 	return &SynthResult{
 		TemplateDir: s.outputDir,
 		Stacks:      stacks,
@@ -291,19 +375,17 @@ func (s *Synthesizer) readCDKConfig() (*CDKConfig, error) {
 	return &config, nil
 }
 
-// runCDKSynth runs the CDK synthesis process
-func (s *Synthesizer) runCDKSynth(appCmd string) error {
-	fmt.Println("Synthesizing CDK app...")
-
+// prepareApp compiles TypeScript apps when needed and returns the app command and
+// the variables to add to the sandbox environment to run it with
+func (s *Synthesizer) prepareApp(ctx context.Context, appCmd string) (string, []string, error) {
 	// Parse the app command
 	parts := strings.Fields(appCmd)
 	if len(parts) == 0 {
-		return fmt.Errorf("empty app command in cdk.json")
+		return "", nil, fmt.Errorf("empty app command in cdk.json")
 	}
 
 	// Set CDK_OUTDIR environment variable
-	env := os.Environ()
-	env = append(env, fmt.Sprintf("CDK_OUTDIR=%s", s.outputDir))
+	env := []string{fmt.Sprintf("CDK_OUTDIR=%s", s.outputDir)}
 
 	projectType, _ := s.DetectProjectType()
 
@@ -311,13 +393,9 @@ func (s *Synthesizer) runCDKSynth(appCmd string) error {
 	if projectType == "typescript" && !strings.Contains(appCmd, "ts-node") {
 		// Try to compile TypeScript first
 		if _, err := os.Stat(filepath.Join(s.projectPath, "tsconfig.json")); err == nil {
-			fmt.Println("Compiling TypeScript...")
-			compileCmd := exec.Command("npx", "tsc")
-			compileCmd.Dir = s.projectPath
-			compileCmd.Stdout = os.Stdout
-			compileCmd.Stderr = os.Stderr
+			s.logger.Info("Compiling TypeScript")
 			// Ignore compile errors as the project might use ts-node
-			_ = compileCmd.Run()
+			_ = s.run(ctx, stepSynth, env, "tsc", "npx", "tsc")
 		}
 	}
 
@@ -336,20 +414,123 @@ func (s *Synthesizer) runCDKSynth(appCmd string) error {
 		env = append(env, fmt.Sprintf("VIRTUAL_ENV=%s", filepath.Join(s.projectPath, ".venv")))
 	}
 
-	// Run cdk synth using npx cdk
-	cmd := exec.Command("npx", "cdk", "synth", "--app", appCmd, "--output", s.outputDir)
-	cmd.Dir = s.projectPath
-	cmd.Env = env
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	return appCmd, env, nil
+}
+
+// runLogged runs a command, logging its output line by line tagged with the source
+func (s *Synthesizer) runLogged(cmd *exec.Cmd, source string) error {
+	stdout := logging.NewLineWriter(s.logger, slog.LevelInfo, source)
+	stderr := logging.NewLineWriter(s.logger, slog.LevelInfo, source)
+	defer stdout.Close()
+	defer stderr.Close()
 
-	if err := cmd.Run(); err != nil {
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// run runs a command of a step in the sandbox, logging its output, bounded by the
+// sandbox timeout
+func (s *Synthesizer) run(ctx context.Context, st step, env []string, source, name string, args ...string) error {
+	parent := ctx
+	if s.sandbox.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.sandbox.Timeout)
+		defer cancel()
+	}
+
+	cmd, release, err := s.command(ctx, st, env, name, args...)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = s.runLogged(cmd, source)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		return fmt.Errorf("%s exceeded the sandbox timeout of %s", source, s.sandbox.Timeout)
+	}
+	// The kernel kills a command at its CPU limit, as measured by its own accounting
+	if err != nil && s.sandbox.CPUTime > 0 && cmd.ProcessState != nil && cmd.ProcessState.ExitCode() == -1 &&
+		cmd.ProcessState.UserTime()+cmd.ProcessState.SystemTime() >= s.sandbox.CPUTime*9/10 {
+		return fmt.Errorf("%s exceeded the sandbox CPU time of %s: %w", source, s.sandbox.CPUTime, err)
+	}
+	return err
+}
+
+// runCommand runs a synthesis command in the sandbox, bounded by the synth timeout
+func (s *Synthesizer) runCommand(ctx context.Context, st step, env []string, source, name string, args ...string) error {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	if err := s.run(ctx, st, env, source, name, args...); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("CDK synthesis timed out after %s", s.timeout)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("CDK synthesis cancelled: %w", ctx.Err())
+		}
 		return fmt.Errorf("CDK synthesis failed: %w", err)
 	}
 
 	return nil
 }
 
+// runCDKSynth runs the CDK synthesis process through the CDK CLI. The CLI runs the
+// app, so it gets no credentials and performs no context lookups.
+func (s *Synthesizer) runCDKSynth(ctx context.Context, appCmd string, env []string) error {
+	s.logger.Info("Synthesizing CDK app with the CDK CLI")
+
+	// Run cdk synth using npx cdk
+	args := []string{"cdk", "synth", "--app", appCmd, "--output", s.outputDir, "--no-lookups"}
+	for _, key := range sortedKeys(s.context) {
+		args = append(args, "--context", fmt.Sprintf("%s=%s", key, s.context[key]))
+	}
+	return s.runCommand(ctx, stepSynth, env, "cdk", "npx", args...)
+}
+
+// synthWithLookups runs synthesize and, when the assembly it produced lacks context
+// lookups and sandbox lookups are enabled, performs them and synthesizes again
+func (s *Synthesizer) synthWithLookups(ctx context.Context, synthesize func() error) error {
+	err := synthesize()
+	if err == nil {
+		return nil
+	}
+	missing := s.missingLookups()
+	if len(missing) == 0 {
+		return err
+	}
+	if !s.sandbox.Lookups {
+		return fmt.Errorf("%w; enable sandbox lookups to let the CDK CLI perform them", &MissingContextError{Keys: missing})
+	}
+
+	s.logger.Warn("Performing context lookups with the CDK CLI", "missing", missing)
+	if err := s.runCDKLookups(ctx); err != nil {
+		return err
+	}
+	return synthesize()
+}
+
+// runCDKLookups performs the context lookups of the assembly in the output directory
+// with the CDK CLI, which saves them to cdk.context.json. The CLI is given the
+// assembly instead of the app, so the credentials of the lookup step never reach code
+// from the repository.
+func (s *Synthesizer) runCDKLookups(ctx context.Context) error {
+	out, err := os.MkdirTemp(s.projectPath, ".cdk-lookups-")
+	if err != nil {
+		return fmt.Errorf("failed to create lookup output directory: %w", err)
+	}
+	defer os.RemoveAll(out)
+
+	args := []string{"cdk", "synth", "--app", s.outputDir, "--output", out, "--quiet"}
+	if err := s.runCommand(ctx, stepLookup, nil, "cdk", "npx", args...); err != nil {
+		return fmt.Errorf("context lookups failed: %w", err)
+	}
+	return nil
+}
+
 // findGeneratedStacks finds all generated CloudFormation stack templates
 func (s *Synthesizer) findGeneratedStacks() ([]string, error) {
 	var stacks []string
@@ -374,6 +555,18 @@ func (s *Synthesizer) findGeneratedStacks() ([]string, error) {
 	return stacks, nil
 }
 
+// WriteTemplate puts a template into the cloud assembly in place of the synthesized one
+func (s *Synthesizer) WriteTemplate(stackName, templateBody string) error {
+	if err := os.MkdirAll(s.outputDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	templatePath := filepath.Join(s.outputDir, stackName+".template.json")
+	if err := os.WriteFile(templatePath, []byte(templateBody), 0o644); err != nil {
+		return fmt.Errorf("failed to write template for stack %s: %w", stackName, err)
+	}
+	return nil
+}
+
 // GetTemplateBody returns the CloudFormation template body for a stack
 func (s *Synthesizer) GetTemplateBody(stackName string) (string, error) {
 	templatePath := filepath.Join(s.outputDir, stackName+".template.json")
@@ -1,5 +1,10 @@
 package cdk
 
+import (
+	"fmt"
+	"time"
+)
+
 // CDKConfig represents the cdk.json configuration
 type CDKConfig struct {
 	App     string                 `json:"app"`
@@ -18,6 +23,15 @@ type DeployResult struct {
 	StackID   string
 	Status    string
 	Outputs   []StackOutput
+	// ChangeSet is set when the deploy was reviewed as a change set
+	ChangeSet *ChangeSetSummary
+}
+
+// CallerIdentity is the AWS identity operations run as
+type CallerIdentity struct {
+	Account string `json:"account"`
+	ARN     string `json:"arn"`
+	UserID  string `json:"userId"`
 }
 
 // SynthResult contains the result of synthesis
@@ -33,6 +47,16 @@ type DriftResult struct {
 	DriftedResources []DriftedResource
 }
 
+// HasReportableDrift reports whether any drifted resource is neither suppressed nor part of the baseline
+func (r *DriftResult) HasReportableDrift() bool {
+	for _, dr := range r.DriftedResources {
+		if dr.Reportable() {
+			return true
+		}
+	}
+	return false
+}
+
 // DriftedResource represents a resource that has drifted
 type DriftedResource struct {
 	LogicalID     string
@@ -40,6 +64,17 @@ type DriftedResource struct {
 	ResourceType  string
 	DriftStatus   string
 	PropertyDiffs []PropertyDiff
+
+	// Suppressed is set when an ignore rule covers the whole resource or all of its diffs
+	Suppressed        bool
+	SuppressionReason string
+	// InBaseline is set when the drift was already recorded in the drift baseline
+	InBaseline bool
+}
+
+// Reportable reports whether the resource drift is neither suppressed nor part of the baseline
+func (r DriftedResource) Reportable() bool {
+	return !r.Suppressed && !r.InBaseline
 }
 
 // PropertyDiff represents a property difference in a drifted resource
@@ -48,4 +83,141 @@ type PropertyDiff struct {
 	ExpectedValue  string
 	ActualValue    string
 	DifferenceType string
+
+	// Suppressed is set when an ignore rule matches the property
+	Suppressed        bool
+	SuppressionReason string
+	// InBaseline is set when the same difference was recorded in the drift baseline
+	InBaseline bool
+}
+
+// Reportable reports whether the difference is neither suppressed nor part of the baseline
+func (p PropertyDiff) Reportable() bool {
+	return !p.Suppressed && !p.InBaseline
+}
+
+// ChangeSetSummary describes a CloudFormation change set and its resource changes
+type ChangeSetSummary struct {
+	StackName       string
+	ChangeSetID     string
+	ChangeSetName   string
+	Status          string
+	StatusReason    string
+	ExecutionStatus string
+	Changes         []ResourceChange
+}
+
+// HasChanges reports whether the change set would modify the stack
+func (s *ChangeSetSummary) HasChanges() bool {
+	return len(s.Changes) > 0
+}
+
+// IsDestructive reports whether the change set removes or may replace resources
+func (s *ChangeSetSummary) IsDestructive() bool {
+	for _, c := range s.Changes {
+		if c.Action == "Remove" || c.Replacement == "True" || c.Replacement == "Conditional" {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceChange represents a single resource change within a change set
+type ResourceChange struct {
+	Action       string
+	LogicalID    string
+	PhysicalID   string
+	ResourceType string
+	Replacement  string
+}
+
+// Timeouts bounds long-running operations; zero values use the defaults
+type Timeouts struct {
+	// Synth bounds running the CDK app
+	Synth time.Duration `yaml:"synth,omitempty"`
+	// Stack bounds waiting for a single stack operation (default 30m)
+	Stack time.Duration `yaml:"stack,omitempty"`
+	// Drift bounds waiting for drift detection of a single stack (default 10m)
+	Drift time.Duration `yaml:"drift,omitempty"`
+}
+
+// ApprovalPolicy decides when a deploy must be confirmed before it is executed
+type ApprovalPolicy string
+
+const (
+	// ApprovalNever deploys without a change set review
+	ApprovalNever ApprovalPolicy = "never"
+	// ApprovalAlways requires confirmation of every change set
+	ApprovalAlways ApprovalPolicy = "always"
+	// ApprovalDestructive requires confirmation when resources are removed or replaced
+	ApprovalDestructive ApprovalPolicy = "destructive"
+	// ApprovalPreview deploys through a change set that is shown but not confirmed
+	ApprovalPreview ApprovalPolicy = "preview"
+)
+
+// Validate checks that the policy is known
+func (p ApprovalPolicy) Validate() error {
+	switch p {
+	case "", ApprovalNever, ApprovalAlways, ApprovalDestructive, ApprovalPreview:
+		return nil
+	default:
+		return fmt.Errorf("invalid approval policy %q (use never, always, destructive or preview)", p)
+	}
+}
+
+// RecoveryOptions configures how the deployer handles stacks left in failed states
+type RecoveryOptions struct {
+	// ContinueRollback continues rollbacks stuck in UPDATE_ROLLBACK_FAILED without asking
+	ContinueRollback bool
+	// ResourcesToSkip are logical IDs skipped when continuing a failed rollback
+	ResourcesToSkip []string
+	// RecreateFailedStacks deletes and recreates stacks left in ROLLBACK_COMPLETE by a
+	// failed create or in REVIEW_IN_PROGRESS by a create change set never executed
+	RecreateFailedStacks bool
+	// CancelOnInterrupt cancels an in-progress update when the context is cancelled
+	CancelOnInterrupt bool
+	// Prompter is asked when an automatic recovery is not enabled; nil means never ask
+	Prompter Prompter
+}
+
+// RemediationStrategy selects how a drifted resource is brought back in line
+type RemediationStrategy string
+
+const (
+	// RemediationReapply re-applies the deployed template, forcing an update of the resource
+	RemediationReapply RemediationStrategy = "reapply"
+	// RemediationImport writes the actual values to an override file for review
+	RemediationImport RemediationStrategy = "import"
+	// RemediationPlan writes a human-readable remediation plan
+	RemediationPlan RemediationStrategy = "plan"
+	// RemediationSkip leaves the resource untouched
+	RemediationSkip RemediationStrategy = "skip"
+)
+
+// RemediationOptions configures drift remediation
+type RemediationOptions struct {
+	// Strategy applies to every drifted resource; when empty the prompter is asked per resource
+	Strategy RemediationStrategy
+	// OutputDir receives override files and remediation plans
+	OutputDir string
+	// Prompter is used for strategy selection and confirmation
+	Prompter Prompter
+}
+
+// RemediationResult contains the outcome of remediating a stack
+type RemediationResult struct {
+	StackName     string
+	Actions       []RemediationAction
+	ChangeSet     *ChangeSetSummary
+	Executed      bool
+	Status        string
+	OverridesFile string
+	PlanFile      string
+}
+
+// RemediationAction records the strategy chosen for a drifted resource
+type RemediationAction struct {
+	LogicalID    string
+	ResourceType string
+	Strategy     RemediationStrategy
 }
@@ -0,0 +1,512 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"cdk-deployer/pkg/cdk"
+)
+
+// FileName is the configuration file discovered in the working directory or repo root
+const FileName = "cdk-deployer.yaml"
+
+// Settings are the values that can be set at the top level of the configuration
+// file and overridden per environment
+type Settings struct {
+	// Repo is the URL of the git repository containing the CDK app
+	Repo string `yaml:"repo,omitempty"`
+	// Ref is the branch, tag or commit to deploy
+	Ref string `yaml:"ref,omitempty"`
+	// Source is a git URL, local directory or archive used instead of Repo, selected
+	// by its scheme: git+<url>, dir:<path> or archive:<path or URL>
+	Source string `yaml:"source,omitempty"`
+	// InPlace uses a local directory source as is instead of copying it
+	InPlace bool `yaml:"inPlace,omitempty"`
+	// NoSubmodules leaves the submodules of a git repository uninitialized
+	NoSubmodules bool `yaml:"noSubmodules,omitempty"`
+	// NoLFS leaves Git LFS pointer files in place instead of fetching their content
+	NoLFS bool `yaml:"noLFS,omitempty"`
+	// Subpath is the directory of the CDK app within the repository
+	Subpath string `yaml:"subpath,omitempty"`
+	// Include holds stack names or glob patterns, matched against stack names and
+	// display names, of the stacks to act on; empty selects every stack
+	Include []string `yaml:"include,omitempty"`
+	// Exclude holds stack names or glob patterns of stacks to leave out
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Context holds CDK context values passed to the app
+	Context map[string]string `yaml:"context,omitempty"`
+	// SynthMode is native, cli or auto
+	SynthMode cdk.SynthMode `yaml:"synthMode,omitempty"`
+	// ContextFile seeds cdk.context.json before synthesis
+	ContextFile string `yaml:"contextFile,omitempty"`
+	// ContextCache keeps cdk.context.json between runs
+	ContextCache string `yaml:"contextCache,omitempty"`
+	// Tags are applied to every stack
+	Tags map[string]string `yaml:"tags,omitempty"`
+	// Concurrency is the number of stacks deployed in parallel
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// Timeouts bound synthesis, stack operations and drift detection
+	Timeouts cdk.Timeouts `yaml:"timeouts,omitempty"`
+	// Approval is the approval policy: never, always, destructive or preview
+	Approval cdk.ApprovalPolicy `yaml:"approval,omitempty"`
+	// Stacks maps stack name glob patterns to deployment options
+	Stacks map[string]cdk.StackOptions `yaml:"stacks,omitempty"`
+	// Lock selects the backend of per-stack deployment locks
+	Lock LockSettings `yaml:"lock,omitempty"`
+	// HistoryFile is the JSON lines file runs are recorded to; "none" disables it
+	HistoryFile string `yaml:"historyFile,omitempty"`
+	// Archive is a directory or s3://bucket/prefix synthesized assemblies are archived to
+	Archive string `yaml:"archive,omitempty"`
+	// GitCache keeps clones of repositories between runs
+	GitCache GitCacheSettings `yaml:"gitCache,omitempty"`
+	// Verify refuses commits and tags not signed by a trusted key
+	Verify VerifySettings `yaml:"verify,omitempty"`
+	// Repos restricts the repositories that may be cloned
+	Repos RepoSettings `yaml:"repos,omitempty"`
+	// Sandbox restricts the installs and synthesis run from the repository
+	Sandbox SandboxSettings `yaml:"sandbox,omitempty"`
+}
+
+// SandboxSettings restrict the commands run from the repository: dependency installs,
+// the TypeScript compiler, the app and the CDK CLI
+type SandboxSettings struct {
+	// Env, Lookups, Isolation, Network, Seccomp, CPUTime and Timeout
+	cdk.Sandbox `yaml:",inline"`
+	// Memory limits the address space of each command to a size such as 4GB
+	Memory string `yaml:"memory,omitempty"`
+}
+
+// validate checks the values of the sandbox; whether they fit together is checked
+// once environment overlays and flags are applied
+func (b SandboxSettings) validate() error {
+	if _, err := ParseSize(b.Memory); err != nil {
+		return fmt.Errorf("memory: %w", err)
+	}
+	if err := b.Isolation.Validate(); err != nil {
+		return err
+	}
+	if err := b.Network.Validate(); err != nil {
+		return err
+	}
+	if b.CPUTime < 0 || b.Timeout < 0 {
+		return fmt.Errorf("cpuTime and timeout must not be negative")
+	}
+	return nil
+}
+
+// Resolve returns the sandbox with the memory size parsed
+func (b SandboxSettings) Resolve() (cdk.Sandbox, error) {
+	memory, err := ParseSize(b.Memory)
+	if err != nil {
+		return cdk.Sandbox{}, fmt.Errorf("invalid sandbox memory: %w", err)
+	}
+	sb := b.Sandbox
+	sb.Memory = memory
+	return sb, sb.Validate()
+}
+
+// Tighten applies the sandbox settings of a configuration file found in the repository
+// itself, which may only make the sandbox stricter: isolation where there was none, no
+// network, seccomp and lower limits. Its variables and lookups are ignored.
+func (b SandboxSettings) Tighten(repo SandboxSettings) SandboxSettings {
+	if b.Isolation == "" || b.Isolation == cdk.IsolationNone {
+		b.Isolation = repo.Isolation
+	}
+	if repo.Network == cdk.NetworkNone {
+		b.Network = repo.Network
+	}
+	b.Seccomp = b.Seccomp || repo.Seccomp
+	b.CPUTime = minLimit(b.CPUTime, repo.CPUTime)
+	b.Timeout = minLimit(b.Timeout, repo.Timeout)
+	if repoMem, err := ParseSize(repo.Memory); err == nil && repoMem > 0 {
+		if mem, err := ParseSize(b.Memory); err == nil && (mem == 0 || repoMem < mem) {
+			b.Memory = repo.Memory
+		}
+	}
+	return b
+}
+
+// minLimit returns the lower of two limits, where 0 is no limit
+func minLimit(a, b time.Duration) time.Duration {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// RepoSettings restrict the repositories and submodules that may be cloned
+type RepoSettings struct {
+	// Allow holds host, host/org or host/org/repo patterns such as github.com/acme;
+	// empty allows every host
+	Allow []string `yaml:"allow,omitempty"`
+	// AllowLocal permits file:// URLs and local paths
+	AllowLocal bool `yaml:"allowLocal,omitempty"`
+}
+
+// VerifySettings configure signature verification of the deployed commit or tag.
+// Verification is enabled when either file is set.
+type VerifySettings struct {
+	// GPGKeyring is an OpenPGP keyring, armored or binary, of the trusted GPG keys
+	GPGKeyring string `yaml:"gpgKeyring,omitempty"`
+	// AllowedSigners is an SSH allowed signers file of the trusted SSH keys
+	AllowedSigners string `yaml:"allowedSigners,omitempty"`
+}
+
+// Enabled reports whether signatures are verified
+func (v VerifySettings) Enabled() bool {
+	return v.GPGKeyring != "" || v.AllowedSigners != ""
+}
+
+// GitCacheSettings configure the clone cache
+type GitCacheSettings struct {
+	// Dir holds the cached repositories; empty disables the cache
+	Dir string `yaml:"dir,omitempty"`
+	// MaxSize evicts the least recently used repositories once the cache grows beyond
+	// a size such as 10GB
+	MaxSize string `yaml:"maxSize,omitempty"`
+	// MaxAge evicts repositories that were not used for a duration such as 720h
+	MaxAge time.Duration `yaml:"maxAge,omitempty"`
+}
+
+// validate checks the size and age limits
+func (g GitCacheSettings) validate() error {
+	if _, err := ParseSize(g.MaxSize); err != nil {
+		return fmt.Errorf("maxSize: %w", err)
+	}
+	if g.MaxAge < 0 {
+		return fmt.Errorf("maxAge must not be negative")
+	}
+	return nil
+}
+
+// ParseSize parses a size in bytes with an optional K, M, G or T suffix (also KB,
+// KiB, ...), each 1024 times the previous one. An empty size is 0.
+func ParseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		multiplier = 1 << (10 * (strings.IndexByte("KMGT", s[i]) + 1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 500M or 10GB)", size)
+	}
+	return n * multiplier, nil
+}
+
+// LockSettings select where per-stack deployment locks are kept
+type LockSettings struct {
+	// Backend is none, file or dynamodb
+	Backend string `yaml:"backend,omitempty"`
+	// Dir holds the lock files of the file backend
+	Dir string `yaml:"dir,omitempty"`
+	// Table is the DynamoDB table of the dynamodb backend
+	Table string `yaml:"table,omitempty"`
+	// Endpoint overrides the DynamoDB endpoint, e.g. for a local stand-in
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// TTL and Wait set the lease of a lock and how long to wait for a held one
+	cdk.LockOptions `yaml:",inline"`
+}
+
+// validate checks the lock backend
+func (l LockSettings) validate() error {
+	switch l.Backend {
+	case "", "none", "file", "dynamodb":
+	default:
+		return fmt.Errorf("invalid backend %q (use none, file or dynamodb)", l.Backend)
+	}
+	if l.TTL < 0 || l.Wait < 0 {
+		return fmt.Errorf("ttl and wait must not be negative")
+	}
+	return nil
+}
+
+// Config is the cdk-deployer configuration file
+type Config struct {
+	Settings `yaml:",inline"`
+	// Environments holds overlays selected with -env
+	Environments map[string]Settings `yaml:"environments,omitempty"`
+}
+
+// Load reads and validates a configuration file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// Discover returns the path of the first configuration file found in dirs, or an
+// empty string if there is none
+func Discover(dirs ...string) string {
+	for _, dir := range dirs {
+		path := filepath.Join(dir, FileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// validate checks the top-level settings and every environment overlay
+func (c *Config) validate() error {
+	if err := c.Settings.validate(""); err != nil {
+		return err
+	}
+	for env, s := range c.Environments {
+		if err := s.validate("environments." + env + "."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the settings, prefixing errors with their location in the file
+func (s *Settings) validate(prefix string) error {
+	if s.Concurrency < 0 {
+		return fmt.Errorf("%sconcurrency must not be negative", prefix)
+	}
+	if s.Approval != "" {
+		if err := s.Approval.Validate(); err != nil {
+			return fmt.Errorf("%sapproval: %w", prefix, err)
+		}
+	}
+	if err := s.SynthMode.Validate(); err != nil {
+		return fmt.Errorf("%ssynthMode: %w", prefix, err)
+	}
+	for pattern, opts := range s.Stacks {
+		if err := opts.Validate(); err != nil {
+			return fmt.Errorf("%sstacks.%s: %w", prefix, pattern, err)
+		}
+	}
+	if err := s.Lock.validate(); err != nil {
+		return fmt.Errorf("%slock: %w", prefix, err)
+	}
+	if err := s.GitCache.validate(); err != nil {
+		return fmt.Errorf("%sgitCache.%w", prefix, err)
+	}
+	if err := s.Sandbox.validate(); err != nil {
+		return fmt.Errorf("%ssandbox: %w", prefix, err)
+	}
+	return nil
+}
+
+// HasEnvironment reports whether an environment overlay is defined
+func (c *Config) HasEnvironment(env string) bool {
+	_, ok := c.Environments[env]
+	return ok
+}
+
+// Resolve returns the effective settings for an environment: the top-level settings
+// overlaid with the environment's settings
+func (c *Config) Resolve(env string) Settings {
+	s := c.Settings
+	if overlay, ok := c.Environments[env]; ok {
+		s = s.Merge(overlay)
+	}
+	return s
+}
+
+// Merge returns s overlaid with the values set in other. Maps are merged key by key;
+// stack entries with the same pattern are merged field by field.
+func (s Settings) Merge(other Settings) Settings {
+	if other.Repo != "" {
+		s.Repo = other.Repo
+	}
+	if other.Ref != "" {
+		s.Ref = other.Ref
+	}
+	if other.Source != "" {
+		s.Source = other.Source
+	}
+	if other.InPlace {
+		s.InPlace = other.InPlace
+	}
+	if other.NoSubmodules {
+		s.NoSubmodules = other.NoSubmodules
+	}
+	if other.NoLFS {
+		s.NoLFS = other.NoLFS
+	}
+	if other.Subpath != "" {
+		s.Subpath = other.Subpath
+	}
+	if len(other.Include) > 0 {
+		s.Include = other.Include
+	}
+	if len(other.Exclude) > 0 {
+		s.Exclude = other.Exclude
+	}
+	s.Context = cdk.MergeMaps(s.Context, other.Context)
+	if other.SynthMode != "" {
+		s.SynthMode = other.SynthMode
+	}
+	if other.ContextFile != "" {
+		s.ContextFile = other.ContextFile
+	}
+	if other.ContextCache != "" {
+		s.ContextCache = other.ContextCache
+	}
+	s.Tags = cdk.MergeMaps(s.Tags, other.Tags)
+	if other.Concurrency != 0 {
+		s.Concurrency = other.Concurrency
+	}
+	if other.Timeouts.Synth != 0 {
+		s.Timeouts.Synth = other.Timeouts.Synth
+	}
+	if other.Timeouts.Stack != 0 {
+		s.Timeouts.Stack = other.Timeouts.Stack
+	}
+	if other.Timeouts.Drift != 0 {
+		s.Timeouts.Drift = other.Timeouts.Drift
+	}
+	if other.Approval != "" {
+		s.Approval = other.Approval
+	}
+	if len(other.Stacks) > 0 {
+		stacks := make(map[string]cdk.StackOptions, len(s.Stacks)+len(other.Stacks))
+		for pattern, opts := range s.Stacks {
+			stacks[pattern] = opts
+		}
+		for pattern, opts := range other.Stacks {
+			stacks[pattern] = stacks[pattern].Merge(opts)
+		}
+		s.Stacks = stacks
+	}
+	if other.Lock.Backend != "" {
+		s.Lock.Backend = other.Lock.Backend
+	}
+	if other.Lock.Dir != "" {
+		s.Lock.Dir = other.Lock.Dir
+	}
+	if other.Lock.Table != "" {
+		s.Lock.Table = other.Lock.Table
+	}
+	if other.Lock.Endpoint != "" {
+		s.Lock.Endpoint = other.Lock.Endpoint
+	}
+	if other.Lock.TTL != 0 {
+		s.Lock.TTL = other.Lock.TTL
+	}
+	if other.Lock.Wait != 0 {
+		s.Lock.Wait = other.Lock.Wait
+	}
+	if other.HistoryFile != "" {
+		s.HistoryFile = other.HistoryFile
+	}
+	if other.Archive != "" {
+		s.Archive = other.Archive
+	}
+	if other.GitCache.Dir != "" {
+		s.GitCache.Dir = other.GitCache.Dir
+	}
+	if other.GitCache.MaxSize != "" {
+		s.GitCache.MaxSize = other.GitCache.MaxSize
+	}
+	if other.GitCache.MaxAge != 0 {
+		s.GitCache.MaxAge = other.GitCache.MaxAge
+	}
+	if other.Verify.GPGKeyring != "" {
+		s.Verify.GPGKeyring = other.Verify.GPGKeyring
+	}
+	if other.Verify.AllowedSigners != "" {
+		s.Verify.AllowedSigners = other.Verify.AllowedSigners
+	}
+	if len(other.Repos.Allow) > 0 {
+		s.Repos.Allow = other.Repos.Allow
+	}
+	if other.Repos.AllowLocal {
+		s.Repos.AllowLocal = other.Repos.AllowLocal
+	}
+	if len(other.Sandbox.Env) > 0 {
+		s.Sandbox.Env = other.Sandbox.Env
+	}
+	if other.Sandbox.Lookups {
+		s.Sandbox.Lookups = other.Sandbox.Lookups
+	}
+	if other.Sandbox.Isolation != "" {
+		s.Sandbox.Isolation = other.Sandbox.Isolation
+	}
+	if other.Sandbox.Network != "" {
+		s.Sandbox.Network = other.Sandbox.Network
+	}
+	if other.Sandbox.Seccomp {
+		s.Sandbox.Seccomp = other.Sandbox.Seccomp
+	}
+	if other.Sandbox.CPUTime != 0 {
+		s.Sandbox.CPUTime = other.Sandbox.CPUTime
+	}
+	if other.Sandbox.Memory != "" {
+		s.Sandbox.Memory = other.Sandbox.Memory
+	}
+	if other.Sandbox.Timeout != 0 {
+		s.Sandbox.Timeout = other.Sandbox.Timeout
+	}
+	return s
+}
+
+// StackOptions resolves the deployment options of a stack. The global tags are
+// applied first, then every matching stack entry with wildcard patterns applied
+// before exact names so the most specific entry wins. Patterns of equal specificity
+// are applied in lexical order, so the result never depends on map iteration.
+func (s Settings) StackOptions(stackName string) cdk.StackOptions {
+	opts := cdk.StackOptions{Tags: s.Tags}
+
+	patterns := make([]string, 0, len(s.Stacks))
+	for pattern := range s.Stacks {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if specificity(patterns[i]) != specificity(patterns[j]) {
+			return specificity(patterns[i]) < specificity(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	for _, pattern := range patterns {
+		if cdk.MatchGlob(pattern, stackName) {
+			opts = opts.Merge(s.Stacks[pattern])
+		}
+	}
+	return opts
+}
+
+// YAML renders the settings as they would appear in a configuration file
+func (s Settings) YAML() (string, error) {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("failed to render config: %w", err)
+	}
+	return string(data), nil
+}
+
+// specificity orders patterns from least to most specific
+func specificity(pattern string) int {
+	if !strings.ContainsAny(pattern, "*?") {
+		return 1 << 20
+	}
+	return len(pattern) - strings.Count(pattern, "*")*2
+}
@@ -0,0 +1,122 @@
+package event
+
+import "time"
+
+// Type identifies the kind of an event
+type Type string
+
+const (
+	// CloneProgress carries a line of git clone progress
+	CloneProgress Type = "clone.progress"
+	// SourceVerified is sent when the signature of a checked out commit or tag was
+	// verified, with Signer set
+	SourceVerified Type = "source.verified"
+	// InstallStarted is sent when dependency installation starts
+	InstallStarted Type = "install.started"
+	// InstallFinished is sent when dependency installation ends, with Err set on failure
+	InstallFinished Type = "install.finished"
+	// SynthFinished is sent when synthesis ends, with the synthesized stacks or Err
+	SynthFinished Type = "synth.finished"
+	// StackStarted is sent when a stack operation is submitted to CloudFormation
+	StackStarted Type = "stack.started"
+	// ResourceEvent carries a CloudFormation stack event of a stack operation
+	ResourceEvent Type = "stack.resource"
+	// StackCompleted is sent when a stack was deployed successfully
+	StackCompleted Type = "stack.completed"
+	// StackFailed is sent when a stack deployment failed, with Err set
+	StackFailed Type = "stack.failed"
+	// DriftResult is sent when drift detection of a stack finished
+	DriftResult Type = "drift.result"
+)
+
+// Event is a progress notification. Only the fields relevant to the type are set.
+type Event struct {
+	Type Type
+	Time time.Time
+	// Stack is the stack the event belongs to
+	Stack string
+	// Source is the tool behind install events, e.g. npm or pip
+	Source string
+	// Operation is the stack operation, e.g. create, update or execute-change-set
+	Operation string
+	// Status is the stack or drift status
+	Status string
+	// Message is free text, e.g. a progress line
+	Message string
+	// Stacks are the synthesized stacks of a SynthFinished event
+	Stacks []string
+	// Duration is how long the finished step took
+	Duration time.Duration
+	// Resource is the resource of a ResourceEvent
+	Resource *Resource
+	// Drift is the result of a DriftResult event
+	Drift *Drift
+	// Signer is the trusted signer of a SourceVerified event
+	Signer *Signer
+	// Err is the failure of a failed step
+	Err error
+}
+
+// Resource is a CloudFormation stack event of a single resource
+type Resource struct {
+	LogicalID    string
+	PhysicalID   string
+	ResourceType string
+	Status       string
+	StatusReason string
+	Timestamp    time.Time
+}
+
+// Drift summarizes the drift detection result of a stack
+type Drift struct {
+	// Status is the stack drift status, e.g. IN_SYNC or DRIFTED
+	Status string
+	// DriftedResources are the logical IDs of the drifted resources
+	DriftedResources []string
+}
+
+// Signer identifies who signed a deployed commit or tag
+type Signer struct {
+	// Method is gpg or ssh
+	Method string `json:"method"`
+	// Identity is the user ID of the GPG key or the principals of the SSH key
+	Identity string `json:"identity"`
+	// Key is the GPG key fingerprint or the SHA256 fingerprint of the SSH key
+	Key string `json:"key"`
+	// Object is the signed object: commit, or tag <name>
+	Object string `json:"object"`
+	// Hash is the SHA of the signed object
+	Hash string `json:"hash"`
+}
+
+// Handler receives events. Handlers are called synchronously from the goroutine doing
+// the work, possibly from several goroutines at once during concurrent deploys.
+type Handler func(Event)
+
+// Emit delivers an event, stamping its time. A nil handler drops the event.
+func (h Handler) Emit(e Event) {
+	if h == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h(e)
+}
+
+// Channel returns a handler sending events to a channel. Sends block, so the channel
+// must be drained while the operation runs.
+func Channel(ch chan<- Event) Handler {
+	return func(e Event) {
+		ch <- e
+	}
+}
+
+// Multi returns a handler delivering events to every non-nil handler in order
+func Multi(handlers ...Handler) Handler {
+	return func(e Event) {
+		for _, h := range handlers {
+			h.Emit(e)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package extract writes the entries of tarballs and zip archives below a directory,
+// refusing every entry that would end up outside it
+package extract
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is a directory archive entries are extracted into. Entries must have local
+// names, symlinks must point within the directory, and no entry is written below a
+// symlink, so chains of symlinks cannot lead outside it either.
+type Dir struct {
+	root string
+}
+
+// New creates dir if needed and returns it for extraction
+func New(dir string) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+	return &Dir{root: root}, nil
+}
+
+// Mkdir creates a directory entry
+func (d *Dir) Mkdir(name string) error {
+	target, name, err := d.target(name)
+	if err != nil || target == "" {
+		return err
+	}
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("archive has a directory %q replacing a symlink", name)
+	}
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	return nil
+}
+
+// Symlink creates a symlink entry pointing at linkname, which must stay within the
+// directory
+func (d *Dir) Symlink(name, linkname string) error {
+	target, name, err := d.target(name)
+	if err != nil || target == "" {
+		return err
+	}
+	link := path.Join(path.Dir(name), filepath.ToSlash(linkname))
+	if path.IsAbs(filepath.ToSlash(linkname)) || !filepath.IsLocal(filepath.FromSlash(link)) {
+		return fmt.Errorf("archive has a symlink %q leaving the directory", name)
+	}
+	if err := os.Symlink(filepath.FromSlash(linkname), target); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	return nil
+}
+
+// File creates a regular file entry with the content of r. An existing file or
+// symlink of the same name is never overwritten.
+func (d *Dir) File(name string, mode os.FileMode, r io.Reader) error {
+	target, name, err := d.target(name)
+	if err != nil || target == "" {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0o600)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close checks that every extracted symlink resolves to an existing path within the
+// directory, which the text of a single link cannot show
+func (d *Dir) Close() error {
+	return filepath.WalkDir(d.root, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		resolved, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return fmt.Errorf("archive has a dangling symlink %s: %w", p, err)
+		}
+		if rel, err := filepath.Rel(d.root, resolved); err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("archive has a symlink %s leaving the directory", p)
+		}
+		return nil
+	})
+}
+
+// target returns the path of an entry after checking that its name is local and that
+// none of its parents is a symlink, creating the parents as needed. The root entry
+// gives an empty path.
+func (d *Dir) target(name string) (string, string, error) {
+	name = strings.TrimSuffix(filepath.ToSlash(name), "/")
+	if name == "" || name == "." {
+		return "", name, nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", name, fmt.Errorf("archive has an invalid entry %q", name)
+	}
+
+	parent := d.root
+	elems := strings.Split(path.Clean(name), "/")
+	for _, elem := range elems[:len(elems)-1] {
+		parent = filepath.Join(parent, elem)
+		info, err := os.Lstat(parent)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if err := os.Mkdir(parent, 0o755); err != nil {
+				return "", name, fmt.Errorf("failed to extract %s: %w", name, err)
+			}
+		case err != nil:
+			return "", name, fmt.Errorf("failed to extract %s: %w", name, err)
+		case info.Mode()&os.ModeSymlink != 0:
+			return "", name, fmt.Errorf("archive has an entry %q below a symlink", name)
+		case !info.IsDir():
+			return "", name, fmt.Errorf("archive has an entry %q below a file", name)
+		}
+	}
+	return filepath.Join(parent, elems[len(elems)-1]), name, nil
+}
+
+// Tar extracts an uncompressed tar stream into dir. Directories, regular files and
+// symlinks are supported; PAX headers are skipped and other entries are refused.
+func Tar(r io.Reader, dir string) error {
+	d, err := New(dir)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return d.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = d.Mkdir(hdr.Name)
+		case tar.TypeSymlink:
+			err = d.Symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeReg:
+			err = d.File(hdr.Name, os.FileMode(hdr.Mode).Perm(), tr)
+		case tar.TypeXGlobalHeader, tar.TypeXHeader:
+			// PAX headers, e.g. the commit ID of git archive, carry no files
+		default:
+			err = fmt.Errorf("archive has an unsupported entry %q", hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
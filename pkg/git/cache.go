@@ -0,0 +1,484 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"cdk-deployer/pkg/lock"
+	"cdk-deployer/pkg/logging"
+)
+
+// cacheLockTTL is the lease of the lock on a cache entry; it is renewed while a
+// fetch or checkout runs
+const cacheLockTTL = 2 * time.Minute
+
+// cacheRefSpecs mirror the branches and tags of the remote into a cache entry
+var cacheRefSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// Cache keeps bare clones of repositories in a directory shared by runs, keyed by
+// normalized repository URL. A run fetches only the objects added since the last
+// one and checks out a working copy holding hard links to the objects of the entry.
+type Cache struct {
+	dir      string
+	maxSize  int64
+	maxAge   time.Duration
+	lockWait time.Duration
+	locker   *lock.FileLocker
+}
+
+// CacheOption configures a clone cache
+type CacheOption func(*Cache)
+
+// WithMaxSize evicts the least recently used entries once the cache grows beyond
+// size bytes
+func WithMaxSize(size int64) CacheOption {
+	return func(c *Cache) {
+		c.maxSize = size
+	}
+}
+
+// WithMaxAge evicts entries that were not used for age
+func WithMaxAge(age time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.maxAge = age
+	}
+}
+
+// WithLockWait sets how long to wait for an entry used by another process (default 10m)
+func WithLockWait(wait time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.lockWait = wait
+	}
+}
+
+// NewCache opens the clone cache in dir, creating it if needed
+func NewCache(dir string, opts ...CacheOption) (*Cache, error) {
+	c := &Cache{dir: dir, lockWait: 10 * time.Minute}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := os.MkdirAll(c.reposDir(), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create clone cache: %w", err)
+	}
+	locker, err := lock.NewFileLocker(filepath.Join(dir, "locks"))
+	if err != nil {
+		return nil, err
+	}
+	c.locker = locker
+	return c, nil
+}
+
+// WithCache clones through a clone cache instead of from scratch
+func WithCache(c *Cache) CloneOption {
+	return func(o *cloneOptions) {
+		o.cache = c
+	}
+}
+
+// reposDir holds the bare repositories of the cache
+func (c *Cache) reposDir() string {
+	return filepath.Join(c.dir, "repos")
+}
+
+// clone updates the cache entry of a repository and checks out ref from it
+func (c *Cache) clone(ctx context.Context, repoURL, ref, clonePath string, auth transport.AuthMethod, progress io.Writer, logger *slog.Logger) error {
+	key := CacheKey(repoURL)
+	release, err := c.lock(ctx, key, "fetch", logger)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	bare := filepath.Join(c.reposDir(), key+".git")
+	repo, err := c.update(ctx, bare, repoURL, auth, progress, logger)
+	if err != nil {
+		return err
+	}
+
+	revision := ref
+	if revision == "" {
+		revision = string(plumbing.HEAD)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return fmt.Errorf("failed to resolve ref %s: %w", revision, err)
+	}
+	// A tag given as ref is kept in the working copy, so its signature can be verified
+	var tag *plumbing.Reference
+	if ref != "" {
+		tag, _ = repo.Reference(plumbing.NewTagReferenceName(ref), false)
+	}
+	if err := checkoutShared(bare, clonePath, repoURL, *hash, tag); err != nil {
+		return err
+	}
+
+	// The modification time of an entry records when it was last used
+	now := time.Now()
+	if err := os.Chtimes(bare, now, now); err != nil {
+		logger.Warn("Failed to record the use of a clone cache entry", "path", bare, "error", err)
+	}
+	return nil
+}
+
+// update opens or creates the bare repository of a cache entry and fetches the
+// branches and tags of the remote into it
+func (c *Cache) update(ctx context.Context, bare, repoURL string, auth transport.AuthMethod, progress io.Writer, logger *slog.Logger) (*git.Repository, error) {
+	repo, err := git.PlainOpen(bare)
+	created := false
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		logger.Info("Creating clone cache entry", "path", bare)
+		if repo, err = initCacheEntry(bare, repoURL); err != nil {
+			os.RemoveAll(bare)
+			return nil, err
+		}
+		created = true
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open clone cache entry %s: %w", bare, err)
+	}
+
+	if err := fetchCacheEntry(ctx, repo, auth, progress); err != nil {
+		if created {
+			os.RemoveAll(bare)
+		}
+		return nil, err
+	}
+	logger.Debug("Clone cache entry up to date", "path", bare)
+	return repo, nil
+}
+
+// initCacheEntry creates the bare repository of a cache entry
+func initCacheEntry(bare, repoURL string) (*git.Repository, error) {
+	repo, err := git.PlainInit(bare, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clone cache entry: %w", err)
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{repoURL},
+		Fetch: cacheRefSpecs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clone cache entry: %w", err)
+	}
+	return repo, nil
+}
+
+// fetchCacheEntry fetches new objects into a cache entry and points its HEAD at the
+// default branch of the remote
+func fetchCacheEntry(ctx context.Context, repo *git.Repository, auth transport.AuthMethod, progress io.Writer) error {
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return fmt.Errorf("failed to open clone cache entry: %w", err)
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to list remote refs: %w", err)
+	}
+
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: cacheRefSpecs,
+		Auth:     auth,
+		Progress: progress,
+		Tags:     git.NoTags,
+		Force:    true,
+		Prune:    true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch repository: %w", err)
+	}
+
+	for _, r := range refs {
+		if r.Name() != plumbing.HEAD {
+			continue
+		}
+		if err := repo.Storer.SetReference(r); err != nil {
+			return fmt.Errorf("failed to update clone cache entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkoutShared creates a working copy at clonePath holding the objects of the bare
+// repository and checks out hash. The object files are hard-linked where possible,
+// which costs no space, and copied otherwise; the working copy does not depend on the
+// cache entry afterwards, so evicting the entry cannot break a run still using it. The
+// tag reference of the requested ref, if any, is copied to the working copy.
+func checkoutShared(bare, clonePath, repoURL string, hash plumbing.Hash, tag *plumbing.Reference) error {
+	if _, err := git.PlainInit(clonePath, false); err != nil {
+		return fmt.Errorf("failed to create working copy: %w", err)
+	}
+	err := linkObjects(filepath.Join(bare, "objects"), filepath.Join(clonePath, git.GitDirName, "objects"))
+	if err != nil {
+		return fmt.Errorf("failed to create working copy: %w", err)
+	}
+
+	repo, err := openWorkingCopy(clonePath)
+	if err != nil {
+		return err
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{repoURL}})
+	if err != nil {
+		return fmt.Errorf("failed to create working copy: %w", err)
+	}
+	if tag != nil {
+		if err := repo.Storer.SetReference(tag); err != nil {
+			return fmt.Errorf("failed to create working copy: %w", err)
+		}
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to open worktree: %w", err)
+	}
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		return fmt.Errorf("failed to check out %s: %w", hash, err)
+	}
+	return nil
+}
+
+// linkObjects hard-links the object files below src into dst, copying those that
+// cannot be linked, e.g. because dst is on another file system. Pack files and loose
+// objects are never modified in place, so the links stay valid while the cache
+// entry is fetched into.
+func linkObjects(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if err := os.Link(path, target); err == nil || errors.Is(err, fs.ErrExist) {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies a regular file, keeping its permissions
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// lock takes the lock of a cache entry, waiting while another process uses it, and
+// keeps renewing it until the returned function is called
+func (c *Cache) lock(ctx context.Context, key, operation string, logger *slog.Logger) (func(), error) {
+	lockKey := lock.Key{Account: "git-cache", Stack: key}
+	deadline := time.Now().Add(c.lockWait)
+	waiting := false
+
+	var info lock.Info
+	for {
+		info = lock.NewInfo(lockKey, operation, cacheLockTTL)
+		err := c.locker.TryLock(ctx, info)
+		if err == nil {
+			break
+		}
+		var held *lock.HeldError
+		if !errors.As(err, &held) {
+			return nil, fmt.Errorf("failed to lock clone cache entry %s: %w", key, err)
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("clone cache entry %s is in use by %s (pid %d)", key, held.Info.Holder, held.Info.PID)
+		}
+		if !waiting {
+			logger.Info("Waiting for clone cache entry", "entry", key, "holder", held.Info.Holder)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(time.Second, time.Until(deadline))):
+		}
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cacheLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				info.ExpiresAt = time.Now().UTC().Add(cacheLockTTL)
+				if err := c.locker.Renew(context.Background(), info); err != nil {
+					logger.Warn("Failed to renew clone cache lock", "entry", key, "error", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		wg.Wait()
+		if err := c.locker.Unlock(context.Background(), info); err != nil {
+			logger.Warn("Failed to release clone cache lock", "entry", key, "error", err)
+		}
+	}, nil
+}
+
+// cacheEntry is a bare repository of the cache
+type cacheEntry struct {
+	key      string
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// Evict removes entries not used within the maximum age, then the least recently
+// used entries until the cache fits its maximum size. Entries in use by other
+// processes and the entry of keep are left alone.
+func (c *Cache) Evict(ctx context.Context, keep string, logger *slog.Logger) error {
+	if c.maxAge <= 0 && c.maxSize <= 0 {
+		return nil
+	}
+	logger = logging.OrDefault(logger)
+
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.Before(entries[j].lastUsed)
+	})
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+
+	now := time.Now()
+	keepKey := ""
+	if keep != "" {
+		keepKey = CacheKey(keep)
+	}
+	for _, e := range entries {
+		expired := c.maxAge > 0 && now.Sub(e.lastUsed) > c.maxAge
+		oversized := c.maxSize > 0 && total > c.maxSize
+		if !expired && !oversized {
+			continue
+		}
+		if e.key == keepKey {
+			continue
+		}
+
+		info := lock.NewInfo(lock.Key{Account: "git-cache", Stack: e.key}, "evict", cacheLockTTL)
+		if err := c.locker.TryLock(ctx, info); err != nil {
+			logger.Debug("Skipping clone cache entry in use", "entry", e.key, "error", err)
+			continue
+		}
+		err := os.RemoveAll(e.path)
+		c.locker.Unlock(context.Background(), info)
+		if err != nil {
+			return fmt.Errorf("failed to evict clone cache entry %s: %w", e.key, err)
+		}
+		total -= e.size
+		logger.Info("Evicted clone cache entry", "entry", e.key, "lastUsed", e.lastUsed, "size", e.size)
+	}
+	return nil
+}
+
+// entries lists the bare repositories of the cache with their size and last use
+func (c *Cache) entries() ([]cacheEntry, error) {
+	dirs, err := os.ReadDir(c.reposDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clone cache: %w", err)
+	}
+	var entries []cacheEntry
+	for _, d := range dirs {
+		if !d.IsDir() || !strings.HasSuffix(d.Name(), ".git") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.reposDir(), d.Name())
+		size, err := dirSize(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read clone cache: %w", err)
+		}
+		entries = append(entries, cacheEntry{
+			key:      strings.TrimSuffix(d.Name(), ".git"),
+			path:     path,
+			size:     size,
+			lastUsed: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
+
+// dirSize returns the total size of the files below dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// CacheKey returns the cache entry name of a repository: a readable form of its
+// normalized URL and a hash telling apart URLs that read the same
+func CacheKey(repoURL string) string {
+	key := strings.TrimSpace(repoURL)
+	if u, err := ParseURL(repoURL); err == nil {
+		key = u.Key()
+	}
+	return safeName(key, key, 64)
+}
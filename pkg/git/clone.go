@@ -1,15 +1,97 @@
 package git
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"cdk-deployer/pkg/event"
+	"cdk-deployer/pkg/logging"
 )
 
+// cloneOptions holds the settings of a clone
+type cloneOptions struct {
+	ref    string
+	logger *slog.Logger
+	events event.Handler
+	cache  *Cache
+	auth   transport.AuthMethod
+
+	verifier *Verifier
+	policy   URLPolicy
+
+	skipSubmodules bool
+	skipLFS        bool
+}
+
+// CloneOption configures a clone
+type CloneOption func(*cloneOptions)
+
+// WithRef checks out a branch, tag or commit SHA instead of the default branch
+func WithRef(ref string) CloneOption {
+	return func(o *cloneOptions) {
+		o.ref = ref
+	}
+}
+
+// WithLogger sets the logger for clone progress
+func WithLogger(logger *slog.Logger) CloneOption {
+	return func(o *cloneOptions) {
+		o.logger = logger
+	}
+}
+
+// WithEventHandler sets the handler receiving clone progress events
+func WithEventHandler(h event.Handler) CloneOption {
+	return func(o *cloneOptions) {
+		o.events = h
+	}
+}
+
+// WithAuth sets the credentials for the repository, its submodules and its LFS server.
+// Without it, credentials given in an https URL are used.
+func WithAuth(auth transport.AuthMethod) CloneOption {
+	return func(o *cloneOptions) {
+		o.auth = auth
+	}
+}
+
+// WithSubmodules sets whether submodules are checked out recursively (default true)
+func WithSubmodules(enabled bool) CloneOption {
+	return func(o *cloneOptions) {
+		o.skipSubmodules = !enabled
+	}
+}
+
+// WithLFS sets whether Git LFS pointer files are replaced with their content from the
+// LFS server (default true)
+func WithLFS(enabled bool) CloneOption {
+	return func(o *cloneOptions) {
+		o.skipLFS = !enabled
+	}
+}
+
 // CloneRepository clones a public git repository to a local directory
-func CloneRepository(repoURL, destDir string) (string, error) {
+func CloneRepository(repoURL, destDir string, opts ...CloneOption) (string, error) {
+	return CloneRepositoryContext(context.Background(), repoURL, destDir, opts...)
+}
+
+// CloneRepositoryContext is CloneRepository with a context. Cancelling it stops the
+// clone, the cache fetch, the submodule updates and the LFS downloads.
+func CloneRepositoryContext(ctx context.Context, repoURL, destDir string, opts ...CloneOption) (string, error) {
+	var options cloneOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	logger := logging.OrDefault(options.logger)
+
 	// If destDir is empty, create a temp directory
 	if destDir == "" {
 		tmpDir, err := os.MkdirTemp("", "cdk-deployer-*")
@@ -19,29 +101,176 @@ func CloneRepository(repoURL, destDir string) (string, error) {
 		destDir = tmpDir
 	}
 
-	// Extract repo name for subdirectory
-	repoName := filepath.Base(repoURL)
-	if filepath.Ext(repoName) == ".git" {
-		repoName = repoName[:len(repoName)-4]
+	// The checkout directory is named after the repository, with a hash of its URL
+	u, err := ParseURL(repoURL)
+	if err != nil {
+		return "", err
+	}
+	if err := options.policy.Check(u); err != nil {
+		return "", err
+	}
+	repoURL = u.String()
+	if options.auth == nil {
+		options.auth = u.auth()
 	}
-	clonePath := filepath.Join(destDir, repoName)
+	clonePath := filepath.Join(destDir, u.DirName())
 
 	// Clone the repository
-	fmt.Printf("Cloning %s to %s...\n", repoURL, clonePath)
-	_, err := git.PlainClone(clonePath, false, &git.CloneOptions{
+	attrs := []any{"repo", repoURL, "path", clonePath}
+	if options.ref != "" {
+		attrs = append(attrs, "ref", options.ref)
+	}
+	logger.Info("Cloning repository", attrs...)
+
+	progress := logging.NewLineWriter(logger, slog.LevelDebug, "git").OnLine(func(line string) {
+		options.events.Emit(event.Event{Type: event.CloneProgress, Source: "git", Message: line})
+	})
+	defer progress.Close()
+	if options.cache != nil {
+		if err := options.cache.clone(ctx, repoURL, options.ref, clonePath, options.auth, progress, logger); err != nil {
+			os.RemoveAll(clonePath)
+			return "", err
+		}
+		if err := options.cache.Evict(ctx, repoURL, logger); err != nil {
+			logger.Warn("Failed to evict clone cache entries", "error", err)
+		}
+	} else if err := cloneRef(ctx, repoURL, clonePath, options.ref, options.auth, progress); err != nil {
+		return "", err
+	}
+
+	// Nothing of an untrusted checkout is fetched or run
+	if options.verifier != nil {
+		signer, err := options.verifier.Verify(clonePath, options.ref)
+		if err != nil {
+			os.RemoveAll(clonePath)
+			return "", err
+		}
+		logger.Info("Signature verified", "signer", signer.Identity, "method", signer.Method, "key", signer.Key, "object", signer.Object)
+		options.events.Emit(event.Event{Type: event.SourceVerified, Source: "git", Signer: signer})
+	}
+
+	if err := completeCheckout(ctx, clonePath, u, options, progress, logger); err != nil {
+		os.RemoveAll(clonePath)
+		return "", err
+	}
+
+	logger.Info("Repository cloned successfully")
+	return clonePath, nil
+}
+
+// completeCheckout checks out the submodules of a clone and replaces the LFS pointer
+// files of the clone and its submodules with their content
+func completeCheckout(ctx context.Context, clonePath string, u *RepoURL, options cloneOptions, progress io.Writer, logger *slog.Logger) error {
+	repos := []module{{path: clonePath, url: u, auth: options.auth}}
+	if !options.skipSubmodules {
+		modules, err := updateSubmodules(ctx, clonePath, u, options.policy, options.auth, progress)
+		if err != nil {
+			return err
+		}
+		if len(modules) > 0 {
+			logger.Info("Submodules checked out", "count", len(modules))
+		}
+		repos = append(repos, modules...)
+	}
+
+	if options.skipLFS {
+		return nil
+	}
+	for _, r := range repos {
+		if err := fetchLFS(ctx, r.path, r.url, options.policy, r.auth, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneRef clones a repository at a ref. Branches and tags are cloned shallowly;
+// anything else is treated as a commit and needs a full clone to resolve.
+func cloneRef(ctx context.Context, repoURL, clonePath, ref string, auth transport.AuthMethod, progress io.Writer) error {
+	cloneOpts := &git.CloneOptions{
 		URL:      repoURL,
-		Progress: os.Stdout,
+		Auth:     auth,
+		Progress: progress,
 		Depth:    1, // Shallow clone for faster operation
+	}
+	if ref == "" {
+		return plainClone(ctx, clonePath, cloneOpts)
+	}
+
+	for _, refName := range []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+	} {
+		cloneOpts.ReferenceName = refName
+		cloneOpts.SingleBranch = true
+		err := plainClone(ctx, clonePath, cloneOpts)
+		if err == nil {
+			return nil
+		}
+		// Remove the partial clone before trying the next kind of ref
+		if rmErr := os.RemoveAll(clonePath); rmErr != nil {
+			return fmt.Errorf("failed to clean up partial clone: %w", rmErr)
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+
+	repo, err := git.PlainCloneContext(ctx, clonePath, false, &git.CloneOptions{
+		URL:      repoURL,
+		Auth:     auth,
+		Progress: progress,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to clone repository: %w", err)
+		return fmt.Errorf("failed to clone repository: %w", err)
 	}
 
-	fmt.Println("Repository cloned successfully")
-	return clonePath, nil
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return fmt.Errorf("failed to resolve ref %s: %w", ref, err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to open worktree: %w", err)
+	}
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: *hash}); err != nil {
+		return fmt.Errorf("failed to check out %s: %w", ref, err)
+	}
+	return nil
+}
+
+// plainClone runs a clone with the given options
+func plainClone(ctx context.Context, clonePath string, opts *git.CloneOptions) error {
+	if _, err := git.PlainCloneContext(ctx, clonePath, false, opts); err != nil {
+		return fmt.Errorf("failed to clone repository: %w", err)
+	}
+	return nil
+}
+
+// openWorkingCopy opens the repository of a working copy
+func openWorkingCopy(path string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open working copy: %w", err)
+	}
+	return repo, nil
 }
 
 // CleanupRepository removes the cloned repository directory
 func CleanupRepository(path string) error {
 	return os.RemoveAll(path)
 }
+
+// HeadCommit returns the SHA of the commit checked out in a repository
+func HeadCommit(path string) (string, error) {
+	repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return head.Hash().String(), nil
+}
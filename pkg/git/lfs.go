@@ -0,0 +1,326 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// lfsPointerVersion is the first line of every Git LFS pointer file
+const lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// MaxLFSPointerSize is the size above which a file is never an LFS pointer
+const MaxLFSPointerSize = 1024
+
+// lfsBatchSize is the number of objects requested from the LFS server at once
+const lfsBatchSize = 100
+
+// lfsMediaType is the content type of the LFS batch API
+const lfsMediaType = "application/vnd.git-lfs+json"
+
+// LFSPointer is the content of a Git LFS pointer file, which stands in for a file
+// stored on the LFS server
+type LFSPointer struct {
+	OID  string
+	Size int64
+}
+
+// ParseLFSPointer parses the content of a file, reporting false if it is not an LFS
+// pointer
+func ParseLFSPointer(data []byte) (*LFSPointer, bool) {
+	if len(data) > MaxLFSPointerSize || !bytes.HasPrefix(data, []byte(lfsPointerVersion+"\n")) {
+		return nil, false
+	}
+	var p LFSPointer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			p.OID, _ = strings.CutPrefix(value, "sha256:")
+		case "size":
+			p.Size, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	if len(p.OID) != sha256.Size*2 {
+		return nil, false
+	}
+	return &p, true
+}
+
+// FindLFSPointers returns the files below dir, relative to it, that are LFS pointers
+// rather than their content. Git metadata and nested repositories are skipped.
+func FindLFSPointers(dir string) ([]string, error) {
+	var pointers []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == git.GitDirName {
+				return filepath.SkipDir
+			}
+			// Submodules are nested repositories with LFS servers of their own
+			if _, err := os.Lstat(filepath.Join(p, git.GitDirName)); err == nil && p != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > MaxLFSPointerSize {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if _, ok := ParseLFSPointer(data); ok {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			pointers = append(pointers, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for LFS pointers: %w", err)
+	}
+	return pointers, nil
+}
+
+// fetchLFS replaces the LFS pointer files of a working copy with their content,
+// downloaded from the LFS server of the repository
+func fetchLFS(ctx context.Context, path string, repo *RepoURL, policy URLPolicy, auth transport.AuthMethod, logger *slog.Logger) error {
+	files, err := FindLFSPointers(path)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	endpoint, sameHost, err := lfsEndpoint(path, repo, policy)
+	if err != nil {
+		return err
+	}
+	// The credentials of the repository are only sent to its own host
+	if !sameHost {
+		auth = nil
+	}
+	logger.Info("Fetching Git LFS objects", "files", len(files), "endpoint", endpoint)
+
+	// Files with the same content share one download
+	byOID := make(map[string][]string)
+	var pointers []LFSPointer
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(path, file))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		p, _ := ParseLFSPointer(data)
+		if _, ok := byOID[p.OID]; !ok {
+			pointers = append(pointers, *p)
+		}
+		byOID[p.OID] = append(byOID[p.OID], file)
+	}
+
+	client := &lfsClient{endpoint: endpoint, auth: auth}
+	for start := 0; start < len(pointers); start += lfsBatchSize {
+		batch := pointers[start:min(start+lfsBatchSize, len(pointers))]
+		objects, err := client.batch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if obj.Error != nil {
+				return fmt.Errorf("LFS object %s of %s: %s (%d)", obj.OID, byOID[obj.OID][0], obj.Error.Message, obj.Error.Code)
+			}
+			for _, file := range byOID[obj.OID] {
+				if err := client.download(ctx, obj, filepath.Join(path, file)); err != nil {
+					return fmt.Errorf("failed to fetch LFS object of %s: %w", file, err)
+				}
+			}
+		}
+	}
+	logger.Info("Git LFS objects fetched successfully")
+	return nil
+}
+
+// lfsEndpoint returns the LFS server of a repository: lfs.url from .lfsconfig, which
+// must be an https:// URL allowed by the policy, or <repo>.git/info/lfs on the HTTPS
+// host of the repository. It also reports whether the server is on the host of the
+// repository.
+func lfsEndpoint(path string, repo *RepoURL, policy URLPolicy) (string, bool, error) {
+	if data, err := os.ReadFile(filepath.Join(path, ".lfsconfig")); err == nil {
+		cfg := gitconfig.New()
+		if err := gitconfig.NewDecoder(bytes.NewReader(data)).Decode(cfg); err != nil {
+			return "", false, fmt.Errorf("failed to parse .lfsconfig: %w", err)
+		}
+		if raw := cfg.Section("lfs").Option("url"); raw != "" {
+			u, err := ParseURL(raw)
+			if err != nil {
+				return "", false, fmt.Errorf("invalid lfs.url in .lfsconfig: %w", err)
+			}
+			if u.Scheme != "https" {
+				return "", false, fmt.Errorf("lfs.url %s in .lfsconfig is not an https:// URL", raw)
+			}
+			if err := policy.Check(u); err != nil {
+				return "", false, fmt.Errorf("lfs.url in .lfsconfig: %w", err)
+			}
+			return u.String(), u.Host == repo.Host && u.Port == repo.Port, nil
+		}
+	}
+
+	if repo.IsLocal() {
+		return "", false, fmt.Errorf("cannot fetch LFS objects of %s: local repositories have no LFS server; set lfs.url in .lfsconfig", repo)
+	}
+	host := repo.Host
+	if repo.Port != "" && repo.Scheme == "https" {
+		host += ":" + repo.Port
+	}
+	return (&url.URL{Scheme: "https", Host: host, Path: "/" + repo.Path + ".git/info/lfs"}).String(), true, nil
+}
+
+// lfsClient talks to the batch API of an LFS server
+type lfsClient struct {
+	endpoint string
+	auth     transport.AuthMethod
+}
+
+// lfsObject is an object of a batch request or response
+type lfsObject struct {
+	OID     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions *struct {
+		Download *lfsAction `json:"download"`
+	} `json:"actions,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// lfsAction is where and how to download an object
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+// batch asks the LFS server where to download objects
+func (c *lfsClient) batch(ctx context.Context, pointers []LFSPointer) ([]lfsObject, error) {
+	objects := make([]lfsObject, len(pointers))
+	for i, p := range pointers {
+		objects[i] = lfsObject{OID: p.OID, Size: p.Size}
+	}
+	body, err := json.Marshal(map[string]any{
+		"operation": "download",
+		"transfers": []string{"basic"},
+		"objects":   objects,
+		"hash_algo": "sha256",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LFS endpoint: %w", err)
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	if auth, ok := c.auth.(githttp.AuthMethod); ok {
+		auth.SetAuth(req)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("LFS batch request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("LFS batch request failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		Objects []lfsObject `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse LFS batch response: %w", err)
+	}
+	return result.Objects, nil
+}
+
+// download replaces a pointer file with the content of its object, checking its size
+// and hash
+func (c *lfsClient) download(ctx context.Context, obj lfsObject, target string) error {
+	if obj.Actions == nil || obj.Actions.Download == nil {
+		return errors.New("the LFS server has no download for the object")
+	}
+	action := obj.Actions.Download
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, action.Href, nil)
+	if err != nil {
+		return fmt.Errorf("invalid download URL: %w", err)
+	}
+	for k, v := range action.Header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".lfs-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != obj.Size {
+		return fmt.Errorf("expected %d bytes, got %d", obj.Size, n)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != obj.OID {
+		return fmt.Errorf("content does not match its hash: expected %s, got %s", obj.OID, got)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
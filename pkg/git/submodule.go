@@ -0,0 +1,84 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// module is a checked out submodule
+type module struct {
+	// path is the directory of the submodule
+	path string
+	// url is the repository the submodule was cloned from
+	url *RepoURL
+	// auth holds the credentials the submodule was cloned with
+	auth transport.AuthMethod
+}
+
+// updateSubmodules initializes and checks out the submodules of a working copy of the
+// repository at u, recursively. The credentials of the parent repository are only sent
+// to submodules on its host; others use the credentials in their URL, if any. Every
+// submodule URL must be allowed by the policy. It returns every checked out
+// submodule, nested ones included.
+func updateSubmodules(ctx context.Context, path string, u *RepoURL, policy URLPolicy, auth transport.AuthMethod, progress io.Writer) ([]module, error) {
+	repo, err := openWorkingCopy(path)
+	if err != nil {
+		return nil, err
+	}
+	return updateRepoSubmodules(ctx, repo, path, u, policy, auth, progress)
+}
+
+// updateRepoSubmodules updates the submodules of an open repository whose working
+// tree is at path
+func updateRepoSubmodules(ctx context.Context, repo *git.Repository, path string, u *RepoURL, policy URLPolicy, auth transport.AuthMethod, progress io.Writer) ([]module, error) {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open worktree: %w", err)
+	}
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read submodules: %w", err)
+	}
+
+	var modules []module
+	for _, sub := range submodules {
+		cfg := sub.Config()
+		subURL, err := u.Resolve(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL of submodule %s: %w", cfg.Path, err)
+		}
+		if err := policy.Check(subURL); err != nil {
+			return nil, fmt.Errorf("submodule %s: %w", cfg.Path, err)
+		}
+		// go-git would resolve a relative URL against the working directory
+		cfg.URL = subURL.String()
+		fmt.Fprintf(progress, "Updating submodule %s from %s\n", cfg.Path, cfg.URL)
+		subAuth := subURL.auth()
+		if auth != nil && subURL.Host == u.Host && subURL.Port == u.Port {
+			subAuth = auth
+		}
+		err = sub.UpdateContext(ctx, &git.SubmoduleUpdateOptions{Init: true, Auth: subAuth})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update submodule %s: %w", cfg.Path, err)
+		}
+
+		subRepo, err := sub.Repository()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open submodule %s: %w", cfg.Path, err)
+		}
+		subPath := filepath.Join(path, filepath.FromSlash(cfg.Path))
+		modules = append(modules, module{path: subPath, url: subURL, auth: subAuth})
+
+		nested, err := updateRepoSubmodules(ctx, subRepo, subPath, subURL, policy, subAuth, progress)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, nested...)
+	}
+	return modules, nil
+}
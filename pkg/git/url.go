@@ -0,0 +1,276 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// scpURL matches the scp-like syntax of SSH URLs, [user@]host:path, where no slash
+// comes before the colon
+var scpURL = regexp.MustCompile(`^(?:([^@/]+)@)?([^@/:]+):(.*)$`)
+
+// remoteHelperURL matches the <transport>::<address> syntax of git remote helpers,
+// such as ext::, which can run arbitrary commands
+var remoteHelperURL = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*::`)
+
+// validHost matches host names and IP addresses
+var validHost = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$|^[0-9a-f.]*:[0-9a-f:.]*$`)
+
+// unsafeKeyChars matches characters left out of cache keys and checkout directories
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// RepoURL is a repository URL in one of the forms git accepts, reduced to what
+// identifies the repository
+type RepoURL struct {
+	// Scheme is https, ssh or file; scp-like URLs are ssh
+	Scheme string
+	// User is the user of SSH URLs, e.g. git
+	User string
+	// Host is the host name in lower case, without the port
+	Host string
+	// Port is the port if given
+	Port string
+	// Path is the repository path without surrounding slashes and .git suffix, e.g.
+	// acme/platform, or the absolute directory of a local repository
+	Path string
+
+	// raw is the URL to clone from, without credentials, query, fragment or trailing slash
+	raw string
+	// username and password are the credentials of an https URL, sent as basic auth
+	// instead of in the URL
+	username, password string
+}
+
+// ParseURL parses an https://, ssh:// or file:// URL, the scp-like git@host:org/repo
+// syntax or a local path. Other schemes, including unencrypted http:// and git://,
+// are refused.
+func ParseURL(repoURL string) (*RepoURL, error) {
+	raw := strings.TrimSpace(repoURL)
+	if raw == "" {
+		return nil, errors.New("empty repository URL")
+	}
+
+	var u *RepoURL
+	switch {
+	case remoteHelperURL.MatchString(raw):
+		return nil, fmt.Errorf("remote helper URL %s is not supported", repoURL)
+
+	case strings.Contains(raw, "://"):
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository URL %s: %w", repoURL, err)
+		}
+		scheme := strings.ToLower(parsed.Scheme)
+		switch scheme {
+		case "https", "file":
+		case "ssh", "git+ssh", "ssh+git":
+			scheme = "ssh"
+			parsed.Scheme = scheme
+		default:
+			return nil, fmt.Errorf("unsupported scheme %s:// in repository URL %s (use https, ssh or file)", parsed.Scheme, repoURL)
+		}
+		if scheme != "file" && parsed.Hostname() == "" {
+			return nil, fmt.Errorf("repository URL %s has no host", repoURL)
+		}
+		parsed.RawQuery, parsed.Fragment, parsed.RawFragment = "", "", ""
+		u = &RepoURL{
+			Scheme: scheme,
+			User:   parsed.User.Username(),
+			Host:   strings.ToLower(parsed.Hostname()),
+			Port:   parsed.Port(),
+			Path:   parsed.Path,
+		}
+		// Credentials are kept out of the URL, which is logged and recorded. Those of
+		// https URLs are not part of the repository's identity and are sent as basic auth.
+		if scheme == "https" {
+			u.username = u.User
+			u.password, _ = parsed.User.Password()
+			u.User = ""
+			parsed.User = nil
+		} else if parsed.User != nil {
+			parsed.User = url.User(u.User)
+		}
+		u.raw = strings.TrimRight(parsed.String(), "/")
+
+	case scpURL.MatchString(raw):
+		m := scpURL.FindStringSubmatch(raw)
+		u = &RepoURL{Scheme: "ssh", User: m[1], Host: strings.ToLower(m[2]), Path: m[3], raw: strings.TrimRight(raw, "/")}
+
+	default:
+		abs, err := filepath.Abs(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository path %s: %w", repoURL, err)
+		}
+		return &RepoURL{Scheme: "file", Path: abs, raw: abs}, nil
+	}
+
+	if u.Scheme == "file" {
+		u.Path = path.Clean("/" + u.Path)
+		return u, nil
+	}
+	if !validHost.MatchString(u.Host) {
+		return nil, fmt.Errorf("repository URL %s has an invalid host %q", repoURL, u.Host)
+	}
+	u.Path = strings.TrimSuffix(strings.Trim(path.Clean("/"+u.Path), "/"), ".git")
+	if u.Path == "" || u.Path == "." {
+		return nil, fmt.Errorf("repository URL %s has no repository path", repoURL)
+	}
+	return u, nil
+}
+
+// String returns the URL to clone from
+func (u *RepoURL) String() string {
+	return u.raw
+}
+
+// auth returns the credentials given in an https URL as basic auth, or nil
+func (u *RepoURL) auth() transport.AuthMethod {
+	if u.username == "" && u.password == "" {
+		return nil
+	}
+	return &githttp.BasicAuth{Username: u.username, Password: u.password}
+}
+
+// RedactURL returns a repository URL without the credentials of https URLs and SSH
+// passwords, for logs and records. URLs that cannot be parsed are returned as given.
+func RedactURL(repoURL string) string {
+	u, err := ParseURL(repoURL)
+	if err != nil {
+		return repoURL
+	}
+	return u.String()
+}
+
+// IsLocal reports whether the repository is on the local file system
+func (u *RepoURL) IsLocal() bool {
+	return u.Scheme == "file"
+}
+
+// Name returns the repository name, the last element of its path
+func (u *RepoURL) Name() string {
+	return path.Base(strings.TrimSuffix(u.Path, ".git"))
+}
+
+// Key returns host/path, the same for every spelling of a repository URL:
+// https://github.com/acme/app.git, https://github.com/acme/app/ and
+// git@github.com:acme/app all give github.com/acme/app. Local repositories give their
+// absolute path.
+func (u *RepoURL) Key() string {
+	if u.IsLocal() {
+		return u.Path
+	}
+	return u.Host + "/" + u.Path
+}
+
+// DirName returns a checkout directory name for the repository: its name and a hash
+// of its key, so repositories of the same name on other hosts or organizations do
+// not collide
+func (u *RepoURL) DirName() string {
+	return safeName(u.Name(), u.Key(), 32)
+}
+
+// Resolve returns the URL of a submodule, which is relative to the repository when
+// given as a relative path such as ../other.git, as go-git resolves it
+func (u *RepoURL) Resolve(ref string) (*RepoURL, error) {
+	ref = strings.TrimSpace(ref)
+	if strings.Contains(ref, "://") || scpURL.MatchString(ref) || filepath.IsAbs(ref) {
+		return ParseURL(ref)
+	}
+	r := *u
+	r.Path = path.Join("/"+u.Path, ref)
+	if !r.IsLocal() {
+		r.Path = strings.TrimSuffix(strings.Trim(r.Path, "/"), ".git")
+		if r.Path == "" {
+			return nil, fmt.Errorf("submodule URL %s has no repository path on %s", ref, u.Host)
+		}
+	}
+	r.raw = r.format()
+	return &r, nil
+}
+
+// format returns the URL of the parsed parts, without credentials
+func (u *RepoURL) format() string {
+	switch {
+	case u.IsLocal():
+		return u.Path
+	case u.Scheme == "ssh" && u.Port == "" && !strings.Contains(u.raw, "://"):
+		return strings.TrimPrefix(u.User+"@", "@") + u.Host + ":" + u.Path
+	default:
+		host := u.Host
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if u.Port != "" {
+			host += ":" + u.Port
+		}
+		user := ""
+		if u.User != "" {
+			user = u.User + "@"
+		}
+		return u.Scheme + "://" + user + host + "/" + u.Path
+	}
+}
+
+// safeName turns a readable name into a file name, followed by a hash of key
+func safeName(name, key string, maxLen int) string {
+	sum := sha256.Sum256([]byte(key))
+	name = strings.Trim(unsafeKeyChars.ReplaceAllString(name, "_"), "_.")
+	if len(name) > maxLen {
+		name = name[len(name)-maxLen:]
+	}
+	if name == "" {
+		name = "repo"
+	}
+	return name + "-" + hex.EncodeToString(sum[:6])
+}
+
+// URLPolicy restricts the repositories that may be cloned
+type URLPolicy struct {
+	// Allow holds host, host/org or host/org/repo patterns, where * matches within a
+	// path element, e.g. github.com/acme or *.example.com. Empty allows every host.
+	Allow []string
+	// AllowLocal permits file:// URLs and local paths, which are refused by default
+	AllowLocal bool
+}
+
+// WithURLPolicy restricts the repositories and submodules that may be cloned. Without
+// it every remote repository is allowed and local ones are refused.
+func WithURLPolicy(p URLPolicy) CloneOption {
+	return func(o *cloneOptions) {
+		o.policy = p
+	}
+}
+
+// Check returns an error if the policy does not allow a repository
+func (p URLPolicy) Check(u *RepoURL) error {
+	if u.IsLocal() {
+		if !p.AllowLocal {
+			return fmt.Errorf("local repository %s is not allowed; enable local repositories to clone it", u.Path)
+		}
+		return nil
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	elems := strings.Split(u.Key(), "/")
+	for _, pattern := range p.Allow {
+		want := strings.Split(strings.Trim(strings.ToLower(pattern), "/"), "/")
+		if len(want) > len(elems) {
+			continue
+		}
+		if ok, _ := path.Match(strings.Join(want, "/"), strings.ToLower(strings.Join(elems[:len(want)], "/"))); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("repository %s is not in the allowed hosts and organizations", u.Key())
+}
@@ -0,0 +1,370 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/crypto/ssh"
+
+	"cdk-deployer/pkg/event"
+)
+
+// sshSigNamespace is the namespace git signs commits and tags in
+const sshSigNamespace = "git"
+
+// sshSigMagic starts SSH signature blobs and the data they sign
+const sshSigMagic = "SSHSIG"
+
+// Verifier checks the signatures of checked out commits and tags against trusted
+// keys: an OpenPGP keyring and an SSH allowed signers file
+type Verifier struct {
+	keyring openpgp.EntityList
+	signers []allowedSigner
+}
+
+// allowedSigner is a line of an SSH allowed signers file
+type allowedSigner struct {
+	principals  string
+	key         ssh.PublicKey
+	namespaces  []string
+	validAfter  time.Time
+	validBefore time.Time
+}
+
+// NewVerifier loads the trusted keys from an OpenPGP keyring, armored or binary, and
+// an SSH allowed signers file in the format of git's gpg.ssh.allowedSignersFile.
+// Either may be empty, but not both.
+func NewVerifier(keyringFile, allowedSignersFile string) (*Verifier, error) {
+	if keyringFile == "" && allowedSignersFile == "" {
+		return nil, errors.New("signature verification needs a GPG keyring or an SSH allowed signers file")
+	}
+	v := &Verifier{}
+	if keyringFile != "" {
+		keyring, err := readKeyring(keyringFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keyring = keyring
+	}
+	if allowedSignersFile != "" {
+		signers, err := readAllowedSigners(allowedSignersFile)
+		if err != nil {
+			return nil, err
+		}
+		v.signers = signers
+	}
+	return v, nil
+}
+
+// WithVerifier refuses checkouts whose commit, or the annotated tag given as ref, is
+// not signed by a trusted key. The signer is reported as a SourceVerified event.
+func WithVerifier(v *Verifier) CloneOption {
+	return func(o *cloneOptions) {
+		o.verifier = v
+	}
+}
+
+// SignatureError reports a checkout without a trusted signature
+type SignatureError struct {
+	// Commit is the checked out commit
+	Commit string
+	// Reasons are why each signed object was refused
+	Reasons []string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("refusing to deploy %s: no trusted signature (%s)", e.Commit, strings.Join(e.Reasons, "; "))
+}
+
+// signedObject is a commit or annotated tag with its signature
+type signedObject struct {
+	kind      string
+	hash      plumbing.Hash
+	signature string
+	payload   []byte
+	time      time.Time
+}
+
+// Verify checks the checkout of ref at path. An annotated tag named by ref that is
+// signed by a trusted key is accepted, as is a signed commit.
+func (v *Verifier) Verify(path, ref string) (*event.Signer, error) {
+	repo, err := openWorkingCopy(path)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", head.Hash(), err)
+	}
+
+	var objects []signedObject
+	if ref != "" {
+		if tagRef, err := repo.Reference(plumbing.NewTagReferenceName(ref), true); err == nil {
+			if tag, err := repo.TagObject(tagRef.Hash()); err == nil && tag.Target == commit.Hash {
+				payload, err := encodeWithoutSignature(tag.EncodeWithoutSignature)
+				if err != nil {
+					return nil, err
+				}
+				objects = append(objects, signedObject{"tag " + ref, tag.Hash, tag.PGPSignature, payload, tag.Tagger.When})
+			}
+		}
+	}
+	payload, err := encodeWithoutSignature(commit.EncodeWithoutSignature)
+	if err != nil {
+		return nil, err
+	}
+	objects = append(objects, signedObject{"commit", commit.Hash, commit.PGPSignature, payload, commit.Committer.When})
+
+	sigErr := &SignatureError{Commit: commit.Hash.String()}
+	for _, obj := range objects {
+		signer, err := v.verify(obj)
+		if err != nil {
+			sigErr.Reasons = append(sigErr.Reasons, fmt.Sprintf("%s: %v", obj.kind, err))
+			continue
+		}
+		signer.Object = obj.kind
+		signer.Hash = obj.hash.String()
+		return signer, nil
+	}
+	return nil, sigErr
+}
+
+// verify checks the signature of an object against the trusted keys of its kind
+func (v *Verifier) verify(obj signedObject) (*event.Signer, error) {
+	sig := strings.TrimSpace(obj.signature)
+	switch {
+	case sig == "":
+		return nil, errors.New("not signed")
+	case strings.HasPrefix(sig, "-----BEGIN PGP SIGNATURE-----"):
+		if v.keyring == nil {
+			return nil, errors.New("signed with GPG but no GPG keyring is configured")
+		}
+		entity, err := openpgp.CheckArmoredDetachedSignature(v.keyring, bytes.NewReader(obj.payload), strings.NewReader(sig), nil)
+		if err != nil {
+			return nil, fmt.Errorf("GPG signature not trusted: %w", err)
+		}
+		identity := ""
+		if id := entity.PrimaryIdentity(); id != nil {
+			identity = id.Name
+		}
+		return &event.Signer{Method: "gpg", Identity: identity, Key: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)}, nil
+	case strings.HasPrefix(sig, "-----BEGIN SSH SIGNATURE-----"):
+		if v.signers == nil {
+			return nil, errors.New("signed with SSH but no allowed signers file is configured")
+		}
+		return v.verifySSH(sig, obj)
+	default:
+		return nil, errors.New("unsupported signature type")
+	}
+}
+
+// verifySSH checks an SSH signature (the SSHSIG format of ssh-keygen -Y sign) and
+// finds the allowed signers of its key
+func (v *Verifier) verifySSH(armored string, obj signedObject) (*event.Signer, error) {
+	block, _ := pem.Decode([]byte(armored))
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return nil, errors.New("malformed SSH signature")
+	}
+	var blob struct {
+		Magic         [6]byte
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      []byte
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := ssh.Unmarshal(block.Bytes, &blob); err != nil || string(blob.Magic[:]) != sshSigMagic || blob.Version != 1 {
+		return nil, errors.New("malformed SSH signature")
+	}
+	if blob.Namespace != sshSigNamespace {
+		return nil, fmt.Errorf("SSH signature is for namespace %q, not %q", blob.Namespace, sshSigNamespace)
+	}
+	key, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("malformed SSH signature key: %w", err)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(blob.Signature, &sig); err != nil {
+		return nil, errors.New("malformed SSH signature")
+	}
+
+	var h hash.Hash
+	switch blob.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported SSH signature hash %s", blob.HashAlgorithm)
+	}
+	h.Write(obj.payload)
+	signed := ssh.Marshal(struct {
+		Magic         [6]byte
+		Namespace     string
+		Reserved      []byte
+		HashAlgorithm string
+		Hash          []byte
+	}{blob.Magic, blob.Namespace, blob.Reserved, blob.HashAlgorithm, h.Sum(nil)})
+	if err := key.Verify(signed, &sig); err != nil {
+		return nil, fmt.Errorf("SSH signature does not match: %w", err)
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, s := range v.signers {
+		if s.allows(key, obj.time) {
+			return &event.Signer{Method: "ssh", Identity: s.principals, Key: fingerprint}, nil
+		}
+	}
+	return nil, fmt.Errorf("SSH key %s is not an allowed signer", fingerprint)
+}
+
+// allows reports whether the line trusts a key for git signatures made at a time
+func (s allowedSigner) allows(key ssh.PublicKey, at time.Time) bool {
+	if !bytes.Equal(s.key.Marshal(), key.Marshal()) {
+		return false
+	}
+	if s.namespaces != nil && !slices.Contains(s.namespaces, sshSigNamespace) {
+		return false
+	}
+	if !s.validAfter.IsZero() && at.Before(s.validAfter) {
+		return false
+	}
+	return s.validBefore.IsZero() || at.Before(s.validBefore)
+}
+
+// encodeWithoutSignature returns the data a commit or tag signature covers
+func encodeWithoutSignature(encode func(plumbing.EncodedObject) error) ([]byte, error) {
+	obj := &plumbing.MemoryObject{}
+	if err := encode(obj); err != nil {
+		return nil, fmt.Errorf("failed to encode signed object: %w", err)
+	}
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// readKeyring reads an OpenPGP keyring, armored or binary
+func readKeyring(file string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPG keyring: %w", err)
+	}
+	var keyring openpgp.EntityList
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		// Exported keys are often concatenated, one armored block per key
+		for rest := data; len(bytes.TrimSpace(rest)) > 0; {
+			start := bytes.Index(rest, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----"))
+			if start < 0 {
+				break
+			}
+			end := bytes.Index(rest[start:], []byte("-----END PGP PUBLIC KEY BLOCK-----"))
+			if end < 0 {
+				return nil, errors.New("failed to parse GPG keyring: unterminated key block")
+			}
+			end += start + len("-----END PGP PUBLIC KEY BLOCK-----")
+			entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(rest[start:end]))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse GPG keyring: %w", err)
+			}
+			keyring = append(keyring, entities...)
+			rest = rest[end:]
+		}
+	} else if keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to parse GPG keyring: %w", err)
+	}
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("GPG keyring %s has no keys", file)
+	}
+	return keyring, nil
+}
+
+// readAllowedSigners reads an SSH allowed signers file: lines of comma-separated
+// principals, optional options and a public key. Certificate authorities are not
+// supported.
+func readAllowedSigners(file string) ([]allowedSigner, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowed signers file: %w", err)
+	}
+	defer f.Close()
+
+	var signers []allowedSigner
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		principals, rest, _ := strings.Cut(line, " ")
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(rest)))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid allowed signer: %w", file, n, err)
+		}
+		s := allowedSigner{principals: principals, key: key}
+		skip := false
+		for _, opt := range options {
+			name, value, _ := strings.Cut(opt, "=")
+			value = strings.Trim(value, `"`)
+			switch strings.ToLower(name) {
+			case "cert-authority":
+				skip = true
+			case "namespaces":
+				s.namespaces = strings.Split(value, ",")
+			case "valid-after":
+				if s.validAfter, err = parseSignerTime(value); err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid valid-after: %w", file, n, err)
+				}
+			case "valid-before":
+				if s.validBefore, err = parseSignerTime(value); err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid valid-before: %w", file, n, err)
+				}
+			}
+		}
+		if !skip {
+			signers = append(signers, s)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read allowed signers file: %w", err)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("allowed signers file %s has no keys", file)
+	}
+	return signers, nil
+}
+
+// parseSignerTime parses the YYYYMMDD[HHMM[SS]][Z] times of allowed signers options,
+// in local time unless suffixed with Z
+func parseSignerTime(s string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(s, "Z") {
+		s, loc = strings.TrimSuffix(s, "Z"), time.UTC
+	}
+	for _, layout := range []string{"20060102150405", "200601021504", "20060102"} {
+		if len(s) == len(layout) {
+			return time.ParseInLocation(layout, s, loc)
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not YYYYMMDD[HHMM[SS]]", s)
+}
@@ -0,0 +1,160 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileStore keeps records as JSON lines in a local file
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore returns a store appending to the JSON lines file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Path returns the file records are appended to
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// Append adds a record as a single line. Lines are written with one write call on a
+// file opened for appending, so concurrent runs do not interleave records.
+func (s *FileStore) Append(_ context.Context, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode history record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write history record: %w", err)
+	}
+	return nil
+}
+
+// Query reads the file and returns the matching records, newest first. A missing
+// file has no records.
+func (s *FileStore) Query(ctx context.Context, q Query) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("failed to parse history file %s line %d: %w", s.path, line, err)
+		}
+		if q.Match(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.After(records[j].Time) })
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+	return records, nil
+}
+
+// templateDir returns the directory of template snapshots, next to the history file
+func (s *FileStore) templateDir() string {
+	return filepath.Join(filepath.Dir(s.path), "templates")
+}
+
+// PutTemplate stores a template snapshot under its hash, once
+func (s *FileStore) PutTemplate(_ context.Context, body string) (string, error) {
+	hash := TemplateHash(body)
+	path := filepath.Join(s.templateDir(), strings.TrimPrefix(hash, "sha256:")+".json")
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(s.templateDir(), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create template directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.templateDir(), ".template-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create template snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(body); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write template snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write template snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to store template snapshot: %w", err)
+	}
+	return hash, nil
+}
+
+// GetTemplate reads a template snapshot
+func (s *FileStore) GetTemplate(_ context.Context, hash string) (string, error) {
+	name, ok := strings.CutPrefix(hash, "sha256:")
+	if !ok || name == "" || strings.ContainsAny(name, `/\.`) {
+		return "", fmt.Errorf("invalid template hash %q", hash)
+	}
+	data, err := os.ReadFile(filepath.Join(s.templateDir(), name+".json"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read template snapshot: %w", err)
+	}
+	if TemplateHash(string(data)) != hash {
+		return "", fmt.Errorf("template snapshot %s is corrupt", hash)
+	}
+	return string(data), nil
+}
+
+// DefaultPath returns the default history file, .cdk-deployer/history.jsonl in the
+// home directory
+func DefaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the home directory: %w", err)
+	}
+	return filepath.Join(home, ".cdk-deployer", "history.jsonl"), nil
+}
@@ -0,0 +1,220 @@
+package history
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"cdk-deployer/pkg/cdk"
+	"cdk-deployer/pkg/event"
+)
+
+// Operation is the kind of run a record describes
+type Operation string
+
+const (
+	// OperationSynth synthesizes the app without touching any stack
+	OperationSynth Operation = "synth"
+	// OperationDeploy deploys stacks
+	OperationDeploy Operation = "deploy"
+	// OperationImport imports existing resources into stacks
+	OperationImport Operation = "import"
+	// OperationDrift detects drift of stacks
+	OperationDrift Operation = "drift"
+	// OperationDestroy deletes stacks
+	OperationDestroy Operation = "destroy"
+	// OperationRollback redeploys a stack at an earlier commit
+	OperationRollback Operation = "rollback"
+)
+
+// Status is the final status of a run or a stack
+type Status string
+
+const (
+	// StatusSucceeded means the run or stack operation completed
+	StatusSucceeded Status = "succeeded"
+	// StatusFailed means the run or stack operation failed
+	StatusFailed Status = "failed"
+	// StatusCancelled means the run was interrupted
+	StatusCancelled Status = "cancelled"
+)
+
+// Record is the audit record of a single synth, deploy, drift or destroy run
+type Record struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Operation Operation `json:"operation"`
+	// Identity is the AWS identity the run used, when it could be resolved
+	Identity *cdk.CallerIdentity `json:"identity,omitempty"`
+	Region   string              `json:"region,omitempty"`
+	Repo     string              `json:"repo,omitempty"`
+	Ref      string              `json:"ref,omitempty"`
+	// Commit is the resolved SHA of the deployed source
+	Commit string `json:"commit,omitempty"`
+	// Signer is the trusted signer of the commit or tag, when signatures are verified
+	Signer *event.Signer `json:"signer,omitempty"`
+	// Subpath and Context are the app directory and CDK context the app was run with
+	Subpath string            `json:"subpath,omitempty"`
+	Context map[string]string `json:"context,omitempty"`
+	// RollbackFrom is the commit a rollback replaced
+	RollbackFrom string `json:"rollbackFrom,omitempty"`
+	// Job is the API server job that ran the operation
+	Job             string        `json:"job,omitempty"`
+	Stacks          []StackRecord `json:"stacks,omitempty"`
+	Status          Status        `json:"status"`
+	Error           string        `json:"error,omitempty"`
+	DurationSeconds float64       `json:"durationSeconds"`
+}
+
+// Duration returns how long the run took
+func (r Record) Duration() time.Duration {
+	return time.Duration(r.DurationSeconds * float64(time.Second))
+}
+
+// Stack returns the record of a stack, or nil if the run did not touch it
+func (r Record) Stack(name string) *StackRecord {
+	for i := range r.Stacks {
+		if r.Stacks[i].Name == name {
+			return &r.Stacks[i]
+		}
+	}
+	return nil
+}
+
+// HasStack reports whether the run touched a stack matching a glob pattern
+func (r Record) HasStack(pattern string) bool {
+	for _, s := range r.Stacks {
+		if cdk.MatchGlob(pattern, s.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// StackRecord is the outcome of a run for a single stack
+type StackRecord struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+	StackID string `json:"stackId,omitempty"`
+	// StackStatus is the CloudFormation status the stack ended in
+	StackStatus string           `json:"stackStatus,omitempty"`
+	ChangeSet   *ChangeSetRecord `json:"changeSet,omitempty"`
+	// OutputsHash fingerprints the stack outputs so changed outputs show up without
+	// recording their values
+	OutputsHash string `json:"outputsHash,omitempty"`
+	// TemplateHash identifies the deployed template in a TemplateStore
+	TemplateHash     string   `json:"templateHash,omitempty"`
+	DriftStatus      string   `json:"driftStatus,omitempty"`
+	DriftedResources []string `json:"driftedResources,omitempty"`
+}
+
+// ChangeSetRecord summarizes the change set a stack was deployed through
+type ChangeSetRecord struct {
+	ID          string `json:"id,omitempty"`
+	Add         int    `json:"add"`
+	Modify      int    `json:"modify"`
+	Remove      int    `json:"remove"`
+	Replace     int    `json:"replace"`
+	Destructive bool   `json:"destructive,omitempty"`
+}
+
+// NewChangeSetRecord summarizes a change set by the kind of its changes
+func NewChangeSetRecord(summary *cdk.ChangeSetSummary) *ChangeSetRecord {
+	if summary == nil {
+		return nil
+	}
+	record := &ChangeSetRecord{ID: summary.ChangeSetID, Destructive: summary.IsDestructive()}
+	for _, c := range summary.Changes {
+		switch c.Action {
+		case "Add":
+			record.Add++
+		case "Remove":
+			record.Remove++
+		default:
+			record.Modify++
+		}
+		if c.Replacement == "True" {
+			record.Replace++
+		}
+	}
+	return record
+}
+
+// OutputsHash returns a SHA-256 over the stack outputs, independent of their order
+func OutputsHash(outputs []cdk.StackOutput) string {
+	if len(outputs) == 0 {
+		return ""
+	}
+	sorted := make([]cdk.StackOutput, len(outputs))
+	copy(sorted, outputs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	h := sha256.New()
+	for _, o := range sorted {
+		h.Write([]byte(o.Key))
+		h.Write([]byte{0})
+		h.Write([]byte(o.Value))
+		h.Write([]byte{0})
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// TemplateHash returns the content address of a template
+func TemplateHash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Query selects records; zero fields match everything
+type Query struct {
+	// Stack is a stack name or glob pattern
+	Stack     string
+	Operation Operation
+	Since     time.Time
+	Until     time.Time
+	// Limit caps the number of records returned, newest first
+	Limit int
+}
+
+// Match reports whether a record satisfies the query, ignoring the limit
+func (q Query) Match(r Record) bool {
+	if q.Operation != "" && r.Operation != q.Operation {
+		return false
+	}
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && r.Time.After(q.Until) {
+		return false
+	}
+	return q.Stack == "" || r.HasStack(q.Stack)
+}
+
+// Store keeps audit records. Implementations must be safe for concurrent use.
+type Store interface {
+	// Append adds a record
+	Append(ctx context.Context, r Record) error
+	// Query returns the matching records, newest first
+	Query(ctx context.Context, q Query) ([]Record, error)
+}
+
+// TemplateStore keeps snapshots of deployed templates by their TemplateHash. Stores
+// that can keep templates implement it next to Store.
+type TemplateStore interface {
+	// PutTemplate stores a template and returns its hash
+	PutTemplate(ctx context.Context, body string) (string, error)
+	// GetTemplate returns a stored template
+	GetTemplate(ctx context.Context, hash string) (string, error)
+}
+
+// newRecordID returns a random record ID that sorts by time
+func newRecordID(now time.Time) string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s", now.UTC().Format("20060102T150405"), hex.EncodeToString(b))
+}
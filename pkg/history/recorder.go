@@ -0,0 +1,181 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"cdk-deployer/pkg/cdk"
+	"cdk-deployer/pkg/event"
+)
+
+// Recorder collects the audit record of a run from its events and results
+type Recorder struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	record Record
+	stacks map[string]int
+}
+
+// NewRecorder starts the record of a run
+func NewRecorder(op Operation) *Recorder {
+	r := &Recorder{now: time.Now, stacks: make(map[string]int)}
+	start := r.now()
+	r.record = Record{ID: newRecordID(start), Time: start, Operation: op}
+	return r
+}
+
+// SetSource records the repository, requested ref and resolved commit of the run
+func (r *Recorder) SetSource(repo, ref, commit string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.Repo = repo
+	r.record.Ref = ref
+	r.record.Commit = commit
+}
+
+// SetApp records the app directory and CDK context of the run
+func (r *Recorder) SetApp(subpath string, context map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.Subpath = subpath
+	r.record.Context = context
+}
+
+// SetRollbackFrom records the commit a rollback replaces
+func (r *Recorder) SetRollbackFrom(commit string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.RollbackFrom = commit
+}
+
+// SetIdentity records the AWS identity and region the run used
+func (r *Recorder) SetIdentity(identity *cdk.CallerIdentity, region string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.Identity = identity
+	r.record.Region = region
+}
+
+// SetJob records the API server job that ran the operation
+func (r *Recorder) SetJob(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.Job = id
+}
+
+// SetStacks records the stacks the run acts on, so stacks that were never reached
+// still show up
+func (r *Recorder) SetStacks(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		r.stack(name)
+	}
+}
+
+// AddDeployResults records the stack IDs, change sets and outputs of deployed stacks
+func (r *Recorder) AddDeployResults(results []cdk.DeployResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, result := range results {
+		s := r.stack(result.StackName)
+		s.StackID = result.StackID
+		s.StackStatus = result.Status
+		s.ChangeSet = NewChangeSetRecord(result.ChangeSet)
+		s.OutputsHash = OutputsHash(result.Outputs)
+	}
+}
+
+// SnapshotTemplates stores the templates of deployed stacks so that a later rollback
+// can redeploy them, and records their hashes
+func (r *Recorder) SnapshotTemplates(ctx context.Context, store TemplateStore, results []cdk.DeployResult, template func(stackName string) (string, error)) error {
+	for _, result := range results {
+		body, err := template(result.StackName)
+		if err != nil {
+			return err
+		}
+		hash, err := store.PutTemplate(ctx, body)
+		if err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.stack(result.StackName).TemplateHash = hash
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// Handler returns an event handler recording the outcome of each stack and the
+// signer of the source
+func (r *Recorder) Handler() event.Handler {
+	return func(e event.Event) {
+		switch e.Type {
+		case event.StackCompleted, event.StackFailed, event.DriftResult:
+		case event.SourceVerified:
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.record.Signer = e.Signer
+			return
+		default:
+			return
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		s := r.stack(e.Stack)
+		switch e.Type {
+		case event.StackCompleted:
+			s.Status = StatusSucceeded
+			s.StackStatus = e.Status
+		case event.StackFailed:
+			s.Status = StatusFailed
+			if e.Err != nil {
+				s.Error = e.Err.Error()
+			}
+		case event.DriftResult:
+			s.Status = StatusSucceeded
+			s.DriftStatus = e.Status
+			if e.Drift != nil {
+				s.DriftedResources = e.Drift.DriftedResources
+			}
+		}
+	}
+}
+
+// stack returns the record of a stack, adding it in the order stacks are first seen.
+// The caller must hold r.mu.
+func (r *Recorder) stack(name string) *StackRecord {
+	i, ok := r.stacks[name]
+	if !ok {
+		i = len(r.record.Stacks)
+		r.stacks[name] = i
+		r.record.Stacks = append(r.record.Stacks, StackRecord{Name: name})
+	}
+	return &r.record.Stacks[i]
+}
+
+// Finish completes the record with the outcome of the run. A run failing with
+// context.Canceled is recorded as cancelled.
+func (r *Recorder) Finish(runErr error) Record {
+	r.mu.Lock()
+	record := r.record
+	record.Stacks = append([]StackRecord(nil), r.record.Stacks...)
+	r.mu.Unlock()
+
+	record.DurationSeconds = r.now().Sub(record.Time).Seconds()
+	switch {
+	case runErr == nil:
+		record.Status = StatusSucceeded
+	case errors.Is(runErr, context.Canceled):
+		record.Status = StatusCancelled
+		record.Error = runErr.Error()
+	default:
+		record.Status = StatusFailed
+		record.Error = runErr.Error()
+	}
+	return record
+}
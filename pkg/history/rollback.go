@@ -0,0 +1,79 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RollbackTarget is a rollback of a stack from its live deploy to an earlier one
+type RollbackTarget struct {
+	// Current is the latest successful deploy of the stack
+	Current Record
+	// Target is the deploy to return to
+	Target Record
+}
+
+// FindRollbackTarget looks up the deploy a stack is rolled back to. to is either a
+// commit SHA or its prefix, or the number of known-good commits to go back; empty
+// means the previous one. Commits that were rolled back from are not known-good and
+// are only chosen when given by SHA.
+func FindRollbackTarget(ctx context.Context, store Store, stack, to string) (*RollbackTarget, error) {
+	records, err := store.Query(ctx, Query{Stack: stack})
+	if err != nil {
+		return nil, err
+	}
+
+	var deploys []Record
+	bad := make(map[string]bool)
+	for _, r := range records {
+		if r.Operation != OperationDeploy && r.Operation != OperationRollback {
+			continue
+		}
+		s := r.Stack(stack)
+		if s == nil || s.Status != StatusSucceeded || (r.Commit == "" && s.TemplateHash == "") {
+			continue
+		}
+		deploys = append(deploys, r)
+		if r.RollbackFrom != "" {
+			bad[r.RollbackFrom] = true
+		}
+	}
+	if len(deploys) == 0 {
+		return nil, fmt.Errorf("no successful deploy of stack %s in the history", stack)
+	}
+	current := deploys[0]
+
+	// Short numbers count back; anything else is a commit
+	n := 1
+	if to != "" {
+		var err error
+		if n, err = strconv.Atoi(to); err != nil || len(to) >= 4 {
+			n = 0
+		} else if n < 1 {
+			return nil, fmt.Errorf("invalid rollback target %q", to)
+		}
+	}
+	if n > 0 {
+		back := n
+		seen := map[string]bool{current.Commit: true}
+		for _, r := range deploys[1:] {
+			if seen[r.Commit] || bad[r.Commit] {
+				continue
+			}
+			seen[r.Commit] = true
+			if n--; n == 0 {
+				return &RollbackTarget{Current: current, Target: r}, nil
+			}
+		}
+		return nil, fmt.Errorf("cannot go back %d deploy(s): stack %s has %d earlier known-good deploy(s) in the history", back, stack, len(seen)-1)
+	}
+
+	for _, r := range deploys {
+		if r.Commit != "" && strings.HasPrefix(r.Commit, strings.ToLower(to)) {
+			return &RollbackTarget{Current: current, Target: r}, nil
+		}
+	}
+	return nil, fmt.Errorf("no successful deploy of stack %s at commit %s in the history", stack, to)
+}
@@ -0,0 +1,259 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBLocker keeps locks as items of a DynamoDB table with the string partition
+// key LockID, using conditional writes so that only one holder succeeds. Expires
+// holds the expiry in epoch seconds and may be used as the table's TTL attribute.
+type DynamoDBLocker struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// DynamoDBOption configures a DynamoDBLocker
+type DynamoDBOption func(*dynamoDBOptions)
+
+// dynamoDBOptions holds the settings collected from DynamoDBOptions
+type dynamoDBOptions struct {
+	region   string
+	endpoint string
+}
+
+// WithRegion overrides the AWS region of the lock table
+func WithRegion(region string) DynamoDBOption {
+	return func(o *dynamoDBOptions) {
+		o.region = region
+	}
+}
+
+// WithEndpoint sends requests to another endpoint, e.g. a local DynamoDB stand-in
+func WithEndpoint(endpoint string) DynamoDBOption {
+	return func(o *dynamoDBOptions) {
+		o.endpoint = endpoint
+	}
+}
+
+// NewDynamoDBLocker creates a locker storing locks in a DynamoDB table
+func NewDynamoDBLocker(ctx context.Context, table string, opts ...DynamoDBOption) (*DynamoDBLocker, error) {
+	if table == "" {
+		return nil, fmt.Errorf("a DynamoDB lock table is required")
+	}
+
+	var options dynamoDBOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var loadOpts []func(*config.LoadOptions) error
+	if options.region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(options.region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if options.endpoint != "" {
+			o.BaseEndpoint = aws.String(options.endpoint)
+		}
+	})
+	return &DynamoDBLocker{client: client, table: table}, nil
+}
+
+// TryLock writes the lock item unless a live one exists
+func (l *DynamoDBLocker) TryLock(ctx context.Context, info Info) error {
+	_, err := l.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(l.table),
+		Item:                toItem(info),
+		ConditionExpression: aws.String("attribute_not_exists(#lockId) OR #expires <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#lockId":  "LockID",
+			"#expires": "Expires",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": epoch(time.Now()),
+		},
+	})
+	if isConditionFailed(err) {
+		current, err := l.Inspect(ctx, info.Key)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			// Released in the meantime
+			return l.TryLock(ctx, info)
+		}
+		return &HeldError{Info: *current}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write lock: %w", err)
+	}
+	return nil
+}
+
+// Renew updates the expiry of the lock item if it is still held
+func (l *DynamoDBLocker) Renew(ctx context.Context, info Info) error {
+	_, err := l.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.table),
+		Key:                 lockID(info.Key),
+		UpdateExpression:    aws.String("SET #expires = :expires, #expiresAt = :expiresAt"),
+		ConditionExpression: aws.String("#id = :id"),
+		ExpressionAttributeNames: map[string]string{
+			"#expires":   "Expires",
+			"#expiresAt": "ExpiresAt",
+			"#id":        "ID",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expires":   epoch(info.ExpiresAt),
+			":expiresAt": timestamp(info.ExpiresAt),
+			":id":        &types.AttributeValueMemberS{Value: info.ID},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to renew lock: %w", err)
+	}
+	return nil
+}
+
+// Unlock deletes the lock item if it is still held
+func (l *DynamoDBLocker) Unlock(ctx context.Context, info Info) error {
+	_, err := l.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(l.table),
+		Key:                      lockID(info.Key),
+		ConditionExpression:      aws.String("#id = :id"),
+		ExpressionAttributeNames: map[string]string{"#id": "ID"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: info.ID},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+// Inspect reads the lock item of a key
+func (l *DynamoDBLocker) Inspect(ctx context.Context, key Key) (*Info, error) {
+	out, err := l.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(l.table),
+		Key:            lockID(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock: %w", err)
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	return fromItem(out.Item), nil
+}
+
+// ForceUnlock deletes the lock item of a key
+func (l *DynamoDBLocker) ForceUnlock(ctx context.Context, key Key) error {
+	_, err := l.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(l.table),
+		Key:       lockID(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove lock: %w", err)
+	}
+	return nil
+}
+
+// List scans the lock table
+func (l *DynamoDBLocker) List(ctx context.Context) ([]Info, error) {
+	var locks []Info
+	paginator := dynamodb.NewScanPaginator(l.client, &dynamodb.ScanInput{
+		TableName:      aws.String(l.table),
+		ConsistentRead: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list locks: %w", err)
+		}
+		for _, item := range page.Items {
+			locks = append(locks, *fromItem(item))
+		}
+	}
+	return locks, nil
+}
+
+// lockID returns the primary key of the item of a lock
+func lockID(key Key) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"LockID": &types.AttributeValueMemberS{Value: key.String()},
+	}
+}
+
+// toItem converts a lock to a table item
+func toItem(info Info) map[string]types.AttributeValue {
+	item := lockID(info.Key)
+	item["Account"] = &types.AttributeValueMemberS{Value: info.Key.Account}
+	item["Region"] = &types.AttributeValueMemberS{Value: info.Key.Region}
+	item["Stack"] = &types.AttributeValueMemberS{Value: info.Key.Stack}
+	item["ID"] = &types.AttributeValueMemberS{Value: info.ID}
+	item["Holder"] = &types.AttributeValueMemberS{Value: info.Holder}
+	item["PID"] = &types.AttributeValueMemberN{Value: strconv.Itoa(info.PID)}
+	item["Operation"] = &types.AttributeValueMemberS{Value: info.Operation}
+	item["AcquiredAt"] = timestamp(info.AcquiredAt)
+	item["ExpiresAt"] = timestamp(info.ExpiresAt)
+	item["Expires"] = epoch(info.ExpiresAt)
+	return item
+}
+
+// fromItem converts a table item to a lock
+func fromItem(item map[string]types.AttributeValue) *Info {
+	str := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	info := &Info{
+		Key:       Key{Account: str("Account"), Region: str("Region"), Stack: str("Stack")},
+		ID:        str("ID"),
+		Holder:    str("Holder"),
+		Operation: str("Operation"),
+	}
+	if v, ok := item["PID"].(*types.AttributeValueMemberN); ok {
+		info.PID, _ = strconv.Atoi(v.Value)
+	}
+	info.AcquiredAt, _ = time.Parse(time.RFC3339Nano, str("AcquiredAt"))
+	info.ExpiresAt, _ = time.Parse(time.RFC3339Nano, str("ExpiresAt"))
+	return info
+}
+
+// timestamp encodes a time as an RFC 3339 string attribute
+func timestamp(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: t.UTC().Format(time.RFC3339Nano)}
+}
+
+// epoch encodes a time as a number attribute in epoch seconds
+func epoch(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+// isConditionFailed reports whether a write was rejected by its condition
+func isConditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	return errors.As(err, &ccf)
+}
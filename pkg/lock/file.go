@@ -0,0 +1,200 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileLocker keeps locks as JSON files in a directory shared by the processes of a host
+type FileLocker struct {
+	dir string
+}
+
+// NewFileLocker creates a locker storing lock files in dir
+func NewFileLocker(dir string) (*FileLocker, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	return &FileLocker{dir: dir}, nil
+}
+
+// path returns the lock file of a key
+func (l *FileLocker) path(key Key) string {
+	name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(key.String())
+	return filepath.Join(l.dir, name+".lock")
+}
+
+// TryLock creates the lock file, taking over an expired one
+func (l *FileLocker) TryLock(_ context.Context, info Info) error {
+	path := l.path(info.Key)
+	for attempt := 0; attempt < 3; attempt++ {
+		// Linking a complete temporary file creates the lock atomically and never
+		// exposes a partially written one
+		tmp, err := l.writeTemp(info)
+		if err != nil {
+			return err
+		}
+		err = os.Link(tmp, path)
+		os.Remove(tmp)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		current, err := readInfo(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !current.Expired(time.Now()) {
+			return &HeldError{Info: *current}
+		}
+		if err := l.breakExpired(path, current); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to acquire lock %s: contended", info.Key)
+}
+
+// breakExpired removes an expired lock file, unless it was replaced in the meantime
+func (l *FileLocker) breakExpired(path string, expired *Info) error {
+	// Moving the file away first makes sure only the expired lock is removed
+	stale := fmt.Sprintf("%s.%s.stale", path, newID())
+	if err := os.Rename(path, stale); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to remove expired lock: %w", err)
+	}
+	defer os.Remove(stale)
+
+	moved, err := readInfo(stale)
+	if err == nil && moved.ID != expired.ID {
+		// A new lock was taken in between; put it back
+		os.Link(stale, path)
+	}
+	return nil
+}
+
+// Renew rewrites the lock file with the new expiry if it is still held
+func (l *FileLocker) Renew(_ context.Context, info Info) error {
+	path := l.path(info.Key)
+	current, err := readInfo(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrLost
+	}
+	if err != nil {
+		return err
+	}
+	if current.ID != info.ID {
+		return ErrLost
+	}
+
+	tmp, err := l.writeTemp(info)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to renew lock: %w", err)
+	}
+	return nil
+}
+
+// Unlock removes the lock file if it is still held
+func (l *FileLocker) Unlock(_ context.Context, info Info) error {
+	path := l.path(info.Key)
+	current, err := readInfo(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrLost
+	}
+	if err != nil {
+		return err
+	}
+	if current.ID != info.ID {
+		return ErrLost
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return nil
+}
+
+// Inspect reads the lock file of a key
+func (l *FileLocker) Inspect(_ context.Context, key Key) (*Info, error) {
+	info, err := readInfo(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return info, err
+}
+
+// ForceUnlock removes the lock file of a key
+func (l *FileLocker) ForceUnlock(_ context.Context, key Key) error {
+	if err := os.Remove(l.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return nil
+}
+
+// List reads all lock files of the directory
+func (l *FileLocker) List(_ context.Context) ([]Info, error) {
+	paths, err := filepath.Glob(filepath.Join(l.dir, "*.lock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lock files: %w", err)
+	}
+	var locks []Info
+	for _, path := range paths {
+		info, err := readInfo(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Released in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, *info)
+	}
+	return locks, nil
+}
+
+// writeTemp writes a lock to a temporary file in the lock directory
+func (l *FileLocker) writeTemp(info Info) (string, error) {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode lock: %w", err)
+	}
+	f, err := os.CreateTemp(l.dir, ".lock-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write lock file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// readInfo reads a lock file
+func readInfo(path string) (*Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file %s: %w", path, err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,109 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// Key identifies the stack a deployment lock protects
+type Key struct {
+	Account string `json:"account"`
+	Region  string `json:"region"`
+	Stack   string `json:"stack"`
+}
+
+// String returns the key as account/region/stack
+func (k Key) String() string {
+	return fmt.Sprintf("%s/%s/%s", k.Account, k.Region, k.Stack)
+}
+
+// Info describes a held lock
+type Info struct {
+	Key Key `json:"key"`
+	// ID is unique per acquisition; only the holder of the ID renews or releases the lock
+	ID string `json:"id"`
+	// Holder describes who holds the lock, e.g. user@host
+	Holder string `json:"holder"`
+	PID    int    `json:"pid"`
+	// Operation is what the lock was taken for, e.g. deploy or destroy
+	Operation  string    `json:"operation"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Expired reports whether the lease of the lock has run out
+func (i *Info) Expired(now time.Time) bool {
+	return !now.Before(i.ExpiresAt)
+}
+
+// HeldError is returned when a lock is held by someone else
+type HeldError struct {
+	Info Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("stack %s is locked by %s (pid %d, %s since %s, expires %s)",
+		e.Info.Key, e.Info.Holder, e.Info.PID, e.Info.Operation,
+		e.Info.AcquiredAt.Format(time.RFC3339), e.Info.ExpiresAt.Format(time.RFC3339))
+}
+
+// ErrLost is returned when renewing or releasing a lock that is no longer held,
+// e.g. because its lease expired and someone else took it
+var ErrLost = errors.New("lock is no longer held")
+
+// Locker is a lock backend. Locks are leases: a holder renews its lock before
+// ExpiresAt, and an expired lock may be taken over by anyone.
+type Locker interface {
+	// TryLock acquires a lock unless it is held and not expired, returning a *HeldError otherwise
+	TryLock(ctx context.Context, info Info) error
+	// Renew moves the expiry of a held lock to info.ExpiresAt
+	Renew(ctx context.Context, info Info) error
+	// Unlock releases a lock held with info.ID
+	Unlock(ctx context.Context, info Info) error
+	// Inspect returns the current lock, or nil when the stack is not locked
+	Inspect(ctx context.Context, key Key) (*Info, error)
+	// ForceUnlock removes a lock regardless of its holder
+	ForceUnlock(ctx context.Context, key Key) error
+	// List returns all current locks
+	List(ctx context.Context) ([]Info, error)
+}
+
+// NewInfo describes a new acquisition of a lock by this process
+func NewInfo(key Key, operation string, ttl time.Duration) Info {
+	now := time.Now().UTC()
+	return Info{
+		Key:        key,
+		ID:         newID(),
+		Holder:     holder(),
+		PID:        os.Getpid(),
+		Operation:  operation,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+}
+
+// holder returns user@host of this process
+func holder() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return name + "@" + host
+}
+
+// newID returns a random acquisition ID
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,303 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// item is a DynamoDB item in the JSON wire format, limited to S and N attributes
+type item map[string]map[string]string
+
+// dynamoDBStandIn is a local stand-in for the DynamoDB API, implementing the requests
+// and the condition and update expressions the DynamoDBLocker sends
+type dynamoDBStandIn struct {
+	mu    sync.Mutex
+	items map[string]item
+}
+
+// request is the union of the request fields the stand-in reads
+type request struct {
+	Item                      item              `json:"Item"`
+	Key                       item              `json:"Key"`
+	ConditionExpression       string            `json:"ConditionExpression"`
+	UpdateExpression          string            `json:"UpdateExpression"`
+	ExpressionAttributeNames  map[string]string `json:"ExpressionAttributeNames"`
+	ExpressionAttributeValues item              `json:"ExpressionAttributeValues"`
+}
+
+func (s *dynamoDBStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDynamoDBError(w, "SerializationException", err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	var key string
+	switch operation {
+	case "PutItem":
+		key = req.Item["LockID"]["S"]
+	case "Scan":
+	default:
+		key = req.Key["LockID"]["S"]
+	}
+	current, exists := s.items[key]
+
+	if req.ConditionExpression != "" && !req.matches(current, exists) {
+		writeDynamoDBError(w, "ConditionalCheckFailedException", "The conditional request failed")
+		return
+	}
+
+	resp := map[string]any{}
+	switch operation {
+	case "PutItem":
+		s.items[key] = req.Item
+	case "UpdateItem":
+		for _, assignment := range strings.Split(strings.TrimPrefix(req.UpdateExpression, "SET "), ",") {
+			name, value, _ := strings.Cut(assignment, "=")
+			current[req.name(name)] = req.ExpressionAttributeValues[strings.TrimSpace(value)]
+		}
+	case "DeleteItem":
+		delete(s.items, key)
+	case "GetItem":
+		if exists {
+			resp["Item"] = current
+		}
+	case "Scan":
+		items := []item{}
+		for _, it := range s.items {
+			items = append(items, it)
+		}
+		resp["Items"], resp["Count"] = items, len(items)
+	default:
+		writeDynamoDBError(w, "UnknownOperationException", operation)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// matches evaluates a condition of OR-ed attribute_not_exists, = and <= terms
+func (req *request) matches(current item, exists bool) bool {
+	for _, term := range strings.Split(req.ConditionExpression, " OR ") {
+		term = strings.TrimSpace(term)
+		if name, ok := strings.CutPrefix(term, "attribute_not_exists("); ok {
+			if _, found := current[req.name(strings.TrimSuffix(name, ")"))]; !exists || !found {
+				return true
+			}
+			continue
+		}
+		if !exists {
+			continue
+		}
+		for _, op := range []string{"<=", "="} {
+			left, right, ok := strings.Cut(term, " "+op+" ")
+			if !ok {
+				continue
+			}
+			attr, value := current[req.name(left)], req.ExpressionAttributeValues[right]
+			if op == "=" && attr["S"] == value["S"] && attr["N"] == value["N"] {
+				return true
+			}
+			if op == "<=" {
+				a, _ := strconv.ParseInt(attr["N"], 10, 64)
+				b, _ := strconv.ParseInt(value["N"], 10, 64)
+				if a <= b {
+					return true
+				}
+			}
+			break
+		}
+	}
+	return false
+}
+
+// name resolves an expression attribute name placeholder
+func (req *request) name(placeholder string) string {
+	placeholder = strings.TrimSpace(placeholder)
+	if name, ok := req.ExpressionAttributeNames[placeholder]; ok {
+		return name
+	}
+	return placeholder
+}
+
+func writeDynamoDBError(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `{"__type":"com.amazonaws.dynamodb.v20120810#%s","message":%q}`, code, message)
+}
+
+// newTestDynamoDBLocker returns a DynamoDBLocker talking to a local stand-in
+func newTestDynamoDBLocker(t *testing.T) Locker {
+	t.Helper()
+	server := httptest.NewServer(&dynamoDBStandIn{items: map[string]item{}})
+	t.Cleanup(server.Close)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	l, err := NewDynamoDBLocker(context.Background(), "locks", WithRegion("us-east-1"), WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func newTestFileLocker(t *testing.T) Locker {
+	t.Helper()
+	l, err := NewFileLocker(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// lockers runs a test against every backend
+func lockers(t *testing.T, test func(t *testing.T, l Locker)) {
+	for name, newLocker := range map[string]func(*testing.T) Locker{
+		"file":     newTestFileLocker,
+		"dynamodb": newTestDynamoDBLocker,
+	} {
+		t.Run(name, func(t *testing.T) {
+			test(t, newLocker(t))
+		})
+	}
+}
+
+var testKey = Key{Account: "123456789012", Region: "us-east-1", Stack: "App"}
+
+func TestAcquireAndInspect(t *testing.T) {
+	lockers(t, func(t *testing.T, l Locker) {
+		ctx := context.Background()
+		info := NewInfo(testKey, "deploy", time.Hour)
+		if err := l.TryLock(ctx, info); err != nil {
+			t.Fatalf("TryLock: %v", err)
+		}
+
+		current, err := l.Inspect(ctx, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current == nil || current.ID != info.ID || current.Holder != info.Holder || current.Operation != "deploy" || current.Key != testKey {
+			t.Errorf("Inspect = %+v, want %+v", current, info)
+		}
+
+		locks, err := l.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(locks) != 1 || locks[0].ID != info.ID {
+			t.Errorf("List = %+v, want the acquired lock", locks)
+		}
+	})
+}
+
+func TestConflict(t *testing.T) {
+	lockers(t, func(t *testing.T, l Locker) {
+		ctx := context.Background()
+		first := NewInfo(testKey, "deploy", time.Hour)
+		if err := l.TryLock(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+
+		err := l.TryLock(ctx, NewInfo(testKey, "destroy", time.Hour))
+		var held *HeldError
+		if !errors.As(err, &held) {
+			t.Fatalf("second TryLock = %v, want a *HeldError", err)
+		}
+		if held.Info.ID != first.ID || held.Info.Operation != "deploy" {
+			t.Errorf("HeldError names %+v, want the first lock", held.Info)
+		}
+
+		// Locks of other stacks are independent
+		other := testKey
+		other.Stack = "Other"
+		if err := l.TryLock(ctx, NewInfo(other, "deploy", time.Hour)); err != nil {
+			t.Errorf("TryLock of another stack: %v", err)
+		}
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	lockers(t, func(t *testing.T, l Locker) {
+		ctx := context.Background()
+		stale := NewInfo(testKey, "deploy", -time.Second)
+		if err := l.TryLock(ctx, stale); err != nil {
+			t.Fatal(err)
+		}
+
+		// An expired lease is taken over, and its former holder has lost it
+		next := NewInfo(testKey, "deploy", time.Hour)
+		if err := l.TryLock(ctx, next); err != nil {
+			t.Fatalf("TryLock over an expired lock: %v", err)
+		}
+		if err := l.Renew(ctx, stale); !errors.Is(err, ErrLost) {
+			t.Errorf("Renew of the expired lock = %v, want ErrLost", err)
+		}
+		if err := l.Unlock(ctx, stale); !errors.Is(err, ErrLost) {
+			t.Errorf("Unlock of the expired lock = %v, want ErrLost", err)
+		}
+
+		// Renewing moves the expiry of a held lock
+		next.ExpiresAt = next.ExpiresAt.Add(time.Hour)
+		if err := l.Renew(ctx, next); err != nil {
+			t.Fatalf("Renew: %v", err)
+		}
+		current, err := l.Inspect(ctx, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current == nil || !current.ExpiresAt.Equal(next.ExpiresAt) {
+			t.Errorf("expiry after Renew = %+v, want %s", current, next.ExpiresAt)
+		}
+	})
+}
+
+func TestRelease(t *testing.T) {
+	lockers(t, func(t *testing.T, l Locker) {
+		ctx := context.Background()
+		first := NewInfo(testKey, "deploy", time.Hour)
+		if err := l.TryLock(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Unlock(ctx, first); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+		if current, err := l.Inspect(ctx, testKey); err != nil || current != nil {
+			t.Errorf("Inspect after Unlock = %+v, %v; want no lock", current, err)
+		}
+
+		second := NewInfo(testKey, "deploy", time.Hour)
+		if err := l.TryLock(ctx, second); err != nil {
+			t.Fatalf("TryLock after Unlock: %v", err)
+		}
+		// A released acquisition cannot release the lock of the next holder
+		if err := l.Unlock(ctx, first); !errors.Is(err, ErrLost) {
+			t.Errorf("second Unlock = %v, want ErrLost", err)
+		}
+
+		if err := l.ForceUnlock(ctx, testKey); err != nil {
+			t.Fatalf("ForceUnlock: %v", err)
+		}
+		locks, err := l.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(locks) != 0 {
+			t.Errorf("List after ForceUnlock = %+v, want none", locks)
+		}
+	})
+}
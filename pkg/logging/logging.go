@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Format selects the log output format
+type Format string
+
+const (
+	// FormatText writes key=value lines
+	FormatText Format = "text"
+	// FormatJSON writes one JSON object per line
+	FormatJSON Format = "json"
+)
+
+// New creates a logger writing to w in the given format, discarding records below level
+func New(w io.Writer, format Format, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (use text or json)", format)
+	}
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (use debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// OrDefault returns the logger, or the default logger if it is nil
+func OrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// LineWriter is an io.Writer that logs every line written to it, e.g. the output of
+// a subprocess. Carriage returns, used by progress output, also end a line.
+type LineWriter struct {
+	logger *slog.Logger
+	level  slog.Level
+	onLine func(string)
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewLineWriter creates a writer logging lines at level, tagged with their source
+func NewLineWriter(logger *slog.Logger, level slog.Level, source string) *LineWriter {
+	return &LineWriter{
+		logger: OrDefault(logger).With("source", source),
+		level:  level,
+	}
+}
+
+// OnLine registers a function called with every logged line
+func (w *LineWriter) OnLine(fn func(line string)) *LineWriter {
+	w.onLine = fn
+	return w
+}
+
+// Write logs every complete line and buffers the rest
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		data := w.buf.Bytes()
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.log(string(data[:i]))
+		w.buf.Next(i + 1)
+	}
+	return len(p), nil
+}
+
+// Close logs any remaining partial line
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.log(w.buf.String())
+		w.buf.Reset()
+	}
+	return nil
+}
+
+// log logs a single line, skipping blank ones
+func (w *LineWriter) log(line string) {
+	line = strings.TrimRight(line, " \t")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	w.logger.Log(context.Background(), w.level, line)
+	if w.onLine != nil {
+		w.onLine(line)
+	}
+}
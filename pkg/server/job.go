@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"cdk-deployer/pkg/cdk"
+	"cdk-deployer/pkg/event"
+	"cdk-deployer/pkg/git"
+)
+
+// JobType is the operation a job performs
+type JobType string
+
+const (
+	// JobSynth synthesizes the app and reports its stacks
+	JobSynth JobType = "synth"
+	// JobDeploy deploys the selected stacks
+	JobDeploy JobType = "deploy"
+	// JobDrift detects drift of the selected stacks
+	JobDrift JobType = "drift"
+	// JobDestroy deletes the selected stacks
+	JobDestroy JobType = "destroy"
+)
+
+// JobStatus is the lifecycle state of a job
+type JobStatus string
+
+const (
+	// StatusQueued jobs wait for a free worker
+	StatusQueued JobStatus = "queued"
+	// StatusRunning jobs are being executed
+	StatusRunning JobStatus = "running"
+	// StatusSucceeded jobs finished without error
+	StatusSucceeded JobStatus = "succeeded"
+	// StatusFailed jobs finished with an error
+	StatusFailed JobStatus = "failed"
+	// StatusCancelled jobs were cancelled before they finished
+	StatusCancelled JobStatus = "cancelled"
+)
+
+// Done reports whether the status is final
+func (s JobStatus) Done() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCancelled
+}
+
+// JobRequest is the body of a job submission
+type JobRequest struct {
+	Type JobType `json:"type"`
+	// Repo is the URL of the git repository containing the CDK app
+	Repo string `json:"repo"`
+	// Ref is the branch, tag or commit to check out
+	Ref string `json:"ref,omitempty"`
+	// Path is the directory of the CDK app within the repository
+	Path string `json:"path,omitempty"`
+	// Stacks holds stack names or glob patterns; empty selects every stack, except for
+	// destroy jobs, which require them
+	Stacks []string `json:"stacks,omitempty"`
+	// Exclude holds stack names or glob patterns to leave out
+	Exclude []string `json:"exclude,omitempty"`
+	// Exclusively skips the automatic inclusion of dependencies
+	Exclusively bool `json:"exclusively,omitempty"`
+	// Context holds CDK context values
+	Context map[string]string `json:"context,omitempty"`
+	// Options tune the deployment
+	Options JobOptions `json:"options,omitempty"`
+}
+
+// JobOptions are the deployment settings of a job
+type JobOptions struct {
+	// Concurrency is the number of stacks deployed in parallel
+	Concurrency int `json:"concurrency,omitempty"`
+	// SynthMode is native, cli or auto
+	SynthMode cdk.SynthMode `json:"synthMode,omitempty"`
+	// SynthTimeout and StackTimeout are durations such as 10m
+	SynthTimeout string `json:"synthTimeout,omitempty"`
+	StackTimeout string `json:"stackTimeout,omitempty"`
+	// Tags are applied to every stack
+	Tags map[string]string `json:"tags,omitempty"`
+	// StackOptions maps stack name glob patterns to deployment options
+	StackOptions map[string]cdk.StackOptions `json:"stackOptions,omitempty"`
+	// ContinueRollback continues rollbacks stuck in UPDATE_ROLLBACK_FAILED
+	ContinueRollback bool `json:"continueRollback,omitempty"`
+	// RecreateFailed deletes and recreates stacks left in ROLLBACK_COMPLETE or
+	// REVIEW_IN_PROGRESS
+	RecreateFailed bool `json:"recreateFailed,omitempty"`
+}
+
+// Validate checks the request before it is queued
+func (r *JobRequest) Validate() error {
+	switch r.Type {
+	case JobSynth, JobDeploy, JobDrift, JobDestroy:
+	default:
+		return fmt.Errorf("invalid job type %q (use synth, deploy, drift or destroy)", r.Type)
+	}
+	if r.Repo == "" {
+		return fmt.Errorf("repo is required")
+	}
+	if r.Type == JobDestroy && len(r.Stacks) == 0 {
+		return fmt.Errorf("destroy jobs require the stacks to destroy")
+	}
+	if _, err := git.ParseURL(r.Repo); err != nil {
+		return err
+	}
+	if r.Path != "" && !filepath.IsLocal(r.Path) {
+		return fmt.Errorf("path %q must be relative to the repository root", r.Path)
+	}
+	if r.Options.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	if err := r.Options.SynthMode.Validate(); err != nil {
+		return err
+	}
+	for _, d := range []string{r.Options.SynthTimeout, r.Options.StackTimeout} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", d, err)
+		}
+	}
+	for pattern, opts := range r.Options.StackOptions {
+		if err := opts.Validate(); err != nil {
+			return fmt.Errorf("stackOptions.%s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// duration parses an optional duration validated by Validate
+func duration(s string) time.Duration {
+	d, _ := time.ParseDuration(s)
+	return d
+}
+
+// JobResult is the outcome of a finished job
+type JobResult struct {
+	// Signer is the trusted signer of the deployed commit or tag, when the server
+	// verifies signatures
+	Signer *event.Signer `json:"signer,omitempty"`
+	// Stacks are the selected stacks
+	Stacks []string `json:"stacks"`
+	// Deployments are set for deploy jobs
+	Deployments []DeploymentResult `json:"deployments,omitempty"`
+	// Drift is set for drift jobs
+	Drift []DriftSummary `json:"drift,omitempty"`
+}
+
+// DeploymentResult is the outcome of deploying a stack
+type DeploymentResult struct {
+	StackName string            `json:"stackName"`
+	StackID   string            `json:"stackId"`
+	Status    string            `json:"status"`
+	Outputs   map[string]string `json:"outputs,omitempty"`
+}
+
+// DriftSummary is the drift status of a stack
+type DriftSummary struct {
+	StackName        string   `json:"stackName"`
+	DriftStatus      string   `json:"driftStatus"`
+	DriftedResources []string `json:"driftedResources,omitempty"`
+}
+
+// Job is a submitted operation and its outcome
+type Job struct {
+	ID         string          `json:"id"`
+	Request    JobRequest      `json:"request"`
+	Status     JobStatus       `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+
+	// Trigger is set for jobs created by a push webhook
+	Trigger *Trigger `json:"trigger,omitempty"`
+	// Key serializes jobs deploying the same branch; empty for API jobs
+	Key string `json:"key,omitempty"`
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// zeroCommit is the commit of a deleted branch in push payloads
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// branchName returns the branch of a refs/heads/ ref, or "" for other refs
+func branchName(ref string) string {
+	branch, ok := strings.CutPrefix(ref, "refs/heads/")
+	if !ok {
+		return ""
+	}
+	return branch
+}
+
+// validHMAC checks a hex-encoded HMAC-SHA256 signature with an optional sha256= prefix
+func validHMAC(signature string, body []byte, secret string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || signature == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// githubProvider handles GitHub push webhooks signed in X-Hub-Signature-256
+type githubProvider struct{}
+
+// githubPush is the part of a GitHub push payload that is used
+type githubPush struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Pusher struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+}
+
+func (githubProvider) verify(r *http.Request, body []byte, secret string) bool {
+	return validHMAC(r.Header.Get("X-Hub-Signature-256"), body, secret)
+}
+
+func (githubProvider) parse(r *http.Request, body []byte) ([]push, error) {
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		return nil, nil
+	}
+
+	// Webhooks may be configured to send the payload as a form field
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid form payload: %w", err)
+		}
+		body = []byte(form.Get("payload"))
+	}
+
+	var p githubPush
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid GitHub push payload: %w", err)
+	}
+	branch := branchName(p.Ref)
+	if branch == "" || p.Deleted || p.After == zeroCommit {
+		return nil, nil
+	}
+
+	return []push{{
+		deliveryID: r.Header.Get("X-GitHub-Delivery"),
+		repository: p.Repository.FullName,
+		urls:       []string{p.Repository.CloneURL, p.Repository.SSHURL, p.Repository.HTMLURL},
+		branch:     branch,
+		commit:     p.After,
+		pusher:     p.Pusher.Name,
+	}}, nil
+}
+
+// gitlabProvider handles GitLab push webhooks authenticated by X-Gitlab-Token
+type gitlabProvider struct{}
+
+// gitlabPush is the part of a GitLab push payload that is used
+type gitlabPush struct {
+	ObjectKind   string `json:"object_kind"`
+	Ref          string `json:"ref"`
+	After        string `json:"after"`
+	UserUsername string `json:"user_username"`
+	Project      struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+		GitSSHURL         string `json:"git_ssh_url"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+}
+
+// verify compares the secret token GitLab sends verbatim; GitLab does not sign payloads
+func (gitlabProvider) verify(r *http.Request, _ []byte, secret string) bool {
+	token := r.Header.Get("X-Gitlab-Token")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+func (gitlabProvider) parse(r *http.Request, body []byte) ([]push, error) {
+	if r.Header.Get("X-Gitlab-Event") != "Push Hook" {
+		return nil, nil
+	}
+
+	var p gitlabPush
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid GitLab push payload: %w", err)
+	}
+	branch := branchName(p.Ref)
+	if p.ObjectKind != "push" || branch == "" || p.After == zeroCommit {
+		return nil, nil
+	}
+
+	return []push{{
+		deliveryID: r.Header.Get("X-Gitlab-Event-UUID"),
+		repository: p.Project.PathWithNamespace,
+		urls:       []string{p.Project.GitHTTPURL, p.Project.GitSSHURL, p.Project.WebURL},
+		branch:     branch,
+		commit:     p.After,
+		pusher:     p.UserUsername,
+	}}, nil
+}
+
+// bitbucketProvider handles Bitbucket Cloud push webhooks signed in X-Hub-Signature
+type bitbucketProvider struct{}
+
+// bitbucketPush is the part of a Bitbucket Cloud repo:push payload that is used
+type bitbucketPush struct {
+	Actor struct {
+		DisplayName string `json:"display_name"`
+	} `json:"actor"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		Links    struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	} `json:"repository"`
+	Push struct {
+		Changes []struct {
+			Closed bool `json:"closed"`
+			New    *struct {
+				Type   string `json:"type"`
+				Name   string `json:"name"`
+				Target struct {
+					Hash string `json:"hash"`
+				} `json:"target"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+}
+
+func (bitbucketProvider) verify(r *http.Request, body []byte, secret string) bool {
+	return validHMAC(r.Header.Get("X-Hub-Signature"), body, secret)
+}
+
+// parse returns a push per updated branch; Bitbucket batches several into one payload
+func (bitbucketProvider) parse(r *http.Request, body []byte) ([]push, error) {
+	if r.Header.Get("X-Event-Key") != "repo:push" {
+		return nil, nil
+	}
+
+	var p bitbucketPush
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid Bitbucket push payload: %w", err)
+	}
+
+	var pushes []push
+	for _, c := range p.Push.Changes {
+		if c.Closed || c.New == nil || c.New.Type != "branch" {
+			continue
+		}
+		pushes = append(pushes, push{
+			deliveryID: r.Header.Get("X-Request-UUID"),
+			repository: p.Repository.FullName,
+			urls:       []string{p.Repository.Links.HTML.Href},
+			branch:     c.New.Name,
+			commit:     c.New.Target.Hash,
+			pusher:     p.Actor.DisplayName,
+		})
+	}
+	return pushes, nil
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"cdk-deployer/pkg/cdk"
+	"cdk-deployer/pkg/config"
+	"cdk-deployer/pkg/event"
+	"cdk-deployer/pkg/git"
+	"cdk-deployer/pkg/history"
+	"cdk-deployer/pkg/logging"
+)
+
+// jobDir returns the directory holding the log, events and checkout of a job
+func (s *Server) jobDir(id string) string {
+	return filepath.Join(s.dataDir, "jobs", id)
+}
+
+// logPath returns the log file of a job
+func (s *Server) logPath(id string) string {
+	return filepath.Join(s.jobDir(id), "job.log")
+}
+
+// eventsPath returns the event file of a job
+func (s *Server) eventsPath(id string) string {
+	return filepath.Join(s.jobDir(id), "events.jsonl")
+}
+
+// runJob executes a queued job and records its outcome
+func (s *Server) runJob(id string) {
+	// Jobs still queued at shutdown are resumed on the next start
+	if s.ctx.Err() != nil {
+		return
+	}
+	queued, err := s.store.Get(id)
+	if err != nil {
+		s.logger.Error("Failed to load job", "job", id, "error", err)
+		return
+	}
+	if queued.Status != StatusQueued || !s.acquire(queued.Key, id) {
+		return
+	}
+	defer s.release(queued.Key)
+
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	defer cancel(nil)
+
+	job, err := s.store.Update(id, func(j *Job) error {
+		if j.Status != StatusQueued {
+			return errNotQueued
+		}
+		now := s.now()
+		j.Status = StatusRunning
+		j.StartedAt = &now
+		return nil
+	})
+	if err != nil {
+		if err != errNotQueued {
+			s.logger.Error("Failed to start job", "job", id, "error", err)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(s.jobDir(id), 0o755); err != nil {
+		s.finishJob(id, nil, nil, fmt.Errorf("failed to create job directory: %w", err))
+		return
+	}
+	events, err := newStream(s.eventsPath(id))
+	if err != nil {
+		s.finishJob(id, nil, nil, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.running[id] = &runningJob{cancel: cancel, events: events}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, id)
+		s.mu.Unlock()
+	}()
+
+	recorder := history.NewRecorder(history.Operation(job.Request.Type))
+	recorder.SetJob(id)
+	recorder.SetSource(git.RedactURL(job.Request.Repo), job.Request.Ref, "")
+	recorder.SetApp(job.Request.Path, job.Request.Context)
+
+	s.logger.Info("Job started", "job", id, "type", job.Request.Type, "repo", git.RedactURL(job.Request.Repo))
+	result, err := s.execute(ctx, job, event.Multi(events.handler(), recorder.Handler()), recorder)
+	switch {
+	case errors.Is(context.Cause(ctx), errCancelled):
+		err = errCancelled
+	case s.ctx.Err() != nil:
+		err = errShutdown
+	}
+	s.finishJob(id, events, result, err)
+	s.recordJob(recorder, err)
+}
+
+// recordJob appends the record of a finished job to the history
+func (s *Server) recordJob(recorder *history.Recorder, jobErr error) {
+	if s.history == nil {
+		return
+	}
+	record := recorder.Finish(jobErr)
+	if jobErr == errCancelled || jobErr == errShutdown {
+		record.Status = history.StatusCancelled
+	}
+	if err := s.history.Append(context.Background(), record); err != nil {
+		s.logger.Error("Failed to record job in the history", "job", record.Job, "error", err)
+	}
+}
+
+// acquire reserves the key of a job so that jobs deploying the same branch run one at
+// a time. When the key is taken, the job is deferred until it is released.
+func (s *Server) acquire(key, id string) bool {
+	if key == "" {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[key] {
+		s.deferred[key] = id
+		return false
+	}
+	s.active[key] = true
+	return true
+}
+
+// release frees the key of a finished job and queues the job deferred on it
+func (s *Server) release(key string) {
+	if key == "" {
+		return
+	}
+	s.mu.Lock()
+	delete(s.active, key)
+	next, ok := s.deferred[key]
+	delete(s.deferred, key)
+	s.mu.Unlock()
+
+	if ok {
+		go func() {
+			select {
+			case s.queue <- next:
+			case <-s.ctx.Done():
+			}
+		}()
+	}
+}
+
+// finishJob stores the final status of a job and closes its event stream
+func (s *Server) finishJob(id string, events *stream, result *JobResult, jobErr error) {
+	job, err := s.store.Update(id, func(j *Job) error {
+		now := s.now()
+		j.FinishedAt = &now
+		switch {
+		case jobErr == nil:
+			j.Status = StatusSucceeded
+		case jobErr == errCancelled || jobErr == errShutdown:
+			j.Status = StatusCancelled
+			j.Error = jobErr.Error()
+		default:
+			j.Status = StatusFailed
+			j.Error = jobErr.Error()
+		}
+		if result != nil {
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			j.Result = data
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Failed to record job result", "job", id, "error", err)
+		return
+	}
+
+	if events != nil {
+		events.close(job.Status, job.Error)
+	}
+	s.logger.Info("Job finished", "job", id, "status", job.Status, "error", job.Error)
+}
+
+// execute clones the repository of a job and runs the requested operation
+func (s *Server) execute(ctx context.Context, job *Job, events event.Handler, recorder *history.Recorder) (*JobResult, error) {
+	req := job.Request
+
+	logFile, err := os.OpenFile(s.logPath(job.ID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job log: %w", err)
+	}
+	defer logFile.Close()
+	logger, err := logging.New(logFile, logging.FormatText, slog.LevelDebug)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.executeLogged(ctx, req, filepath.Join(s.jobDir(job.ID), "work"), logger, events, recorder)
+	if err != nil {
+		logger.Error("Job failed", "error", err)
+	}
+	return result, err
+}
+
+// executeLogged runs a job with its own logger and checkout directory
+func (s *Server) executeLogged(ctx context.Context, req JobRequest, workDir string, logger *slog.Logger, events event.Handler, recorder *history.Recorder) (*JobResult, error) {
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			logger.Warn("Failed to clean up", "path", workDir, "error", err)
+		}
+	}()
+
+	var signer *event.Signer
+	cloneEvents := event.Multi(events, func(e event.Event) {
+		if e.Type == event.SourceVerified {
+			signer = e.Signer
+		}
+	})
+	cloneOpts := append([]git.CloneOption{git.WithRef(req.Ref), git.WithLogger(logger), git.WithEventHandler(cloneEvents)}, s.cloneOptions...)
+	repoPath, err := git.CloneRepositoryContext(ctx, req.Repo, workDir, cloneOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	commit, err := git.HeadCommit(repoPath)
+	if err != nil {
+		logger.Warn("Failed to resolve the checked out commit", "error", err)
+	}
+	recorder.SetSource(git.RedactURL(req.Repo), req.Ref, commit)
+
+	projectPath := repoPath
+	if req.Path != "" {
+		projectPath = filepath.Join(repoPath, req.Path)
+		if _, err := os.Stat(projectPath); err != nil {
+			return nil, fmt.Errorf("path %s not found in repository: %w", req.Path, err)
+		}
+	}
+
+	opts := req.Options
+	settings := config.Settings{Tags: opts.Tags, Stacks: opts.StackOptions}
+	cdkOpts := []cdk.Option{
+		cdk.WithLogger(logger),
+		cdk.WithEventHandler(events),
+		cdk.WithSynthMode(opts.SynthMode),
+		cdk.WithSynthTimeout(duration(opts.SynthTimeout)),
+		cdk.WithContext(req.Context),
+		cdk.WithDeployerOptions(s.deployerOptions...),
+		cdk.WithDeployerOptions(
+			cdk.WithRecovery(cdk.RecoveryOptions{
+				ContinueRollback:     opts.ContinueRollback,
+				RecreateFailedStacks: opts.RecreateFailed,
+				CancelOnInterrupt:    true,
+			}),
+			cdk.WithStackOptions(settings.StackOptions),
+			cdk.WithConcurrency(opts.Concurrency),
+			cdk.WithTimeouts(cdk.Timeouts{Stack: duration(opts.StackTimeout)}),
+		),
+	}
+	cdkApp := cdk.New(projectPath, append(cdkOpts, s.cdkOptions...)...)
+
+	if s.history != nil {
+		identity, region, err := cdkApp.CallerIdentity(ctx)
+		if err != nil {
+			logger.Warn("Failed to resolve the AWS identity for the history record", "error", err)
+		}
+		recorder.SetIdentity(identity, region)
+	}
+
+	if err := cdkApp.InitializeContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize CDK project: %w", err)
+	}
+	if _, err := cdkApp.SynthContext(ctx); err != nil {
+		return nil, fmt.Errorf("synthesis failed: %w", err)
+	}
+	infos, err := cdkApp.Stacks()
+	if err != nil {
+		return nil, err
+	}
+	stacks, err := cdk.SelectStacks(infos, cdk.StackSelection{
+		Include:     req.Stacks,
+		Exclude:     req.Exclude,
+		Exclusively: req.Exclusively,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	recorder.SetStacks(stacks)
+
+	result := &JobResult{Signer: signer, Stacks: stacks}
+	switch req.Type {
+	case JobDeploy:
+		deployed, err := cdkApp.Deploy(ctx, stacks)
+		recorder.AddDeployResults(deployed)
+		if templates, ok := s.history.(history.TemplateStore); ok {
+			if err := recorder.SnapshotTemplates(ctx, templates, deployed, cdkApp.TemplateBody); err != nil {
+				logger.Warn("Failed to keep template snapshots for rollbacks", "error", err)
+			}
+		}
+		for _, r := range deployed {
+			d := DeploymentResult{StackName: r.StackName, StackID: r.StackID, Status: r.Status}
+			for _, o := range r.Outputs {
+				if d.Outputs == nil {
+					d.Outputs = make(map[string]string)
+				}
+				d.Outputs[o.Key] = o.Value
+			}
+			result.Deployments = append(result.Deployments, d)
+		}
+		if err != nil {
+			return result, fmt.Errorf("deployment failed: %w", err)
+		}
+
+	case JobDrift:
+		drifts, err := cdkApp.DetectDrift(ctx, stacks)
+		if err != nil {
+			return result, fmt.Errorf("drift detection failed: %w", err)
+		}
+		for _, r := range drifts {
+			summary := DriftSummary{StackName: r.StackName, DriftStatus: r.DriftStatus}
+			for _, dr := range r.DriftedResources {
+				if dr.Reportable() {
+					summary.DriftedResources = append(summary.DriftedResources, dr.LogicalID)
+				}
+			}
+			result.Drift = append(result.Drift, summary)
+		}
+
+	case JobDestroy:
+		if err := cdkApp.Destroy(ctx, stacks); err != nil {
+			return result, fmt.Errorf("destroy failed: %w", err)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,605 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cdk-deployer/pkg/cdk"
+	"cdk-deployer/pkg/git"
+	"cdk-deployer/pkg/history"
+	"cdk-deployer/pkg/logging"
+)
+
+var (
+	// errNotQueued is returned when a job picked by a worker is no longer queued
+	errNotQueued = errors.New("job is not queued")
+	// errCancelled is the cause of jobs cancelled through the API
+	errCancelled = errors.New("job cancelled")
+	// errQueueFull is returned when no more jobs can be queued
+	errQueueFull = errors.New("job queue is full")
+	// errShutdown is the error of jobs cancelled by a server shutdown
+	errShutdown = errors.New("job cancelled by a server shutdown")
+)
+
+// Server runs synth, deploy, drift and destroy jobs submitted over a REST API
+type Server struct {
+	dataDir         string
+	store           *Store
+	tokens          [][]byte
+	workers         int
+	queueSize       int
+	logger          *slog.Logger
+	webhooks        *WebhookConfig
+	deployerOptions []cdk.DeployerOption
+	cloneOptions    []git.CloneOption
+	cdkOptions      []cdk.Option
+	history         history.Store
+	now             func() time.Time
+
+	queue chan string
+	ctx   context.Context
+
+	mu      sync.Mutex
+	running map[string]*runningJob
+	// active holds the keys of running jobs; deferred the latest job waiting for each
+	active   map[string]bool
+	deferred map[string]string
+}
+
+// runningJob is a job currently executed by a worker
+type runningJob struct {
+	cancel context.CancelCauseFunc
+	events *stream
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithTokens sets the bearer tokens accepted by the API
+func WithTokens(tokens ...string) Option {
+	return func(s *Server) {
+		for _, t := range tokens {
+			if t = strings.TrimSpace(t); t != "" {
+				s.tokens = append(s.tokens, []byte(t))
+			}
+		}
+	}
+}
+
+// WithWorkers sets the number of jobs run in parallel (default 2)
+func WithWorkers(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.workers = n
+		}
+	}
+}
+
+// WithQueueSize sets how many jobs may wait for a worker before submissions are rejected (default 100)
+func WithQueueSize(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.queueSize = n
+		}
+	}
+}
+
+// WithLogger sets the logger of the server; job output goes to the job logs
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithWebhooks enables push webhooks at /webhooks/{provider}
+func WithWebhooks(cfg *WebhookConfig) Option {
+	return func(s *Server) {
+		s.webhooks = cfg
+	}
+}
+
+// WithHistory records every job in an audit history
+func WithHistory(store history.Store) Option {
+	return func(s *Server) {
+		s.history = store
+	}
+}
+
+// WithDeployerOptions adds deployer options to every job, e.g. stack locking
+func WithDeployerOptions(opts ...cdk.DeployerOption) Option {
+	return func(s *Server) {
+		s.deployerOptions = append(s.deployerOptions, opts...)
+	}
+}
+
+// WithCDKOptions adds options to the CDK app of every job, e.g. a sandbox
+func WithCDKOptions(opts ...cdk.Option) Option {
+	return func(s *Server) {
+		s.cdkOptions = append(s.cdkOptions, opts...)
+	}
+}
+
+// WithCloneOptions adds options to the clone of every job, e.g. a clone cache
+func WithCloneOptions(opts ...git.CloneOption) Option {
+	return func(s *Server) {
+		s.cloneOptions = append(s.cloneOptions, opts...)
+	}
+}
+
+// New creates a server keeping its job database and job logs in dataDir
+func New(dataDir string, opts ...Option) (*Server, error) {
+	s := &Server{
+		dataDir:   dataDir,
+		workers:   2,
+		queueSize: 100,
+		now:       time.Now,
+		running:   make(map[string]*runningJob),
+		active:    make(map[string]bool),
+		deferred:  make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.logger = logging.OrDefault(s.logger)
+
+	if len(s.tokens) == 0 {
+		return nil, fmt.Errorf("at least one API token is required")
+	}
+	if err := os.MkdirAll(filepath.Join(dataDir, "jobs"), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	store, err := OpenStore(filepath.Join(dataDir, "jobs.db"))
+	if err != nil {
+		return nil, err
+	}
+	s.store = store
+	s.queue = make(chan string, s.queueSize)
+	return s, nil
+}
+
+// Close closes the job store
+func (s *Server) Close() error {
+	return s.store.Close()
+}
+
+// ListenAndServe starts the workers and serves the API on addr until ctx is cancelled.
+// Running jobs are cancelled on shutdown; queued jobs are resumed on the next start.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	s.ctx = ctx
+
+	pending, err := s.recover()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+	go func() {
+		for _, id := range pending {
+			select {
+			case s.queue <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		// Event streams end when the server shuts down
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("API server listening", "addr", addr, "workers", s.workers)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+	}
+
+	wg.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// work runs queued jobs until ctx is cancelled
+func (s *Server) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-s.queue:
+			s.runJob(id)
+		}
+	}
+}
+
+// recover fails jobs that were running when the server stopped and returns the
+// queued ones, oldest first
+func (s *Server) recover() ([]string, error) {
+	jobs, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for i := len(jobs) - 1; i >= 0; i-- {
+		job := jobs[i]
+		switch job.Status {
+		case StatusQueued:
+			pending = append(pending, job.ID)
+		case StatusRunning:
+			_, err := s.store.Update(job.ID, func(j *Job) error {
+				now := s.now()
+				j.Status = StatusFailed
+				j.Error = "interrupted by a server restart"
+				j.FinishedAt = &now
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to recover job %s: %w", job.ID, err)
+			}
+			s.logger.Warn("Job was interrupted by a restart", "job", job.ID)
+		}
+	}
+	if len(pending) > 0 {
+		s.logger.Info("Resuming queued jobs", "count", len(pending))
+	}
+	return pending, nil
+}
+
+// Handler returns the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "ok\n")
+	})
+	mux.Handle("POST /jobs", s.authorize(s.handleSubmit))
+	mux.Handle("GET /jobs", s.authorize(s.handleList))
+	mux.Handle("GET /jobs/{id}", s.authorize(s.handleGet))
+	mux.Handle("GET /jobs/{id}/logs", s.authorize(s.handleLogs))
+	mux.Handle("GET /jobs/{id}/events", s.authorize(s.handleEvents))
+	mux.Handle("POST /jobs/{id}/cancel", s.authorize(s.handleCancel))
+	if s.webhooks != nil {
+		mux.HandleFunc("POST /webhooks/{provider}", s.handleWebhook)
+	}
+	return mux
+}
+
+// authorize rejects requests without a valid bearer token
+func (s *Server) authorize(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !s.validToken([]byte(token)) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cdk-deployer"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// validToken compares a token against every configured token in constant time
+func (s *Server) validToken(token []byte) bool {
+	valid := 0
+	for _, t := range s.tokens {
+		valid |= subtle.ConstantTimeCompare(token, t)
+	}
+	return valid == 1
+}
+
+// handleSubmit validates and queues a job
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid job request: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job, err := s.submit(req, nil)
+	if errors.Is(err, errQueueFull) {
+		writeError(w, http.StatusServiceUnavailable, "job queue is full; try again later")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// submit stores and queues a job, letting prepare set fields before it is stored
+func (s *Server) submit(req JobRequest, prepare func(*Job)) (*Job, error) {
+	job, _, err := s.submitUnless(req, prepare, nil)
+	return job, err
+}
+
+// submitUnless is submit with its own store step, which returns the existing job
+// instead of storing the new one when they conflict
+func (s *Server) submitUnless(req JobRequest, prepare func(*Job), put func(*Job) (*Job, error)) (*Job, *Job, error) {
+	job := &Job{
+		ID:        newJobID(),
+		Request:   req,
+		Status:    StatusQueued,
+		CreatedAt: s.now(),
+	}
+	if prepare != nil {
+		prepare(job)
+	}
+	if put == nil {
+		if err := s.store.Put(job); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		existing, err := put(job)
+		if err != nil {
+			return nil, nil, err
+		}
+		if existing != nil {
+			return nil, existing, nil
+		}
+	}
+
+	select {
+	case s.queue <- job.ID:
+	default:
+		s.store.Update(job.ID, func(j *Job) error {
+			now := s.now()
+			j.Status = StatusFailed
+			j.Error = errQueueFull.Error()
+			j.FinishedAt = &now
+			return nil
+		})
+		return nil, nil, errQueueFull
+	}
+
+	s.logger.Info("Job queued", "job", job.ID, "type", req.Type, "repo", git.RedactURL(req.Repo), "ref", req.Ref)
+	return job, nil, nil
+}
+
+// handleList returns every job, newest first, optionally filtered by ?status=
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.store.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filtered := jobs[:0]
+		for _, j := range jobs {
+			if string(j.Status) == status {
+				filtered = append(filtered, j)
+			}
+		}
+		jobs = filtered
+	}
+	if jobs == nil {
+		jobs = []*Job{}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// handleGet returns a job
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleLogs returns the log of a job as plain text
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	f, err := os.Open(s.logPath(job.ID))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer f.Close()
+	io.Copy(w, f)
+}
+
+// handleEvents streams the events of a job as Server-Sent Events. Past events are
+// replayed first; a Last-Event-ID header skips the events already received.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	var lastSeq int
+	fmt.Sscan(r.Header.Get("Last-Event-ID"), &lastSeq)
+
+	var records []EventRecord
+	var live chan EventRecord
+	s.mu.Lock()
+	running := s.running[job.ID]
+	s.mu.Unlock()
+	if running != nil {
+		records, live = running.events.subscribe()
+		defer running.events.unsubscribe(live)
+	} else if job.Status.Done() {
+		var err error
+		if records, err = readEvents(s.eventsPath(job.ID)); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(rec EventRecord) {
+		if rec.Seq <= lastSeq {
+			return
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", rec.Seq, rec.Type, data)
+		lastSeq = rec.Seq
+	}
+	for _, rec := range records {
+		send(rec)
+	}
+	flusher.Flush()
+
+	if live == nil {
+		if !job.Status.Done() {
+			// Queued jobs have no events yet; the client reconnects until the job runs
+			fmt.Fprint(w, "retry: 5000\n\n")
+			flusher.Flush()
+		}
+		return
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case rec, ok := <-live:
+			if !ok {
+				return
+			}
+			send(rec)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleCancel cancels a queued or running job
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	s.mu.Lock()
+	running := s.running[id]
+	s.mu.Unlock()
+	if running != nil {
+		running.cancel(errCancelled)
+		s.logger.Info("Cancelling job", "job", id)
+		job, err := s.store.Get(id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	job, err := s.store.Update(id, func(j *Job) error {
+		if j.Status != StatusQueued {
+			return fmt.Errorf("job is %s and cannot be cancelled", j.Status)
+		}
+		now := s.now()
+		j.Status = StatusCancelled
+		j.Error = errCancelled.Error()
+		j.FinishedAt = &now
+		return nil
+	})
+	switch {
+	case errors.Is(err, errJobNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		s.logger.Info("Job cancelled", "job", id)
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// lookup loads the job named in the request path, writing a 404 when it does not exist
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*Job, bool) {
+	job, err := s.store.Get(r.PathValue("id"))
+	if errors.Is(err, errJobNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return job, true
+}
+
+// newJobID returns a random, time-ordered job ID
+func newJobID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(b))
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
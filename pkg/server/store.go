@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// jobsBucket holds the jobs by ID
+	jobsBucket = []byte("jobs")
+	// deliveriesBucket holds the time webhook deliveries were received, by delivery key
+	deliveriesBucket = []byte("deliveries")
+)
+
+// deliveryRetention is how long webhook deliveries are remembered for de-duplication
+const deliveryRetention = 7 * 24 * time.Hour
+
+// errJobNotFound is returned for unknown job IDs
+var errJobNotFound = errors.New("job not found")
+
+// errDuplicateDelivery is returned for webhook deliveries that were already recorded
+var errDuplicateDelivery = errors.New("duplicate delivery")
+
+// Store persists jobs in a BoltDB file so their status survives restarts
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens or creates the job database
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, deliveriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize job store: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put creates or replaces a job
+func (s *Store) Put(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+// Get returns a job by ID
+func (s *Store) Get(id string) (*Job, error) {
+	var job Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return errJobNotFound
+		}
+		return json.Unmarshal(data, &job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Update applies fn to a job and saves it in a single transaction
+func (s *Store) Update(id string, fn func(*Job) error) (*Job, error) {
+	var job Job
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return errJobNotFound
+		}
+		if err := json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+		if err := fn(&job); err != nil {
+			return err
+		}
+		updated, err := json.Marshal(&job)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List returns the jobs, newest first
+func (s *Store) List() ([]*Job, error) {
+	var jobs []*Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			var job Job
+			if err := json.Unmarshal(data, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, &job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// PutTriggered stores a job created by a webhook delivery. In a single transaction it
+// records the delivery, returning errDuplicateDelivery if it was seen before, and
+// stores the job unless an existing job conflicts with it, returning that job instead.
+// An empty delivery key is not recorded.
+func (s *Store) PutTriggered(job *Job, delivery string, now time.Time, conflicts func(*Job) bool) (*Job, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job: %w", err)
+	}
+	var existing *Job
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if delivery != "" {
+			isNew, err := recordDelivery(tx, delivery, now)
+			if err != nil {
+				return fmt.Errorf("failed to record webhook delivery: %w", err)
+			}
+			if !isNew {
+				return errDuplicateDelivery
+			}
+		}
+
+		bucket := tx.Bucket(jobsBucket)
+		err := bucket.ForEach(func(_, data []byte) error {
+			var j Job
+			if err := json.Unmarshal(data, &j); err != nil {
+				return err
+			}
+			if existing == nil && conflicts(&j) {
+				existing = &j
+			}
+			return nil
+		})
+		if err != nil || existing != nil {
+			return err
+		}
+		return bucket.Put([]byte(job.ID), data)
+	})
+	if errors.Is(err, errDuplicateDelivery) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store job: %w", err)
+	}
+	return existing, nil
+}
+
+// recordDelivery remembers a webhook delivery and reports whether it is new.
+// Deliveries older than the retention period are forgotten.
+func recordDelivery(tx *bolt.Tx, key string, now time.Time) (bool, error) {
+	bucket := tx.Bucket(deliveriesBucket)
+
+	var expired [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		var received time.Time
+		if err := received.UnmarshalText(v); err != nil || now.Sub(received) > deliveryRetention {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, k := range expired {
+		if err := bucket.Delete(k); err != nil {
+			return false, err
+		}
+	}
+
+	if bucket.Get([]byte(key)) != nil {
+		return false, nil
+	}
+	stamp, err := now.MarshalText()
+	if err != nil {
+		return false, err
+	}
+	return true, bucket.Put([]byte(key), stamp)
+}
+
+// ForgetDelivery removes a recorded webhook delivery
+func (s *Store) ForgetDelivery(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deliveriesBucket).Delete([]byte(key))
+	})
+}
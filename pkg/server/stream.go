@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"cdk-deployer/pkg/event"
+)
+
+// jobFinished is the type of the last record of every job event stream
+const jobFinished event.Type = "job.finished"
+
+// EventRecord is the JSON form of an event in a job event stream
+type EventRecord struct {
+	// Seq numbers the events of a job from 1
+	Seq       int             `json:"seq"`
+	Type      event.Type      `json:"type"`
+	Time      time.Time       `json:"time"`
+	Stack     string          `json:"stack,omitempty"`
+	Source    string          `json:"source,omitempty"`
+	Operation string          `json:"operation,omitempty"`
+	Status    string          `json:"status,omitempty"`
+	Message   string          `json:"message,omitempty"`
+	Stacks    []string        `json:"stacks,omitempty"`
+	Duration  string          `json:"duration,omitempty"`
+	Resource  *ResourceRecord `json:"resource,omitempty"`
+	Drift     *DriftSummary   `json:"drift,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// ResourceRecord is the JSON form of a CloudFormation resource event
+type ResourceRecord struct {
+	LogicalID    string    `json:"logicalId"`
+	PhysicalID   string    `json:"physicalId,omitempty"`
+	ResourceType string    `json:"resourceType"`
+	Status       string    `json:"status"`
+	StatusReason string    `json:"statusReason,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// newEventRecord converts an event to its JSON form
+func newEventRecord(e event.Event) EventRecord {
+	r := EventRecord{
+		Type:      e.Type,
+		Time:      e.Time,
+		Stack:     e.Stack,
+		Source:    e.Source,
+		Operation: e.Operation,
+		Status:    e.Status,
+		Message:   e.Message,
+		Stacks:    e.Stacks,
+	}
+	if e.Duration > 0 {
+		r.Duration = e.Duration.String()
+	}
+	if e.Resource != nil {
+		r.Resource = &ResourceRecord{
+			LogicalID:    e.Resource.LogicalID,
+			PhysicalID:   e.Resource.PhysicalID,
+			ResourceType: e.Resource.ResourceType,
+			Status:       e.Resource.Status,
+			StatusReason: e.Resource.StatusReason,
+			Timestamp:    e.Resource.Timestamp,
+		}
+	}
+	if e.Drift != nil {
+		r.Drift = &DriftSummary{
+			StackName:        e.Stack,
+			DriftStatus:      e.Drift.Status,
+			DriftedResources: e.Drift.DriftedResources,
+		}
+	}
+	if e.Err != nil {
+		r.Error = e.Err.Error()
+	}
+	return r
+}
+
+// stream records the events of a running job to a JSON lines file and fans them out
+// to subscribers. Subscribers receive every record published after they subscribed;
+// earlier records are returned by subscribe so that nothing is missed in between.
+type stream struct {
+	mu      sync.Mutex
+	file    *os.File
+	records []EventRecord
+	subs    map[chan EventRecord]struct{}
+	closed  bool
+}
+
+// newStream creates the event file of a job
+func newStream(path string) (*stream, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event file: %w", err)
+	}
+	return &stream{file: f, subs: make(map[chan EventRecord]struct{})}, nil
+}
+
+// handler returns an event handler publishing to the stream
+func (s *stream) handler() event.Handler {
+	return func(e event.Event) {
+		s.publish(newEventRecord(e))
+	}
+}
+
+// publish numbers, stores and delivers a record. Slow subscribers miss records
+// rather than blocking the job.
+func (s *stream) publish(r EventRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	r.Seq = len(s.records) + 1
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	s.records = append(s.records, r)
+	if data, err := json.Marshal(r); err == nil {
+		s.file.Write(append(data, '\n'))
+	}
+
+	for ch := range s.subs {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+// subscribe returns the records published so far and a channel receiving the
+// following ones. The channel is closed when the stream is closed.
+func (s *stream) subscribe() ([]EventRecord, chan EventRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan EventRecord, 256)
+	if s.closed {
+		close(ch)
+	} else {
+		s.subs[ch] = struct{}{}
+	}
+	return append([]EventRecord(nil), s.records...), ch
+}
+
+// unsubscribe stops delivery to a subscriber
+func (s *stream) unsubscribe(ch chan EventRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[ch]; ok {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+// close publishes the final job status and ends every subscription
+func (s *stream) close(status JobStatus, errMsg string) {
+	s.publish(EventRecord{Type: jobFinished, Status: string(status), Error: errMsg})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ch := range s.subs {
+		close(ch)
+	}
+	s.subs = nil
+	s.file.Close()
+}
+
+// readEvents reads the recorded events of a finished job
+func readEvents(path string) ([]EventRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event file: %w", err)
+	}
+	defer f.Close()
+
+	var records []EventRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r EventRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
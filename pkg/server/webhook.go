@@ -0,0 +1,367 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"cdk-deployer/pkg/cdk"
+)
+
+// WebhookConfig maps pushed repositories and branches to deploy jobs
+type WebhookConfig struct {
+	Rules []WebhookRule `json:"rules"`
+}
+
+// WebhookRule deploys pushes to matching branches of a repository
+type WebhookRule struct {
+	// Name identifies the rule; pushes to the same branch supersede each other per rule.
+	// Defaults to the repository.
+	Name string `json:"name,omitempty"`
+	// Provider restricts the rule to github, gitlab or bitbucket; empty accepts all
+	Provider string `json:"provider,omitempty"`
+	// Repo is the repository as owner/name or as a clone or web URL
+	Repo string `json:"repo"`
+	// Branch is a branch name or glob pattern, e.g. main or release/*
+	Branch string `json:"branch"`
+	// Secret validates the webhook signature; SecretEnv names an environment variable holding it
+	Secret    string `json:"secret,omitempty"`
+	SecretEnv string `json:"secretEnv,omitempty"`
+	// CloneURL overrides the URL the pushed commit is cloned from
+	CloneURL string `json:"cloneUrl,omitempty"`
+	// Job is the template of the triggered job; repo and ref are set from the push
+	Job JobRequest `json:"job"`
+}
+
+// LoadWebhookConfig reads a webhook configuration file
+func LoadWebhookConfig(path string) (*WebhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook config: %w", err)
+	}
+
+	var cfg WebhookConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook config: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// validate checks every rule and fills in defaults
+func (c *WebhookConfig) validate() error {
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("webhook config has no rules")
+	}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.Repo == "" || r.Branch == "" {
+			return fmt.Errorf("webhook rule %d: repo and branch are required", i+1)
+		}
+		if r.Provider != "" {
+			if _, ok := webhookProviders[r.Provider]; !ok {
+				return fmt.Errorf("webhook rule %d: unknown provider %q (use github, gitlab or bitbucket)", i+1, r.Provider)
+			}
+		}
+		if r.SecretEnv != "" {
+			r.Secret = os.Getenv(r.SecretEnv)
+		}
+		if r.Secret == "" {
+			return fmt.Errorf("webhook rule %d: a secret is required", i+1)
+		}
+		if r.Name == "" {
+			r.Name = r.Repo
+		}
+		if r.Job.Type == "" {
+			r.Job.Type = JobDeploy
+		}
+		// Repo and ref come from the push; validate the rest with placeholders
+		probe := r.Job
+		probe.Repo = "-"
+		if err := probe.Validate(); err != nil {
+			return fmt.Errorf("webhook rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Trigger records the push that created a job
+type Trigger struct {
+	Provider   string `json:"provider"`
+	DeliveryID string `json:"deliveryId,omitempty"`
+	Repository string `json:"repository"`
+	Branch     string `json:"branch"`
+	Commit     string `json:"commit"`
+	Pusher     string `json:"pusher,omitempty"`
+	Rule       string `json:"rule"`
+}
+
+// key identifies the branch a job deploys; jobs with the same key run one at a time
+// and newer pushes supersede queued ones
+func (t *Trigger) key() string {
+	return fmt.Sprintf("%s|%s|%s", t.Rule, t.Repository, t.Branch)
+}
+
+// push is a branch push parsed from a webhook payload
+type push struct {
+	deliveryID string
+	// repository is the owner/name path of the repository
+	repository string
+	// urls are the clone and web URLs of the repository
+	urls   []string
+	branch string
+	commit string
+	pusher string
+}
+
+// webhookProvider parses and authenticates the webhooks of a git hosting service
+type webhookProvider interface {
+	// verify checks the request signature or token against a secret
+	verify(r *http.Request, body []byte, secret string) bool
+	// parse returns the branch pushes of a payload; other events have none
+	parse(r *http.Request, body []byte) ([]push, error)
+}
+
+// webhookProviders are the supported providers by name
+var webhookProviders = map[string]webhookProvider{
+	"github":    githubProvider{},
+	"gitlab":    gitlabProvider{},
+	"bitbucket": bitbucketProvider{},
+}
+
+// webhookResponse reports the jobs created for a delivery. Error is set when the
+// delivery failed after some of its jobs were already created.
+type webhookResponse struct {
+	Jobs    []string `json:"jobs"`
+	Skipped []string `json:"skipped,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// handleWebhook turns push webhooks into deploy jobs. Requests are authenticated by
+// the secret of the matching rules instead of a bearer token.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	provider, ok := webhookProviders[providerName]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown webhook provider %q", providerName))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read payload")
+		return
+	}
+
+	pushes, err := provider.parse(r, body)
+	if err != nil {
+		// Parse errors are only reported to callers signed with a rule secret
+		if !s.verifyAny(providerName, provider, r, body) {
+			writeError(w, http.StatusUnauthorized, "invalid webhook signature")
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := webhookResponse{Jobs: []string{}}
+	for _, p := range pushes {
+		for i := range s.webhooks.Rules {
+			rule := &s.webhooks.Rules[i]
+			if !rule.matches(providerName, p) || !provider.verify(r, body, rule.Secret) {
+				continue
+			}
+
+			id, reason, err := s.triggerJob(rule, providerName, p)
+			if err != nil {
+				// The jobs created so far are reported with the error; their deliveries
+				// are recorded, so a retry of the delivery only creates the rest
+				status := http.StatusInternalServerError
+				resp.Error = err.Error()
+				if errors.Is(err, errQueueFull) {
+					status = http.StatusServiceUnavailable
+					resp.Error = "job queue is full; try again later"
+				}
+				writeJSON(w, status, resp)
+				return
+			}
+			if id != "" {
+				resp.Jobs = append(resp.Jobs, id)
+			} else {
+				resp.Skipped = append(resp.Skipped, fmt.Sprintf("%s: %s", rule.Name, reason))
+			}
+		}
+	}
+	if len(resp.Jobs)+len(resp.Skipped) > 0 {
+		writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+
+	// Pings, other events and pushes no rule deploys are acknowledged when they are
+	// signed with the secret of any rule of the provider
+	if !s.verifyAny(providerName, provider, r, body) {
+		writeError(w, http.StatusUnauthorized, "invalid webhook signature")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// verifyAny reports whether the request is signed with the secret of any rule of a provider
+func (s *Server) verifyAny(providerName string, provider webhookProvider, r *http.Request, body []byte) bool {
+	for _, rule := range s.webhooks.Rules {
+		if (rule.Provider == "" || rule.Provider == providerName) && provider.verify(r, body, rule.Secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a rule deploys a push
+func (r *WebhookRule) matches(provider string, p push) bool {
+	if r.Provider != "" && r.Provider != provider {
+		return false
+	}
+	if !cdk.MatchGlob(r.Branch, p.branch) {
+		return false
+	}
+	want := normalizeRepo(r.Repo)
+	if want == strings.ToLower(p.repository) {
+		return true
+	}
+	for _, u := range p.urls {
+		if normalizeRepo(u) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerJob queues the job of a rule for a push. Deliveries already seen and commits
+// already deployed are skipped; queued jobs of older pushes to the branch are cancelled.
+func (s *Server) triggerJob(rule *WebhookRule, provider string, p push) (string, string, error) {
+	trigger := &Trigger{
+		Provider:   provider,
+		DeliveryID: p.deliveryID,
+		Repository: p.repository,
+		Branch:     p.branch,
+		Commit:     p.commit,
+		Pusher:     p.pusher,
+		Rule:       rule.Name,
+	}
+
+	req := rule.Job
+	req.Repo = rule.CloneURL
+	if req.Repo == "" {
+		req.Repo = cloneURL(p)
+	}
+	req.Ref = p.commit
+
+	delivery := ""
+	if p.deliveryID != "" {
+		delivery = strings.Join([]string{provider, p.deliveryID, rule.Name, p.branch}, "/")
+	}
+	// The delivery is recorded and the commit checked in the transaction storing the
+	// job, so concurrent deliveries cannot both queue it and a crash cannot record a
+	// delivery without its job
+	job, existing, err := s.submitUnless(req, func(j *Job) {
+		j.Trigger = trigger
+		j.Key = trigger.key()
+	}, func(j *Job) (*Job, error) {
+		return s.store.PutTriggered(j, delivery, s.now(), func(other *Job) bool {
+			return other.Trigger != nil && other.Trigger.key() == trigger.key() && other.Trigger.Commit == p.commit &&
+				other.Status != StatusFailed && other.Status != StatusCancelled
+		})
+	})
+	if errors.Is(err, errDuplicateDelivery) {
+		return "", "duplicate delivery " + p.deliveryID, nil
+	}
+	if err != nil {
+		// A failed delivery is retried by the provider and must not count as a duplicate
+		if delivery != "" {
+			if err := s.store.ForgetDelivery(delivery); err != nil {
+				s.logger.Warn("Failed to forget webhook delivery", "delivery", p.deliveryID, "error", err)
+			}
+		}
+		return "", "", err
+	}
+	if existing != nil {
+		return "", fmt.Sprintf("commit %s already has job %s", p.commit, existing.ID), nil
+	}
+	s.supersede(job)
+	return job.ID, "", nil
+}
+
+// supersede cancels the queued jobs of older pushes to the same branch
+func (s *Server) supersede(newer *Job) {
+	jobs, err := s.store.List()
+	if err != nil {
+		s.logger.Warn("Failed to look up superseded jobs", "error", err)
+		return
+	}
+	for _, j := range jobs {
+		if j.ID == newer.ID || j.Key != newer.Key || j.Status != StatusQueued || !j.CreatedAt.Before(newer.CreatedAt) {
+			continue
+		}
+		_, err := s.store.Update(j.ID, func(j *Job) error {
+			if j.Status != StatusQueued {
+				return errNotQueued
+			}
+			now := s.now()
+			j.Status = StatusCancelled
+			j.Error = fmt.Sprintf("superseded by commit %s (job %s)", newer.Trigger.Commit, newer.ID)
+			j.FinishedAt = &now
+			return nil
+		})
+		if err == nil {
+			s.logger.Info("Job superseded", "job", j.ID, "by", newer.ID)
+		} else if !errors.Is(err, errNotQueued) {
+			s.logger.Warn("Failed to cancel superseded job", "job", j.ID, "error", err)
+		}
+	}
+}
+
+// cloneURL picks the HTTPS clone URL of a pushed repository
+func cloneURL(p push) string {
+	for _, u := range p.urls {
+		if strings.HasPrefix(u, "https://") && strings.HasSuffix(u, ".git") {
+			return u
+		}
+	}
+	for _, u := range p.urls {
+		if strings.HasPrefix(u, "https://") {
+			return strings.TrimSuffix(u, "/") + ".git"
+		}
+	}
+	if len(p.urls) > 0 {
+		return p.urls[0]
+	}
+	return ""
+}
+
+// normalizeRepo reduces a repository URL or owner/name path to host/owner/name, or
+// owner/name when no host is given, in lower case
+func normalizeRepo(repo string) string {
+	repo = strings.ToLower(strings.TrimSpace(repo))
+	repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+
+	// scp-like SSH URLs: git@host:owner/name
+	if at := strings.Index(repo, "@"); at >= 0 && !strings.Contains(repo, "://") {
+		if host, path, ok := strings.Cut(repo[at+1:], ":"); ok {
+			return host + "/" + path
+		}
+	}
+	if u, err := url.Parse(repo); err == nil && u.Host != "" {
+		return u.Hostname() + "/" + strings.Trim(u.Path, "/")
+	}
+	return strings.Trim(repo, "/")
+}
@@ -0,0 +1,260 @@
+package source
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"cdk-deployer/pkg/extract"
+	"cdk-deployer/pkg/logging"
+)
+
+// archiveExts are the archive formats that can be extracted
+var archiveExts = []string{".tar.gz", ".tgz", ".zip"}
+
+// sha256Hex matches a hex-encoded SHA-256 checksum
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Archive extracts a .tar.gz or .zip archive from a local path or an http(s) URL
+type Archive struct {
+	location string
+	checksum string
+	opts     options
+}
+
+// NewArchive creates a source for an archive. A #sha256=<hex> suffix of location
+// sets the checksum the archive must match.
+func NewArchive(location string, opts ...Option) (*Archive, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newArchive(location, o)
+}
+
+// newArchive creates an archive source, splitting off the checksum
+func newArchive(location string, o options) (*Archive, error) {
+	location, fragment, _ := strings.Cut(location, "#")
+	a := &Archive{location: location, opts: o}
+	if fragment != "" {
+		checksum, ok := strings.CutPrefix(fragment, "sha256=")
+		checksum = strings.ToLower(checksum)
+		if !ok || !sha256Hex.MatchString(checksum) {
+			return nil, fmt.Errorf("invalid checksum %q of %s (use #sha256=<hex>)", fragment, location)
+		}
+		a.checksum = checksum
+	}
+	if !IsArchive(location) {
+		return nil, fmt.Errorf("unsupported archive %s (use .tar.gz, .tgz or .zip)", location)
+	}
+	if strings.HasPrefix(location, "http://") && a.checksum == "" {
+		return nil, fmt.Errorf("archive %s is fetched over unencrypted http and needs a #sha256=<hex> checksum", location)
+	}
+	return a, nil
+}
+
+// Fetch downloads the archive, verifies its checksum and extracts it below destDir.
+// The contents of an archive with a single top-level directory, as produced by
+// GitHub and GitLab source downloads, are used as the project root.
+func (a *Archive) Fetch(ctx context.Context, destDir string) (*Checkout, error) {
+	logger := logging.OrDefault(a.opts.logger)
+
+	logger.Info("Fetching archive", "location", a.location)
+	file, err := a.download(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file)
+
+	target, err := targetDir(destDir, a.name())
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Extracting archive", "path", target)
+
+	if strings.HasSuffix(strings.ToLower(a.path()), ".zip") {
+		err = extractZip(file, target)
+	} else {
+		err = extractTarGz(file, target)
+	}
+	if err != nil {
+		os.RemoveAll(target)
+		return nil, err
+	}
+
+	root, err := projectRoot(target)
+	if err != nil {
+		os.RemoveAll(target)
+		return nil, err
+	}
+	logger.Info("Archive extracted successfully")
+	return &Checkout{Path: root, dir: target}, nil
+}
+
+// String returns the location of the archive
+func (a *Archive) String() string {
+	return a.location
+}
+
+// path returns the location without the query of a URL
+func (a *Archive) path() string {
+	location, _, _ := strings.Cut(a.location, "?")
+	return location
+}
+
+// name returns the name of the archive without its extension
+func (a *Archive) name() string {
+	name := path.Base(a.path())
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// download copies the archive to a temporary file, checking its checksum on the way
+func (a *Archive) download(ctx context.Context) (string, error) {
+	r, err := a.open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "cdk-deployer-source-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to download archive: %w", err)
+	}
+
+	if a.checksum != "" {
+		if got := hex.EncodeToString(h.Sum(nil)); got != a.checksum {
+			os.Remove(tmp.Name())
+			return "", fmt.Errorf("archive %s does not match its checksum: expected %s, got %s", a.location, a.checksum, got)
+		}
+	}
+	return tmp.Name(), nil
+}
+
+// open opens the archive by path or URL
+func (a *Archive) open(ctx context.Context) (io.ReadCloser, error) {
+	if !strings.HasPrefix(a.location, "https://") && !strings.HasPrefix(a.location, "http://") {
+		f, err := os.Open(a.location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		return f, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive URL: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download archive: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download archive: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// extractTarGz extracts a gzipped tarball into dir
+func extractTarGz(file, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer gz.Close()
+	return extract.Tar(gz, dir)
+}
+
+// extractZip extracts a zip archive into dir
+func extractZip(file, dir string) error {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer zr.Close()
+
+	d, err := extract.New(dir)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = d.Mkdir(zf.Name)
+		case mode&os.ModeSymlink != 0:
+			err = extractZipSymlink(d, zf)
+		case mode.IsRegular():
+			var r io.ReadCloser
+			if r, err = zf.Open(); err == nil {
+				err = d.File(zf.Name, mode.Perm(), r)
+				r.Close()
+			}
+		default:
+			err = fmt.Errorf("archive has an unsupported entry %q", zf.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return d.Close()
+}
+
+// extractZipSymlink extracts a zip entry holding a symlink target
+func extractZipSymlink(d *extract.Dir, zf *zip.File) error {
+	r, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer r.Close()
+	link, err := io.ReadAll(io.LimitReader(r, 4096))
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	return d.Symlink(zf.Name, string(link))
+}
+
+// projectRoot returns the single top-level directory of an extracted archive, or
+// dir itself when the archive holds more than that
+func projectRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read extracted archive: %w", err)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("archive is empty")
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
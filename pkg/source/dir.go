@@ -0,0 +1,146 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"cdk-deployer/pkg/git"
+	"cdk-deployer/pkg/logging"
+)
+
+// skippedDirs are left out when a directory is copied: the git metadata, and the
+// cloud assembly of an earlier synthesis
+var skippedDirs = map[string]bool{".git": true, "cdk.out": true}
+
+// Dir provides a local directory, such as a working copy, either copied or in place
+type Dir struct {
+	path string
+	opts options
+}
+
+// NewDir creates a source for a local directory
+func NewDir(path string, opts ...Option) *Dir {
+	d := &Dir{path: path}
+	for _, opt := range opts {
+		opt(&d.opts)
+	}
+	return d
+}
+
+// Fetch copies the directory below destDir, or returns it as is when used in place.
+// When the directory is a git working copy, its HEAD commit is recorded.
+func (d *Dir) Fetch(ctx context.Context, destDir string) (*Checkout, error) {
+	logger := logging.OrDefault(d.opts.logger)
+
+	src, err := filepath.Abs(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory %s: %w", d.path, err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", d.path)
+	}
+
+	commit, err := git.HeadCommit(src)
+	if err != nil {
+		logger.Debug("Directory is not a git working copy", "path", src, "error", err)
+		commit = ""
+	}
+
+	if d.opts.inPlace {
+		logger.Info("Using directory in place", "path", src)
+		return &Checkout{Path: src, Commit: commit, InPlace: true}, nil
+	}
+
+	target, err := targetDir(destDir, filepath.Base(src))
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Copying directory", "path", src, "to", target)
+	if err := copyTree(ctx, src, target); err != nil {
+		os.RemoveAll(target)
+		return nil, err
+	}
+	logger.Info("Directory copied successfully")
+	return &Checkout{Path: target, Commit: commit}, nil
+}
+
+// String returns the path of the directory
+func (d *Dir) String() string {
+	if abs, err := filepath.Abs(d.path); err == nil {
+		return abs
+	}
+	return d.path
+}
+
+// copyTree copies the files, directories and symlinks of src to dst, leaving out
+// skippedDirs at the top level
+func copyTree(ctx context.Context, src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && skippedDirs[rel] {
+			return filepath.SkipDir
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", rel, err)
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", rel, err)
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", rel, err)
+			}
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", rel, err)
+			}
+			if err := copyFile(p, target, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", rel, err)
+			}
+		}
+		// Sockets, devices and the like are not part of a project
+		return nil
+	})
+}
+
+// copyFile copies a regular file
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
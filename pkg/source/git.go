@@ -0,0 +1,46 @@
+package source
+
+import (
+	"context"
+
+	"cdk-deployer/pkg/git"
+	"cdk-deployer/pkg/logging"
+)
+
+// Git clones a git repository
+type Git struct {
+	url  string
+	opts options
+}
+
+// NewGit creates a source cloning a git repository
+func NewGit(url string, opts ...Option) *Git {
+	g := &Git{url: url}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	return g
+}
+
+// Fetch clones the repository at the configured ref
+func (g *Git) Fetch(ctx context.Context, destDir string) (*Checkout, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	opts := []git.CloneOption{git.WithRef(g.opts.ref), git.WithLogger(g.opts.logger), git.WithEventHandler(g.opts.events)}
+	path, err := git.CloneRepositoryContext(ctx, g.url, destDir, append(opts, g.opts.clone...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := git.HeadCommit(path)
+	if err != nil {
+		logging.OrDefault(g.opts.logger).Warn("Failed to resolve the checked out commit", "error", err)
+	}
+	return &Checkout{Path: path, Commit: commit}, nil
+}
+
+// String returns the repository URL without credentials
+func (g *Git) String() string {
+	return git.RedactURL(g.url)
+}
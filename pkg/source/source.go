@@ -0,0 +1,181 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cdk-deployer/pkg/event"
+	"cdk-deployer/pkg/git"
+)
+
+// Source provides the files of a CDK project
+type Source interface {
+	// Fetch makes the project available below destDir, or below a new temporary
+	// directory when destDir is empty
+	Fetch(ctx context.Context, destDir string) (*Checkout, error)
+	// String returns the location of the source, as recorded in the history
+	String() string
+}
+
+// Checkout is a project fetched from a source
+type Checkout struct {
+	// Path is the root directory of the project
+	Path string
+	// Commit is the SHA of the checked out commit, when it is known
+	Commit string
+	// InPlace is set when Path is a directory of the user that Cleanup leaves alone
+	InPlace bool
+
+	// dir is the directory removed by Cleanup when it is not Path itself
+	dir string
+}
+
+// Cleanup removes the fetched files; a directory used in place is kept
+func (c *Checkout) Cleanup() error {
+	if c.InPlace {
+		return nil
+	}
+	if c.dir != "" {
+		return os.RemoveAll(c.dir)
+	}
+	return os.RemoveAll(c.Path)
+}
+
+// options holds the settings shared by all sources
+type options struct {
+	ref     string
+	inPlace bool
+	logger  *slog.Logger
+	events  event.Handler
+	clone   []git.CloneOption
+}
+
+// Option configures a source
+type Option func(*options)
+
+// WithRef checks out a branch, tag or commit SHA of a git source
+func WithRef(ref string) Option {
+	return func(o *options) {
+		o.ref = ref
+	}
+}
+
+// WithInPlace uses a local directory as is instead of copying it
+func WithInPlace(inPlace bool) Option {
+	return func(o *options) {
+		o.inPlace = inPlace
+	}
+}
+
+// WithCloneOptions adds options to the clone of a git source, e.g. a clone cache
+func WithCloneOptions(opts ...git.CloneOption) Option {
+	return func(o *options) {
+		o.clone = append(o.clone, opts...)
+	}
+}
+
+// WithLogger sets the logger for fetch progress
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithEventHandler sets the handler receiving clone progress events
+func WithEventHandler(h event.Handler) Option {
+	return func(o *options) {
+		o.events = h
+	}
+}
+
+// Parse selects the source of a -source value by its scheme:
+//
+//   - git+https://, git+ssh://, git+file:// and plain git URLs such as https://,
+//     ssh:// or git@host:org/repo clone a git repository
+//   - dir:<path>, or the path of an existing directory, copies a local directory,
+//     or uses it in place with WithInPlace
+//   - archive:<path or URL>, or a path or http(s) URL ending in .tar.gz, .tgz or
+//     .zip, extracts an archive. A #sha256=<hex> suffix verifies its checksum.
+func Parse(spec string, opts ...Option) (Source, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if spec == "" {
+		return nil, fmt.Errorf("a source is required")
+	}
+
+	var src Source
+	switch {
+	case strings.HasPrefix(spec, "git+"):
+		src = &Git{url: strings.TrimPrefix(spec, "git+"), opts: o}
+	case strings.HasPrefix(spec, "dir:"):
+		src = &Dir{path: strings.TrimPrefix(spec, "dir:"), opts: o}
+	case strings.HasPrefix(spec, "archive:"):
+		archive, err := newArchive(strings.TrimPrefix(spec, "archive:"), o)
+		if err != nil {
+			return nil, err
+		}
+		src = archive
+	case IsArchive(spec):
+		archive, err := newArchive(spec, o)
+		if err != nil {
+			return nil, err
+		}
+		src = archive
+	case isLocalDir(spec):
+		src = &Dir{path: spec, opts: o}
+	default:
+		src = &Git{url: spec, opts: o}
+	}
+
+	if _, ok := src.(*Git); !ok && o.ref != "" {
+		return nil, fmt.Errorf("a ref only applies to git sources, not %s", src)
+	}
+	if _, ok := src.(*Dir); !ok && o.inPlace {
+		return nil, fmt.Errorf("only local directories can be used in place, not %s", src)
+	}
+	return src, nil
+}
+
+// IsArchive reports whether a location names a .tar.gz, .tgz or .zip archive
+func IsArchive(location string) bool {
+	location, _, _ = strings.Cut(location, "#")
+	location, _, _ = strings.Cut(location, "?")
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(strings.ToLower(location), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalDir reports whether spec is the path of an existing directory rather than
+// a URL
+func isLocalDir(spec string) bool {
+	if strings.Contains(spec, "://") {
+		return false
+	}
+	info, err := os.Stat(spec)
+	return err == nil && info.IsDir()
+}
+
+// targetDir returns the directory a source named name is fetched to, creating a
+// temporary parent directory when destDir is empty
+func targetDir(destDir, name string) (string, error) {
+	if destDir == "" {
+		tmpDir, err := os.MkdirTemp("", "cdk-deployer-*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		destDir = tmpDir
+	}
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "source"
+	}
+	return filepath.Join(destDir, name), nil
+}
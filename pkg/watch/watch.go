@@ -0,0 +1,402 @@
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cdk-deployer/pkg/alert"
+	"cdk-deployer/pkg/cdk"
+)
+
+const (
+	// statusDetectionFailed is recorded when drift detection could not run for a stack
+	statusDetectionFailed = "DETECTION_FAILED"
+	// driftStatusInSync is the status of a stack without reportable drift
+	driftStatusInSync = "IN_SYNC"
+)
+
+// Target is a set of stacks watched in one region
+type Target struct {
+	// Region defaults to the region of the AWS configuration when empty
+	Region string   `json:"region"`
+	Stacks []string `json:"stacks"`
+}
+
+// Config is the content of a drift watch configuration file
+type Config struct {
+	Interval     Duration           `json:"interval"`
+	StateFile    string             `json:"stateFile"`
+	IgnoreFile   string             `json:"ignoreFile"`
+	BaselineFile string             `json:"baselineFile"`
+	Targets      []Target           `json:"targets"`
+	Alerts       []alert.SinkConfig `json:"alerts"`
+}
+
+// Duration is a time.Duration encoded as a Go duration string in JSON
+type Duration time.Duration
+
+// UnmarshalJSON parses durations such as "1h" or "30m"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads a drift watch configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watch config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse watch config: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// State is the persisted result of the previous watch iteration, keyed by region/stack
+type State struct {
+	Stacks map[string]StackState `json:"stacks"`
+}
+
+// StackState records the last observed drift status of a stack
+type StackState struct {
+	Status      string    `json:"status"`
+	Fingerprint string    `json:"fingerprint"`
+	CheckedAt   time.Time `json:"checkedAt"`
+}
+
+// detector detects the drift of stacks in one region; *cdk.Deployer implements it
+type detector interface {
+	DetectDrift(ctx context.Context, stackName string) (*cdk.DriftResult, error)
+	Region() string
+}
+
+// Watcher periodically detects drift and alerts on status changes
+type Watcher struct {
+	cfg       Config
+	sinks     []alert.Sink
+	ignore    *cdk.DriftIgnoreRules
+	baseline  *cdk.DriftBaseline
+	deployers map[string]detector
+	logger    *slog.Logger
+
+	// newDeployer creates the detector of a region
+	newDeployer func(ctx context.Context, region string) (detector, error)
+}
+
+// Option configures a Watcher
+type Option func(*Watcher)
+
+// WithLogger sets the logger for watch progress
+func WithLogger(logger *slog.Logger) Option {
+	return func(w *Watcher) {
+		w.logger = logger
+	}
+}
+
+// New creates a watcher from its configuration
+func New(cfg Config, opts ...Option) (*Watcher, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("no watch targets configured")
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("watch interval must be positive")
+	}
+	if cfg.StateFile == "" {
+		cfg.StateFile = "drift-watch-state.json"
+	}
+
+	w := &Watcher{
+		cfg:       cfg,
+		deployers: make(map[string]detector),
+		logger:    slog.Default(),
+	}
+	w.newDeployer = w.connect
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	if len(cfg.Alerts) == 0 {
+		cfg.Alerts = []alert.SinkConfig{{Type: "stdout"}}
+	}
+	for _, sc := range cfg.Alerts {
+		sink, err := alert.NewSink(sc)
+		if err != nil {
+			return nil, err
+		}
+		w.sinks = append(w.sinks, sink)
+	}
+
+	if cfg.IgnoreFile != "" {
+		rules, err := cdk.LoadDriftIgnoreRules(cfg.IgnoreFile)
+		if err != nil {
+			return nil, err
+		}
+		w.ignore = rules
+	}
+	if cfg.BaselineFile != "" {
+		baseline, err := cdk.LoadDriftBaseline(cfg.BaselineFile)
+		if err != nil {
+			return nil, err
+		}
+		w.baseline = baseline
+	}
+
+	return w, nil
+}
+
+// Run checks all targets immediately and then once per interval until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) error {
+	w.logger.Info("Watching drift", "interval", time.Duration(w.cfg.Interval).String(), "state", w.cfg.StateFile)
+
+	ticker := time.NewTicker(time.Duration(w.cfg.Interval))
+	defer ticker.Stop()
+
+	for {
+		if err := w.RunOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			w.logger.Warn("Drift watch iteration failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce detects drift for every target, compares it with the saved state and sends
+// alerts. A target that fails does not stop the others; the errors are joined.
+func (w *Watcher) RunOnce(ctx context.Context) error {
+	state, err := loadState(w.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+
+	w.logger.Info("Running drift detection")
+
+	var errs []error
+	for _, target := range w.cfg.Targets {
+		deployer, err := w.deployer(ctx, target.Region)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to watch region %s: %w", orDefaultRegion(target.Region), err))
+			continue
+		}
+		region := deployer.Region()
+
+		for _, stackName := range target.Stacks {
+			current := w.check(ctx, deployer, stackName)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			key := region + "/" + stackName
+			previous, seen := state.Stacks[key]
+			state.Stacks[key] = current.state
+
+			if seen && previous.Status == current.state.Status && previous.Fingerprint == current.state.Fingerprint {
+				continue
+			}
+
+			previousStatus := previous.Status
+			if !seen {
+				previousStatus = "UNKNOWN"
+				// A first observation of an in-sync stack is not worth an alert
+				if current.state.Status == driftStatusInSync {
+					continue
+				}
+			}
+
+			w.send(ctx, alert.Alert{
+				Time:           current.state.CheckedAt,
+				Region:         region,
+				StackName:      stackName,
+				PreviousStatus: previousStatus,
+				Status:         current.state.Status,
+				Message:        current.message(previousStatus),
+				Resources:      current.resources,
+			})
+		}
+	}
+
+	if err := saveState(w.cfg.StateFile, state); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// orDefaultRegion names the region of a target for messages
+func orDefaultRegion(region string) string {
+	if region == "" {
+		return "(default)"
+	}
+	return region
+}
+
+// observation is the outcome of checking a single stack
+type observation struct {
+	state     StackState
+	resources []alert.Resource
+	err       error
+}
+
+// message describes the status change for an alert
+func (o observation) message(previousStatus string) string {
+	if o.err != nil {
+		return fmt.Sprintf("drift detection failed: %v", o.err)
+	}
+	if o.state.Status == previousStatus {
+		return fmt.Sprintf("drifted resources changed (%d drifted)", len(o.resources))
+	}
+	return fmt.Sprintf("drift status changed from %s to %s (%d drifted)", previousStatus, o.state.Status, len(o.resources))
+}
+
+// check detects drift for one stack and reduces it to its reportable status
+func (w *Watcher) check(ctx context.Context, deployer detector, stackName string) observation {
+	obs := observation{
+		state: StackState{CheckedAt: time.Now().UTC()},
+	}
+
+	result, err := deployer.DetectDrift(ctx, stackName)
+	if err != nil {
+		obs.err = err
+		obs.state.Status = statusDetectionFailed
+		return obs
+	}
+
+	results := []cdk.DriftResult{*result}
+	if w.ignore != nil {
+		w.ignore.Apply(results, time.Now())
+	}
+	if w.baseline != nil {
+		w.baseline.Apply(results)
+	}
+
+	var ids []string
+	for _, dr := range results[0].DriftedResources {
+		// Resources CloudFormation could not check say nothing about drift
+		if !dr.Reportable() || dr.DriftStatus == "NOT_CHECKED" {
+			continue
+		}
+		obs.resources = append(obs.resources, alert.Resource{
+			LogicalID:    dr.LogicalID,
+			PhysicalID:   dr.PhysicalID,
+			ResourceType: dr.ResourceType,
+			DriftStatus:  dr.DriftStatus,
+		})
+		ids = append(ids, dr.LogicalID+":"+dr.DriftStatus)
+	}
+
+	obs.state.Status = result.DriftStatus
+	if len(obs.resources) == 0 && result.DriftStatus == "DRIFTED" {
+		// Everything that drifted is suppressed or already known
+		obs.state.Status = driftStatusInSync
+	}
+
+	if len(ids) > 0 {
+		sort.Strings(ids)
+		sum := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+		obs.state.Fingerprint = hex.EncodeToString(sum[:])
+	}
+
+	return obs
+}
+
+// deployer returns a cached deployer for a region
+func (w *Watcher) deployer(ctx context.Context, region string) (detector, error) {
+	if d, ok := w.deployers[region]; ok {
+		return d, nil
+	}
+
+	d, err := w.newDeployer(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	w.deployers[region] = d
+	return d, nil
+}
+
+// connect creates the deployer of a region
+func (w *Watcher) connect(ctx context.Context, region string) (detector, error) {
+	opts := []cdk.DeployerOption{cdk.WithDeployerLogger(w.logger)}
+	if region != "" {
+		opts = append(opts, cdk.WithRegion(region))
+	}
+	return cdk.NewDeployer(ctx, nil, opts...)
+}
+
+// send delivers an alert to every sink, reporting but not failing on sink errors
+func (w *Watcher) send(ctx context.Context, a alert.Alert) {
+	for _, sink := range w.sinks {
+		if err := sink.Send(ctx, a); err != nil {
+			w.logger.Warn("Failed to send alert", "stack", a.StackName, "error", err)
+		}
+	}
+}
+
+// loadState reads the previous watch state, returning an empty state if none exists
+func loadState(path string) (*State, error) {
+	state := &State{Stacks: make(map[string]StackState)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watch state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse watch state: %w", err)
+	}
+	if state.Stacks == nil {
+		state.Stacks = make(map[string]StackState)
+	}
+
+	return state, nil
+}
+
+// saveState atomically writes the watch state
+func saveState(path string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode watch state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write watch state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write watch state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"cdk-deployer/pkg/alert"
+	"cdk-deployer/pkg/cdk"
+)
+
+// fakeDetector returns canned drift results for the stacks of one region
+type fakeDetector struct {
+	region  string
+	results map[string]cdk.DriftResult
+}
+
+func (f *fakeDetector) DetectDrift(_ context.Context, stackName string) (*cdk.DriftResult, error) {
+	result, ok := f.results[stackName]
+	if !ok {
+		return nil, errors.New("stack " + stackName + " does not exist")
+	}
+	return &result, nil
+}
+
+func (f *fakeDetector) Region() string {
+	return f.region
+}
+
+// alertServer is a local stand-in for a webhook alert receiver
+type alertServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	alerts []alert.Alert
+}
+
+func newAlertServer(t *testing.T) *alertServer {
+	t.Helper()
+	s := &alertServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a alert.Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.alerts = append(s.alerts, a)
+		s.mu.Unlock()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *alertServer) received() []alert.Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]alert.Alert(nil), s.alerts...)
+}
+
+// newTestWatcher creates a watcher whose regions are served by the given detectors;
+// other regions fail to connect
+func newTestWatcher(t *testing.T, cfg Config, detectors ...*fakeDetector) *Watcher {
+	t.Helper()
+	if cfg.Interval == 0 {
+		cfg.Interval = Duration(1)
+	}
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+	w, err := New(cfg, WithLogger(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.newDeployer = func(_ context.Context, region string) (detector, error) {
+		for _, d := range detectors {
+			if d.region == region {
+				return d, nil
+			}
+		}
+		return nil, errors.New("no credentials for region " + region)
+	}
+	return w
+}
+
+func driftedBucket(stackName string) cdk.DriftResult {
+	return cdk.DriftResult{
+		StackName:   stackName,
+		DriftStatus: "DRIFTED",
+		DriftedResources: []cdk.DriftedResource{{
+			LogicalID:    "Bucket",
+			PhysicalID:   "app-bucket",
+			ResourceType: "AWS::S3::Bucket",
+			DriftStatus:  "MODIFIED",
+			PropertyDiffs: []cdk.PropertyDiff{{
+				PropertyPath:   "/VersioningConfiguration/Status",
+				ExpectedValue:  "Enabled",
+				ActualValue:    "Suspended",
+				DifferenceType: "NOT_EQUAL",
+			}},
+		}},
+	}
+}
+
+func TestRunOnceWatchesOtherTargetsWhenOneFails(t *testing.T) {
+	receiver := newAlertServer(t)
+	w := newTestWatcher(t, Config{
+		Targets: []Target{
+			{Region: "eu-west-1", Stacks: []string{"Broken"}},
+			{Region: "us-east-1", Stacks: []string{"App"}},
+		},
+		Alerts: []alert.SinkConfig{{Type: "webhook", URL: receiver.URL}},
+	}, &fakeDetector{region: "us-east-1", results: map[string]cdk.DriftResult{"App": driftedBucket("App")}})
+
+	err := w.RunOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "eu-west-1") {
+		t.Fatalf("RunOnce error = %v, want the failure of eu-west-1", err)
+	}
+
+	alerts := receiver.received()
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1: %+v", len(alerts), alerts)
+	}
+	if a := alerts[0]; a.Region != "us-east-1" || a.StackName != "App" || a.Status != "DRIFTED" || a.PreviousStatus != "UNKNOWN" {
+		t.Errorf("unexpected alert %+v", a)
+	}
+
+	state, err := loadState(w.cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.Stacks["us-east-1/App"].Status; got != "DRIFTED" {
+		t.Errorf("saved status of us-east-1/App = %q, want DRIFTED", got)
+	}
+
+	// The unchanged drift is not alerted again, and the failing target is retried
+	if err := w.RunOnce(context.Background()); err == nil {
+		t.Error("second RunOnce succeeded, want the failure of eu-west-1 again")
+	}
+	if alerts := receiver.received(); len(alerts) != 1 {
+		t.Errorf("got %d alerts after the second run, want 1", len(alerts))
+	}
+}
+
+func TestRunOnceWarnsAboutExpiredIgnoreRuleOnce(t *testing.T) {
+	ignoreFile := filepath.Join(t.TempDir(), "drift-ignore.json")
+	rules := `{"rules": [{"stack": "App", "logicalId": "Bucket", "reason": "migration", "expires": "2020-01-31"}]}`
+	if err := os.WriteFile(ignoreFile, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired rules are reported through the default logger
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	receiver := newAlertServer(t)
+	w := newTestWatcher(t, Config{
+		IgnoreFile: ignoreFile,
+		Targets:    []Target{{Region: "us-east-1", Stacks: []string{"App"}}},
+		Alerts:     []alert.SinkConfig{{Type: "webhook", URL: receiver.URL}},
+	}, &fakeDetector{region: "us-east-1", results: map[string]cdk.DriftResult{"App": driftedBucket("App")}})
+
+	for i := 0; i < 3; i++ {
+		if err := w.RunOnce(context.Background()); err != nil {
+			t.Fatalf("RunOnce %d: %v", i+1, err)
+		}
+	}
+
+	if n := strings.Count(logs.String(), "Drift ignore rule expired"); n != 1 {
+		t.Errorf("expired rule warned %d times, want once:\n%s", n, logs.String())
+	}
+	// The expired rule no longer suppresses the drift
+	if alerts := receiver.received(); len(alerts) != 1 || alerts[0].Status != "DRIFTED" {
+		t.Errorf("got alerts %+v, want one DRIFTED alert", alerts)
+	}
+}